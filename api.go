@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bpouw/gopherseerr/store"
+)
+
+// The /api/v1 handlers mirror the HTML pages for scripts and bots. They
+// authenticate with a session cookie or a user's X-Api-Key header, and always
+// answer with JSON, including errors: {"error": "..."}.
+
+func registerAPIRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/search", requireLogin(handle((*server).apiSearch)))
+	mux.HandleFunc("GET /api/v1/movie/{tmdb_id}", requireLogin(handle((*server).apiMovieDetails)))
+	mux.HandleFunc("GET /api/v1/tv/{tmdb_id}", requireLogin(handle((*server).apiShowDetails)))
+	mux.HandleFunc("GET /api/v1/tv/{tmdb_id}/season/{season}", requireLogin(handle((*server).apiSeasonEpisodes)))
+	mux.HandleFunc("GET /api/v1/requests", requireLogin(handle((*server).apiListRequests)))
+	mux.HandleFunc("POST /api/v1/requests", requireLogin(handle((*server).apiCreateRequest)))
+	mux.HandleFunc("POST /api/v1/requests/plan", requireLogin(handle((*server).apiPlanRequest)))
+	mux.HandleFunc("GET /api/v1/requests/{id}", requireLogin(handle((*server).apiGetRequest)))
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, "unknown API endpoint")
+	})
+}
+
+type apiError struct {
+	Error   string         `json:"error"`
+	Request *store.Request `json:"request,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+func pathInt(r *http.Request, name string) (int, error) {
+	n, err := strconv.Atoi(r.PathValue(name))
+	if err != nil {
+		return 0, errors.New("invalid " + name)
+	}
+	return n, nil
+}
+
+func (s *server) apiSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing query parameter q")
+		return
+	}
+	results, err := s.tmdb.Search(r.Context(), q)
+	if err != nil {
+		writeJSONUpstreamError(w, err, http.StatusBadGateway, "TMDB search error: ")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.enrichSearchResults(r.Context(), results))
+}
+
+func (s *server) apiMovieDetails(w http.ResponseWriter, r *http.Request) {
+	tmdbID, err := pathInt(r, "tmdb_id")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	details, err := s.tmdb.GetMovieDetails(r.Context(), tmdbID)
+	if err != nil {
+		writeJSONUpstreamError(w, err, http.StatusBadGateway, "failed to get movie details from TMDB: ")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.enrichMovieDetails(r.Context(), details))
+}
+
+func (s *server) apiShowDetails(w http.ResponseWriter, r *http.Request) {
+	tmdbID, err := pathInt(r, "tmdb_id")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	details, err := s.tmdb.GetTVShowDetails(r.Context(), tmdbID)
+	if err != nil {
+		writeJSONUpstreamError(w, err, http.StatusBadGateway, "failed to get show details from TMDB: ")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.enrichShowDetails(r.Context(), details))
+}
+
+func (s *server) apiSeasonEpisodes(w http.ResponseWriter, r *http.Request) {
+	tmdbID, err := pathInt(r, "tmdb_id")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	seasonNumber, err := pathInt(r, "season")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	details, err := s.tmdb.GetSeasonDetails(r.Context(), tmdbID, seasonNumber)
+	if err != nil {
+		writeJSONUpstreamError(w, err, http.StatusBadGateway, "failed to get season details from TMDB: ")
+		return
+	}
+	writeJSON(w, http.StatusOK, details.Episodes)
+}
+
+func (s *server) apiListRequests(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseRequestFilter(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, s.requests.List(filter))
+}
+
+func (s *server) apiGetRequest(w http.ResponseWriter, r *http.Request) {
+	id, err := pathInt(r, "id")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req, err := s.requests.Get(id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "request not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, req)
+}
+
+// apiRequestBody is the payload of POST /api/v1/requests. It carries the same
+// fields as the HTML request forms.
+type apiRequestBody struct {
+	Type           string          `json:"type"` // "movie" or "tv"
+	TMDBID         int             `json:"tmdb_id"`
+	RequestType    string          `json:"request_type"` // "full_show", "season", "episode" or "batch"
+	SeasonNumber   int             `json:"season_number"`
+	EpisodeNumber  int             `json:"episode_number"`
+	Seasons        []int           `json:"seasons"`  // batch only
+	Episodes       []store.Episode `json:"episodes"` // batch only
+	QualityProfile int             `json:"quality_profile"`
+	RootFolder     string          `json:"root_folder"`
+	Availability   string          `json:"minimum_availability"` // movies only: "announced", "inCinemas" or "released"
+	Server         string          `json:"server"`               // a radarr_servers/sonarr_servers name, empty picks one
+}
+
+type apiRequestResponse struct {
+	Message string        `json:"message"`
+	Request store.Request `json:"request"`
+}
+
+// decodeRequestBody reads and validates an apiRequestBody, writing the error
+// response itself when it returns false.
+func decodeRequestBody(w http.ResponseWriter, r *http.Request) (store.Request, bool) {
+	var body apiRequestBody
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return store.Request{}, false
+	}
+
+	req, err := validateRequest(r, store.Request{
+		TMDBID:           body.TMDBID,
+		MediaType:        body.Type,
+		RequestType:      body.RequestType,
+		SeasonNumber:     body.SeasonNumber,
+		EpisodeNumber:    body.EpisodeNumber,
+		Seasons:          body.Seasons,
+		Episodes:         body.Episodes,
+		QualityProfileID: body.QualityProfile,
+		RootFolder:       body.RootFolder,
+		Availability:     body.Availability,
+		Server:           body.Server,
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return req, false
+	}
+	return req, true
+}
+
+func (s *server) apiCreateRequest(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeRequestBody(w, r)
+	if !ok {
+		return
+	}
+	rec, message, err := s.submitRequest(r, req)
+	if err != nil {
+		status, message := requestError(err, http.StatusBadGateway)
+		writeJSON(w, status, apiError{Error: message, Request: &rec})
+		return
+	}
+	status := http.StatusCreated
+	if rec.Status == store.StatusPending {
+		status = http.StatusAccepted
+	}
+	writeJSON(w, status, apiRequestResponse{Message: message, Request: rec})
+}
+
+// apiPlanRequest is a dry run of POST /api/v1/requests for TV requests: it
+// returns the Sonarr operations the request would perform, without
+// recording or changing anything.
+func (s *server) apiPlanRequest(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeRequestBody(w, r)
+	if !ok {
+		return
+	}
+	if req.MediaType != "tv" {
+		writeJSONError(w, http.StatusBadRequest, "only TV requests can be planned")
+		return
+	}
+	plan, err := s.planRequest(r.Context(), req)
+	if err != nil {
+		status, message := requestError(err, http.StatusBadGateway)
+		writeJSONError(w, status, message)
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpouw/gopherseerr/store"
+)
+
+func postJSON(target, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func TestAPICreateRequestTV(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		approval    bool
+		wantStatus  int
+		wantRequest store.Request
+		wantCalls   []string
+	}{
+		{
+			name:        "full show",
+			body:        `{"type": "tv", "tmdb_id": 1396, "request_type": "full_show"}`,
+			wantStatus:  http.StatusCreated,
+			wantRequest: store.Request{TMDBID: 1396, MediaType: "tv", RequestType: "full_show", Status: store.StatusSubmitted},
+			wantCalls:   []string{"FindSeriesByTMDB", "LookupSeries", "GetQualityProfiles", "GetRootFolders", "GetLanguageProfiles", "AddSeries"},
+		},
+		{
+			name:        "season",
+			body:        `{"type": "tv", "tmdb_id": 1396, "request_type": "season", "season_number": 2, "episode_number": 4}`,
+			wantStatus:  http.StatusCreated,
+			wantRequest: store.Request{TMDBID: 1396, MediaType: "tv", RequestType: "season", SeasonNumber: 2, Status: store.StatusSubmitted},
+			wantCalls:   []string{"FindSeriesByTMDB", "GetEpisodes", "GetSeries", "UpdateSeries", "SearchEpisodes"},
+		},
+		{
+			name:        "episode",
+			body:        `{"type": "tv", "tmdb_id": 1396, "request_type": "episode", "season_number": 2, "episode_number": 1}`,
+			wantStatus:  http.StatusCreated,
+			wantRequest: store.Request{TMDBID: 1396, MediaType: "tv", RequestType: "episode", SeasonNumber: 2, EpisodeNumber: 1, Status: store.StatusSubmitted},
+			wantCalls:   []string{"FindSeriesByTMDB", "GetEpisodes", "MonitorEpisodes", "InvalidateSeries", "SearchEpisodes"},
+		},
+		{
+			name:        "waits for approval",
+			body:        `{"type": "tv", "tmdb_id": 1396, "request_type": "season", "season_number": 2}`,
+			approval:    true,
+			wantStatus:  http.StatusAccepted,
+			wantRequest: store.Request{TMDBID: 1396, MediaType: "tv", RequestType: "season", SeasonNumber: 2, Status: store.StatusPending},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.config.RequireApproval = tt.approval
+			ts.addBreakingBad(tt.name != "full show")
+
+			w := ts.do((*server).apiCreateRequest, postJSON("/api/v1/requests", tt.body))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			var resp apiRequestResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			got := resp.Request
+			got.ID, got.CreatedAt, got.UpdatedAt = 0, tt.wantRequest.CreatedAt, tt.wantRequest.UpdatedAt
+			if !reflect.DeepEqual(got, tt.wantRequest) {
+				t.Errorf("request = %+v\nwant %+v", got, tt.wantRequest)
+			}
+			if calls := ts.sonarr.Calls(); !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("Sonarr calls = %v\nwant %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestAPICreateRequestRejected(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{"unknown field", `{"type": "tv", "tmdb_id": 1396, "request_type": "full_show", "seson": 1}`, http.StatusBadRequest, "invalid JSON body"},
+		{"unsupported request type", `{"type": "tv", "tmdb_id": 1396, "request_type": "special"}`, http.StatusBadRequest, "Unsupported TV request type"},
+		{"empty batch", `{"type": "tv", "tmdb_id": 1396, "request_type": "batch"}`, http.StatusBadRequest, "Select at least one season or episode"},
+		{"unknown episode", `{"type": "tv", "tmdb_id": 1396, "request_type": "episode", "season_number": 1, "episode_number": 9}`, http.StatusBadGateway, "could not find S01E09 in Sonarr"},
+		{"unknown show", `{"type": "tv", "tmdb_id": 1, "request_type": "full_show"}`, http.StatusBadGateway, "no series found for tmdb id 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.addBreakingBad(true)
+			w := ts.do((*server).apiCreateRequest, postJSON("/api/v1/requests", tt.body))
+			var resp apiError
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if w.Code != tt.wantStatus || !strings.Contains(resp.Error, tt.wantError) {
+				t.Errorf("got %d %q, want %d %q", w.Code, resp.Error, tt.wantStatus, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestAPIPlanRequest(t *testing.T) {
+	ts := newTestServer(t)
+	ts.addBreakingBad(true)
+	w := ts.do((*server).apiPlanRequest, postJSON("/api/v1/requests/plan",
+		`{"type": "tv", "tmdb_id": 1396, "request_type": "batch", "seasons": [2], "episodes": [{"season": 1, "episode": 2}]}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var plan tvPlan
+	if err := json.Unmarshal(w.Body.Bytes(), &plan); err != nil {
+		t.Fatal(err)
+	}
+	want := []planStep{
+		{Op: opMonitorSeasons, Seasons: []int{2}},
+		{Op: opSearchEpisodes, Episodes: []episodeRef{{2, 1, 21}, {2, 2, 22}, {1, 2, 12}}},
+	}
+	if !reflect.DeepEqual(plan.Steps, want) {
+		t.Errorf("steps = %+v\nwant %+v", plan.Steps, want)
+	}
+	for _, call := range ts.sonarr.Calls() {
+		if call == "UpdateSeries" || call == "SearchEpisodes" || call == "MonitorEpisodes" {
+			t.Errorf("the dry run called %s", call)
+		}
+	}
+	if len(ts.requests.List(store.Filter{})) != 0 {
+		t.Error("the dry run recorded a request")
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bpouw/gopherseerr/notify"
+	"github.com/bpouw/gopherseerr/store"
+)
+
+// needsApproval reports whether a request made by the current user has to
+// wait in the approval queue. Admins are never queued.
+func needsApproval(r *http.Request) bool {
+	if !serverFor(r).config.RequireApproval {
+		return false
+	}
+	u := currentUser(r)
+	return u == nil || !u.Admin
+}
+
+func (s *server) handleApprovalQueue(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Pending []store.Request
+		Recent  []store.Request
+	}{
+		Pending: s.requests.List(store.Filter{Status: store.StatusPending}),
+		Recent:  s.reviewedRequests(20),
+	}
+	if err := s.templates.ExecuteTemplate(w, "approvals.gohtml", data); err != nil {
+		http.Error(w, "Failed to render template: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// reviewedRequests returns the most recently created requests that an admin
+// has acted on.
+func (s *server) reviewedRequests(limit int) []store.Request {
+	var out []store.Request
+	for _, req := range s.requests.List(store.Filter{}) {
+		if req.ReviewedBy == "" {
+			continue
+		}
+		out = append(out, req)
+		if len(out) == limit {
+			break
+		}
+	}
+	return out
+}
+
+func (s *server) handleApproveRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/requests", http.StatusSeeOther)
+		return
+	}
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	req, err := s.approveRequest(r.Context(), id, currentUsername(r))
+	if err != nil {
+		writeReviewError(w, err)
+		return
+	}
+	if req.Status == store.StatusFailed {
+		showPopupAndRedirect(w, "Request approved, but it failed: "+req.Error, "/admin/requests")
+		return
+	}
+	showPopupAndRedirect(w, fmt.Sprintf("Request #%d approved and submitted.", req.ID), "/admin/requests")
+}
+
+func (s *server) handleDenyRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/admin/requests", http.StatusSeeOther)
+		return
+	}
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	req, err := s.denyRequest(id, currentUsername(r), strings.TrimSpace(r.FormValue("reason")))
+	if err != nil {
+		writeReviewError(w, err)
+		return
+	}
+	showPopupAndRedirect(w, fmt.Sprintf("Request #%d denied.", req.ID), "/admin/requests")
+}
+
+func writeReviewError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Request not found", http.StatusNotFound)
+	case errors.Is(err, store.ErrStatusChanged):
+		http.Error(w, "Request is no longer pending", http.StatusConflict)
+	default:
+		http.Error(w, "Failed to update request: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// approveRequest moves a pending request out of the queue and runs it
+// through the same logic as an unmoderated request.
+func (s *server) approveRequest(ctx context.Context, id int, reviewer string) (store.Request, error) {
+	now := time.Now().UTC()
+	req, err := s.requests.Transition(id, store.StatusPending, func(req *store.Request) {
+		req.Status = store.StatusApproved
+		req.ReviewedBy = reviewer
+		req.ReviewedAt = &now
+	})
+	if err != nil {
+		return req, err
+	}
+
+	log.Printf("Request #%d approved by %s, submitting...", id, reviewer)
+	s.notifyRequest(notify.EventApproved, req)
+	_, errAdd := s.executeRequest(ctx, &req)
+	server := req.Server
+	req, err = s.requests.Update(id, func(req *store.Request) {
+		req.Server = server
+		req.Status = store.StatusSubmitted
+		if errAdd != nil {
+			req.Status = store.StatusFailed
+			req.Error = errAdd.Error()
+		}
+	})
+	if err == nil && req.Status == store.StatusFailed {
+		s.notifyRequest(notify.EventFailed, req)
+	}
+	return req, err
+}
+
+func (s *server) denyRequest(id int, reviewer, reason string) (store.Request, error) {
+	now := time.Now().UTC()
+	req, err := s.requests.Transition(id, store.StatusPending, func(req *store.Request) {
+		req.Status = store.StatusDenied
+		req.ReviewedBy = reviewer
+		req.ReviewedAt = &now
+		req.DenyReason = reason
+	})
+	if err == nil {
+		s.notifyRequest(notify.EventDenied, req)
+	}
+	return req, err
+}
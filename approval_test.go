@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bpouw/gopherseerr/radarr"
+	"github.com/bpouw/gopherseerr/store"
+)
+
+// review posts the approve or deny form for the request with the ID as the
+// admin user.
+func (ts *testServer) review(h func(*server, http.ResponseWriter, *http.Request), id int, reason string) *httptest.ResponseRecorder {
+	r := postForm("/admin/requests", url.Values{"id": {strconv.Itoa(id)}, "reason": {reason}})
+	r = r.WithContext(context.WithValue(r.Context(), userContextKey, &User{Username: "admin", Admin: true}))
+	return ts.do(h, r)
+}
+
+func pendingMovie(t *testing.T, ts *testServer) int {
+	t.Helper()
+	return ts.addRequests(t, store.Request{TMDBID: 603, MediaType: "movie", User: "alice", Status: store.StatusPending})[0]
+}
+
+func TestApproveRequest(t *testing.T) {
+	ts := newTestServer(t)
+	id := pendingMovie(t, ts)
+
+	w := ts.review((*server).handleApproveRequest, id, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "approved and submitted") {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	req, _ := ts.requests.Get(id)
+	if req.Status != store.StatusSubmitted || req.ReviewedBy != "admin" || req.ReviewedAt == nil {
+		t.Errorf("request = %+v, want submitted and reviewed by admin", req)
+	}
+	if movies := ts.radarr.Movies(); len(movies) != 1 || movies[0].TmdbID != 603 {
+		t.Errorf("Radarr has %+v, want The Matrix", movies)
+	}
+
+	// The second click of a double submit finds the request no longer
+	// pending and changes nothing.
+	w = ts.review((*server).handleApproveRequest, id, "")
+	if w.Code != http.StatusConflict {
+		t.Errorf("second approval: got %d %s, want %d", w.Code, w.Body, http.StatusConflict)
+	}
+	if calls := ts.radarr.Calls(); strings.Count(strings.Join(calls, " "), "AddMovie") != 1 {
+		t.Errorf("Radarr calls = %v, want one AddMovie", calls)
+	}
+}
+
+func TestApproveRequestFails(t *testing.T) {
+	ts := newTestServer(t)
+	ts.radarr.FailWith("AddMovie", radarr.ErrUnauthorized)
+	id := pendingMovie(t, ts)
+
+	w := ts.review((*server).handleApproveRequest, id, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Request approved, but it failed") {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	req, _ := ts.requests.Get(id)
+	if req.Status != store.StatusFailed || req.Error == "" || req.ReviewedBy != "admin" {
+		t.Errorf("request = %+v, want failed with the error", req)
+	}
+	if w := ts.review((*server).handleApproveRequest, id, ""); w.Code != http.StatusConflict {
+		t.Errorf("approving the failed request: got %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestDenyRequest(t *testing.T) {
+	ts := newTestServer(t)
+	id := pendingMovie(t, ts)
+
+	w := ts.review((*server).handleDenyRequest, id, "  Already on the shelf  ")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "denied") {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	req, _ := ts.requests.Get(id)
+	if req.Status != store.StatusDenied || req.DenyReason != "Already on the shelf" || req.ReviewedBy != "admin" {
+		t.Errorf("request = %+v, want denied with the reason", req)
+	}
+	if len(ts.radarr.Calls()) != 0 {
+		t.Errorf("denying called Radarr: %v", ts.radarr.Calls())
+	}
+
+	if w := ts.review((*server).handleApproveRequest, id, ""); w.Code != http.StatusConflict {
+		t.Errorf("approving the denied request: got %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := ts.review((*server).handleDenyRequest, id, "again"); w.Code != http.StatusConflict {
+		t.Errorf("denying twice: got %d, want %d", w.Code, http.StatusConflict)
+	}
+	if req, _ := ts.requests.Get(id); req.DenyReason != "Already on the shelf" {
+		t.Errorf("deny reason = %q after the second denial", req.DenyReason)
+	}
+}
+
+func TestReviewUnknownRequest(t *testing.T) {
+	ts := newTestServer(t)
+	for name, h := range map[string]func(*server, http.ResponseWriter, *http.Request){
+		"approve": (*server).handleApproveRequest,
+		"deny":    (*server).handleDenyRequest,
+	} {
+		if w := ts.review(h, 42, ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d, want %d", name, w.Code, http.StatusNotFound)
+		}
+	}
+}
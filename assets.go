@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+)
+
+// The templates and static files are compiled into the binary. Files in
+// assets_dir (templates/*.gohtml, static/...) replace the built-in ones with
+// the same name, so a customized page does not need a rebuild.
+//
+//go:embed templates/*.gohtml static
+var embeddedAssets embed.FS
+
+// devMode reads the assets from disk on every request, see -dev.
+var devMode bool
+
+// assetsDir returns the directory whose files override the embedded ones, or
+// "" for none. In dev mode it defaults to the working directory, i.e. the
+// source checkout.
+func (c *Config) assetsDir() string {
+	if c.AssetsDir == "" && devMode {
+		return "."
+	}
+	return c.AssetsDir
+}
+
+// parseTemplates parses the embedded templates, then the ones in dir, which
+// replace embedded templates of the same name.
+func parseTemplates(dir string) (*template.Template, error) {
+	t, err := template.ParseFS(embeddedAssets, "templates/*.gohtml")
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return t, nil
+	}
+	disk := os.DirFS(dir)
+	if matches, _ := fs.Glob(disk, "templates/*.gohtml"); len(matches) == 0 {
+		return t, nil
+	}
+	return t.ParseFS(disk, "templates/*.gohtml")
+}
+
+// overlayFS serves files from disk when they exist there and from base
+// otherwise.
+type overlayFS struct {
+	disk, base fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.disk.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return f, err
+}
+
+// handleStatic serves /static/ from assets_dir and the embedded files.
+func (s *server) handleStatic(w http.ResponseWriter, r *http.Request) {
+	var fsys fs.FS = embeddedAssets
+	if dir := s.config.assetsDir(); dir != "" {
+		fsys = overlayFS{disk: os.DirFS(dir), base: embeddedAssets}
+	}
+	http.FileServerFS(fsys).ServeHTTP(w, r)
+}
+
+// withDevTemplates parses the templates again for every request, so template
+// changes show up on reload in the browser. The request runs on a copy of its
+// server with the fresh templates; other requests keep theirs.
+func withDevTemplates(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := *serverFor(r)
+		t, err := parseTemplates(s.config.assetsDir())
+		if err != nil {
+			log.Println("Failed to parse templates:", err)
+			http.Error(w, "Failed to parse templates: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.templates = t
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), serverContextKey, &s)))
+	})
+}
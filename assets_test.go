@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithDevTemplatesIsRequestLocal(t *testing.T) {
+	defer current.Store(current.Load())
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "templates"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "templates", "login.gohtml"), []byte("edited"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	builtIn, err := parseTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{config: Config{AssetsDir: dir}, templates: builtIn}
+	current.Store(s)
+
+	h := withServer(withDevTemplates(handle((*server).handleLogin)))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/login", nil))
+	if w.Body.String() != "edited" {
+		t.Errorf("page = %q, want the template from assets_dir", w.Body.String())
+	}
+	if current.Load() != s || s.templates != builtIn {
+		t.Error("dev templates replaced the templates of the running server")
+	}
+
+	os.WriteFile(filepath.Join(dir, "templates", "login.gohtml"), []byte("edited again"), 0o644)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/login", nil))
+	if w.Body.String() != "edited again" {
+		t.Errorf("page = %q, want the template parsed again", w.Body.String())
+	}
+}
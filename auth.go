@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bpouw/gopherseerr/notify"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookieName = "gopherseerr_session"
+	sessionTTL        = 30 * 24 * time.Hour
+)
+
+// User is a local account allowed to use the request UI.
+type User struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"` // bcrypt hash, see -hash-password
+	Admin        bool   `json:"admin"`
+	APIKey       string `json:"api_key,omitempty"` // for /api/v1 clients, sent as X-Api-Key
+
+	// Notifications are the user's own targets, told only about the user's
+	// requests.
+	Notifications []notify.Target `json:"notifications,omitempty"`
+}
+
+type session struct {
+	username string
+	expires  time.Time
+}
+
+// sessionStore keeps login sessions in memory. Sessions do not survive a
+// restart; users simply log in again.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+var sessions = &sessionStore{sessions: make(map[string]session)}
+
+func (s *sessionStore) create(username string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for t, sess := range s.sessions {
+		if now.After(sess.expires) {
+			delete(s.sessions, t)
+		}
+	}
+	s.sessions[token] = session{username: username, expires: now.Add(sessionTTL)}
+	return token, nil
+}
+
+func (s *sessionStore) lookup(token string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[token]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(sess.expires) {
+		delete(s.sessions, token)
+		return "", false
+	}
+	return sess.username, true
+}
+
+func (s *sessionStore) delete(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	serverContextKey
+)
+
+// authEnabled reports whether any accounts are configured. Without accounts
+// the UI stays open, as it was before logins existed.
+func (s *server) authEnabled() bool {
+	return len(s.config.Users) > 0
+}
+
+func (s *server) findUser(username string) *User {
+	for i := range s.config.Users {
+		if strings.EqualFold(s.config.Users[i].Username, username) {
+			return &s.config.Users[i]
+		}
+	}
+	return nil
+}
+
+// findUserByAPIKey returns the user owning an API key used by scripts and
+// bots instead of a session cookie.
+func (s *server) findUserByAPIKey(key string) *User {
+	for i := range s.config.Users {
+		if s.config.Users[i].APIKey != "" && subtle.ConstantTimeCompare([]byte(s.config.Users[i].APIKey), []byte(key)) == 1 {
+			return &s.config.Users[i]
+		}
+	}
+	return nil
+}
+
+// currentUser returns the logged-in user for the request, or nil.
+func currentUser(r *http.Request) *User {
+	u, _ := r.Context().Value(userContextKey).(*User)
+	return u
+}
+
+// currentUsername returns the name requests are attributed to.
+func currentUsername(r *http.Request) string {
+	if u := currentUser(r); u != nil {
+		return u.Username
+	}
+	return ""
+}
+
+// isAdmin reports whether the current user may use admin-only features.
+// Without accounts everyone can.
+func isAdmin(r *http.Request) bool {
+	if !serverFor(r).authEnabled() {
+		return true
+	}
+	u := currentUser(r)
+	return u != nil && u.Admin
+}
+
+// requireLogin wraps a handler so that it is only reachable with a valid
+// session. Browsers are redirected to the login page, other clients get 401.
+func requireLogin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := serverFor(r)
+		if !s.authEnabled() {
+			next(w, r)
+			return
+		}
+		if key := r.Header.Get("X-Api-Key"); key != "" {
+			if u := s.findUserByAPIKey(key); u != nil {
+				next(w, r.WithContext(context.WithValue(r.Context(), userContextKey, u)))
+				return
+			}
+		}
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			if username, ok := sessions.lookup(cookie.Value); ok {
+				if u := s.findUser(username); u != nil {
+					next(w, r.WithContext(context.WithValue(r.Context(), userContextKey, u)))
+					return
+				}
+			}
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			writeJSONError(w, http.StatusUnauthorized, "login or X-Api-Key header required")
+			return
+		}
+		if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		http.Error(w, "Login required", http.StatusUnauthorized)
+	}
+}
+
+// requireAdmin is like requireLogin but additionally rejects non-admin users.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return requireLogin(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeJSONError(w, http.StatusForbidden, "admin access required")
+				return
+			}
+			http.Error(w, "Admin access required", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// localRedirect returns next if it is a path on this site, else "/". Browsers
+// treat a backslash like a slash, so "/\evil.com" would leave the site just
+// like "//evil.com".
+func localRedirect(next string) string {
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(next, "/") ||
+		strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// dummyPasswordHash returns a hash no password matches, as costly to check
+// as the hashes -hash-password makes.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte(rand.Text()), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+func (s *server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	next := localRedirect(r.FormValue("next"))
+	data := struct {
+		Next  string
+		Error string
+	}{Next: next}
+
+	if r.Method != http.MethodPost {
+		s.templates.ExecuteTemplate(w, "login.gohtml", data)
+		return
+	}
+
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+	u := s.findUser(username)
+	hash := dummyPasswordHash()
+	if u != nil {
+		hash = []byte(u.PasswordHash)
+	}
+	// Unknown users are checked against a dummy hash so that the response
+	// time does not tell which usernames exist.
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || u == nil {
+		data.Error = "Invalid username or password."
+		w.WriteHeader(http.StatusUnauthorized)
+		s.templates.ExecuteTemplate(w, "login.gohtml", data)
+		return
+	}
+
+	token, err := sessions.create(u.Username)
+	if err != nil {
+		http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(sessionTTL),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		sessions.delete(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLocalRedirect(t *testing.T) {
+	tests := []struct {
+		next string
+		want string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"/show?tmdb_id=1399", "/show?tmdb_id=1399"},
+		{"/requests#top", "/requests#top"},
+		{"requests", "/"},
+		{"//evil.com", "/"},
+		{`/\evil.com`, "/"},
+		{`/\/evil.com`, "/"},
+		{"https://evil.com/", "/"},
+		{"javascript:alert(1)", "/"},
+	}
+	for _, tt := range tests {
+		if got := localRedirect(tt.next); got != tt.want {
+			t.Errorf("localRedirect(%q) = %q, want %q", tt.next, got, tt.want)
+		}
+	}
+}
+
+// withUsers configures accounts on ts: an admin, alice with the password
+// "secret", and a bot with an API key.
+func withUsers(t *testing.T, ts *testServer) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.config.Users = []User{
+		{Username: "admin", PasswordHash: string(hash), Admin: true},
+		{Username: "alice", PasswordHash: string(hash)},
+		{Username: "bot", APIKey: "bot-key"},
+	}
+}
+
+// whoAmI is a handler that writes the name of the logged-in user.
+func whoAmI(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, currentUsername(r))
+}
+
+// serve runs h for r on ts, the way withServer does on the current server.
+func (ts *testServer) serve(h http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h(w, r.WithContext(context.WithValue(r.Context(), serverContextKey, ts.server)))
+	return w
+}
+
+func TestRequireLogin(t *testing.T) {
+	ts := newTestServer(t)
+	withUsers(t, ts)
+	token, err := sessions.create("Alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sessions.delete(token) })
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		header   map[string]string
+		cookie   string
+		code     int
+		body     string
+		location string
+	}{
+		{name: "page", method: "GET", target: "/show?tmdb_id=1396", header: map[string]string{"Accept": "text/html"},
+			code: http.StatusSeeOther, location: "/login?next=%2Fshow%3Ftmdb_id%3D1396"},
+		{name: "form post", method: "POST", target: "/request", header: map[string]string{"Accept": "text/html"},
+			code: http.StatusUnauthorized, body: "Login required\n"},
+		{name: "API", method: "GET", target: "/api/v1/requests",
+			code: http.StatusUnauthorized, body: `{"error":"login or X-Api-Key header required"}` + "\n"},
+		{name: "API key", method: "GET", target: "/api/v1/requests", header: map[string]string{"X-Api-Key": "bot-key"},
+			code: http.StatusOK, body: "bot"},
+		{name: "wrong API key", method: "GET", target: "/api/v1/requests", header: map[string]string{"X-Api-Key": "bot-kez"},
+			code: http.StatusUnauthorized},
+		{name: "session", method: "GET", target: "/requests", cookie: token,
+			code: http.StatusOK, body: "alice"},
+		{name: "unknown session", method: "GET", target: "/api/v1/requests", cookie: "forged",
+			code: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: tt.cookie})
+			}
+			w := ts.serve(requireLogin(whoAmI), r)
+			if w.Code != tt.code {
+				t.Errorf("got %d %s, want %d", w.Code, w.Body, tt.code)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body, tt.body)
+			}
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
+
+func TestRequireLoginWithoutAccounts(t *testing.T) {
+	ts := newTestServer(t)
+	w := ts.serve(requireLogin(whoAmI), httptest.NewRequest("GET", "/api/v1/requests", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got %d, want the handler to run", w.Code)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	ts := newTestServer(t)
+	withUsers(t, ts)
+	for key, want := range map[string]int{"": http.StatusUnauthorized, "bot-key": http.StatusForbidden} {
+		r := httptest.NewRequest("GET", "/api/v1/admin/requests", nil)
+		r.Header.Set("X-Api-Key", key)
+		if w := ts.serve(requireAdmin(whoAmI), r); w.Code != want {
+			t.Errorf("key %q: got %d, want %d", key, w.Code, want)
+		}
+	}
+}
+
+func TestLoginAndLogout(t *testing.T) {
+	ts := newTestServer(t)
+	withUsers(t, ts)
+	login := func(username, password string) *httptest.ResponseRecorder {
+		return ts.do((*server).handleLogin, postForm("/login", url.Values{
+			"username": {username}, "password": {password}, "next": {"/requests"},
+		}))
+	}
+
+	for _, creds := range [][2]string{{"alice", "wrong"}, {"mallory", "secret"}, {"bot", ""}} {
+		if w := login(creds[0], creds[1]); w.Code != http.StatusUnauthorized || len(w.Result().Cookies()) != 0 {
+			t.Errorf("login as %s/%q: got %d with cookies %v", creds[0], creds[1], w.Code, w.Result().Cookies())
+		}
+	}
+
+	w := login("ALICE", "secret")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/requests" {
+		t.Fatalf("login: got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookieName || !cookies[0].HttpOnly {
+		t.Fatalf("login set cookies %v", cookies)
+	}
+	session := cookies[0]
+	whoami := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/v1/requests", nil)
+		r.AddCookie(session)
+		return ts.serve(requireLogin(whoAmI), r)
+	}
+	if w := whoami(); w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Errorf("with the session: got %d %q, want alice", w.Code, w.Body)
+	}
+
+	r := httptest.NewRequest("GET", "/logout", nil)
+	r.AddCookie(session)
+	w = httptest.NewRecorder()
+	handleLogout(w, r)
+	if cookies := w.Result().Cookies(); w.Code != http.StatusSeeOther || len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("logout: got %d with cookies %v, want the cookie cleared", w.Code, cookies)
+	}
+	if w := whoami(); w.Code != http.StatusUnauthorized {
+		t.Errorf("after logout: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestDummyPasswordHash(t *testing.T) {
+	hash := dummyPasswordHash()
+	if cost, err := bcrypt.Cost(hash); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("cost = %d, %v, want %d like -hash-password", cost, err, bcrypt.DefaultCost)
+	}
+	if bcrypt.CompareHashAndPassword(hash, nil) == nil {
+		t.Error("the empty password matches the dummy hash")
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bpouw/gopherseerr/notify"
+	"github.com/bpouw/gopherseerr/sonarr"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Settings are read from a config file and then overridden by GOPHERSEERR_*
+// environment variables, named after the JSON keys: GOPHERSEERR_TMDB_API_KEY,
+// GOPHERSEERR_SERVER_TLS_CERT, ... Lists and other structured values are
+// given as JSON, e.g. GOPHERSEERR_USERS='[{"username": "alice", ...}]'.
+const envPrefix = "GOPHERSEERR_"
+
+// configNames are looked for, in order, when no config file is given: first
+// in the working directory, then next to the executable.
+var configNames = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// findConfig returns the config file to load: path if given, otherwise the
+// first of configNames that exists. Without any config file the settings come
+// from the environment alone and "" is returned.
+func findConfig(path string) (string, error) {
+	if path != "" {
+		_, err := os.Stat(path)
+		return path, err
+	}
+	dirs := []string{"."}
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(exe))
+	}
+	for _, dir := range dirs {
+		for _, name := range configNames {
+			if p := filepath.Join(dir, name); fileExists(p) {
+				return p, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// loadConfig reads the config file at path (if any), applies environment
+// overrides and defaults, and validates the result. All problems found are
+// reported together.
+func loadConfig(path string) (Config, error) {
+	var c Config
+	var errs []error
+	if path != "" {
+		problems, err := decodeConfigFile(path, &c)
+		if err != nil {
+			return c, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, p := range problems {
+			errs = append(errs, fmt.Errorf("%s: %w", path, p))
+		}
+	}
+	errs = append(errs, applyEnv(reflect.ValueOf(&c).Elem(), envPrefix)...)
+	c.setDefaults(filepath.Dir(path))
+	return c, errors.Join(append(errs, c.validate())...)
+}
+
+// decodeConfigFile decodes a JSON, YAML or TOML file into c. YAML and TOML
+// are converted to JSON first so the json field names apply to all three.
+// The error is set when the file cannot be read or parsed at all; problems
+// lists settings with a value of the wrong type, each decoded on its own so
+// one does not hide the next, and keys that are not settings, which are
+// usually misspelled.
+func decodeConfigFile(path string, c *Config) (problems []error, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown config format %q, use .json, .yaml or .toml", filepath.Ext(path))
+	}
+	if raw != nil {
+		if port, ok := raw["port"]; ok {
+			raw["port"] = fmt.Sprint(port) // port: 8080 rather than "8080"
+		}
+		if data, err = json.Marshal(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("the config must be an object of settings, not a %s", typeErr.Value)
+		}
+		return nil, err
+	}
+	var tree any
+	json.Unmarshal(data, &tree) // cannot fail after the decode above
+	problems = append(problems, unknownKeys(reflect.TypeFor[Config](), tree, "")...)
+
+	v := reflect.ValueOf(c).Elem()
+	for i := range v.NumField() {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		value, ok := fields[name]
+		if !ok || name == "" || name == "-" {
+			continue
+		}
+		if err := json.Unmarshal(value, v.Field(i).Addr().Interface()); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				field := name
+				if typeErr.Field != "" {
+					field += "." + typeErr.Field
+				}
+				err = fmt.Errorf("%s must be a %s, not a %s", field, typeErr.Type, typeErr.Value)
+			} else {
+				err = fmt.Errorf("%s: %w", name, err)
+			}
+			problems = append(problems, err)
+		}
+	}
+	slices.SortFunc(problems, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+	return problems, nil
+}
+
+// unknownKeys returns an error for every key in the decoded JSON value raw
+// that has no field in t, descending into nested sections and lists.
+func unknownKeys(t reflect.Type, raw any, prefix string) []error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	ptr := reflect.PointerTo(t)
+	if ptr.Implements(jsonUnmarshalerType) || ptr.Implements(textUnmarshalerType) {
+		return nil
+	}
+	var errs []error
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := raw.(map[string]any)
+		if !ok {
+			return nil // reported as a type error
+		}
+		known := make(map[string]reflect.Type)
+		for i := range t.NumField() {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if name != "" && name != "-" {
+				known[name] = t.Field(i).Type
+			}
+		}
+		keys := slices.Sorted(maps.Keys(obj))
+		for _, key := range keys {
+			ft, ok := known[key]
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s%s is not a setting", prefix, key))
+				continue
+			}
+			errs = append(errs, unknownKeys(ft, obj[key], prefix+key+".")...)
+		}
+	case reflect.Slice, reflect.Array:
+		list, _ := raw.([]any)
+		for i, item := range list {
+			errs = append(errs, unknownKeys(t.Elem(), item, fmt.Sprintf("%s[%d].", strings.TrimSuffix(prefix, "."), i))...)
+		}
+	}
+	return errs
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// applyEnv sets the fields of the struct v from environment variables named
+// prefix plus the upper-cased JSON key. Nested structs extend the prefix.
+func applyEnv(v reflect.Value, prefix string) []error {
+	var errs []error
+	t := v.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + strings.ToUpper(name)
+		field := v.Field(i)
+		ptr := reflect.PointerTo(f.Type)
+		if f.Type.Kind() == reflect.Struct && !ptr.Implements(jsonUnmarshalerType) && !ptr.Implements(textUnmarshalerType) {
+			errs = append(errs, applyEnv(field, key+"_")...)
+			continue
+		}
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			continue
+		}
+		if err := setFromEnv(field, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return errs
+}
+
+// setFromEnv sets field to value. Strings, booleans and numbers are taken as
+// is; anything else is parsed as JSON, falling back to a JSON string for types
+// like ProfileRef that also accept a bare name.
+func setFromEnv(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		field.SetBool(b)
+		return nil
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		field.SetInt(int64(n))
+		return nil
+	}
+	target := field.Addr().Interface()
+	err := json.Unmarshal([]byte(value), target)
+	if err == nil {
+		return nil
+	}
+	quoted, _ := json.Marshal(value)
+	if json.Unmarshal(quoted, target) == nil {
+		return nil
+	}
+	return fmt.Errorf("invalid value %q: %w", value, err)
+}
+
+// setDefaults fills in unset fields. Relative paths are taken relative to
+// the directory of the config file, so running as a service from another
+// working directory finds the same files.
+func (c *Config) setDefaults(dir string) {
+	if c.Port == "" {
+		c.Port = "8080"
+	}
+	if c.RadarrURL == "" && len(c.RadarrServers) == 0 {
+		c.RadarrURL = "http://localhost:7878"
+	}
+	if c.SonarrURL == "" && len(c.SonarrServers) == 0 {
+		c.SonarrURL = "http://localhost:8989"
+	}
+	if c.RequestsFile == "" {
+		c.RequestsFile = "requests.json"
+	}
+	c.RadarrURL = strings.TrimRight(c.RadarrURL, "/")
+	c.SonarrURL = strings.TrimRight(c.SonarrURL, "/")
+
+	paths := []*string{
+		&c.RequestsFile,
+		&c.AssetsDir,
+		&c.Server.TLSCert,
+		&c.Server.TLSKey,
+		&c.HTTP.CAFile,
+		&c.TMDBHTTP.CAFile,
+		&c.RadarrHTTP.CAFile,
+		&c.SonarrHTTP.CAFile,
+	}
+	for _, servers := range [][]InstanceConfig{c.RadarrServers, c.SonarrServers} {
+		for i := range servers {
+			servers[i].URL = strings.TrimRight(servers[i].URL, "/")
+			paths = append(paths, &servers[i].HTTP.CAFile)
+		}
+	}
+	for _, p := range paths {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+}
+
+// validate reports every missing or malformed setting at once.
+func (c *Config) validate() error {
+	var errs []error
+	problem := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		problem("port: %q is not a port number", c.Port)
+	}
+	type setting struct{ name, value string }
+	required := []setting{{"tmdb_api_key", c.TMDBApiKey}}
+	var urls []setting
+	if len(c.RadarrServers) == 0 { // otherwise set per server
+		required = append(required, setting{"radarr_api_key", c.RadarrApiKey})
+		urls = append(urls, setting{"radarr_url", c.RadarrURL})
+	}
+	if len(c.SonarrServers) == 0 {
+		required = append(required, setting{"sonarr_api_key", c.SonarrApiKey})
+		urls = append(urls, setting{"sonarr_url", c.SonarrURL})
+	}
+	for _, r := range required {
+		if r.value == "" {
+			problem("%s is missing (set it in the config file or as %s%s)", r.name, envPrefix, strings.ToUpper(r.name))
+		}
+	}
+	for _, u := range urls {
+		if parsed, err := url.Parse(u.value); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			problem("%s: %q is not an http:// or https:// URL", u.name, u.value)
+		}
+	}
+
+	errs = append(errs, validateServers("radarr_servers", c.RadarrServers, setKeys(map[string]bool{
+		"radarr_url":               c.RadarrURL != "",
+		"radarr_api_key":           c.RadarrApiKey != "",
+		"radarr_quality_profile":   !c.RadarrQualityProfile.IsZero(),
+		"radarr_root_folder":       c.RadarrRootFolder != "",
+		"radarr_root_folder_rules": len(c.RadarrRootFolderRules) > 0,
+	}))...)
+	errs = append(errs, validateServers("sonarr_servers", c.SonarrServers, setKeys(map[string]bool{
+		"sonarr_url":               c.SonarrURL != "",
+		"sonarr_api_key":           c.SonarrApiKey != "",
+		"sonarr_quality_profile":   !c.SonarrQualityProfile.IsZero(),
+		"sonarr_language_profile":  !c.SonarrLanguageProfile.IsZero(),
+		"sonarr_root_folder":       c.SonarrRootFolder != "",
+		"sonarr_root_folder_rules": len(c.SonarrRootFolderRules) > 0,
+	}))...)
+
+	if c.RequireApproval && !slices.ContainsFunc(c.Users, func(u User) bool { return u.Admin }) {
+		problem("require_approval is enabled but no admin user is configured to approve requests")
+	}
+	usernames := make(map[string]bool)
+	apiKeys := make(map[string]bool)
+	for i, u := range c.Users {
+		switch {
+		case u.Username == "":
+			problem("users[%d]: username is missing", i)
+		case usernames[strings.ToLower(u.Username)]: // logins ignore case
+			problem("users[%d]: username %q is used twice", i, u.Username)
+		}
+		usernames[strings.ToLower(u.Username)] = true
+		if _, err := bcrypt.Cost([]byte(u.PasswordHash)); u.PasswordHash != "" && err != nil {
+			problem("users[%d]: password_hash is not a bcrypt hash, create one with -hash-password", i)
+		}
+		if u.APIKey != "" {
+			if apiKeys[u.APIKey] {
+				problem("users[%d]: api_key is used by another user", i)
+			}
+			apiKeys[u.APIKey] = true
+		}
+		if _, err := notify.NewDispatcher(u.Notifications); err != nil {
+			problem("users[%d]: notifications: %w", i, err)
+		}
+	}
+
+	if _, err := c.HTTP.options(); err != nil {
+		problem("http: %w", err)
+	}
+	services := []struct {
+		name string
+		http HTTPConfig
+	}{{"tmdb_http", c.TMDBHTTP}, {"radarr_http", c.RadarrHTTP}, {"sonarr_http", c.SonarrHTTP}}
+	for _, s := range services {
+		if _, err := s.http.options(); err != nil {
+			problem("%s: %w", s.name, err)
+		}
+	}
+	if _, err := c.TMDBCache.newCache(); err != nil {
+		problem("tmdb_cache: %w", err)
+	}
+	if _, err := parseIndexMaxAge(c.SonarrIndexMaxAge); err != nil {
+		problem("%w", err)
+	}
+	if _, err := c.Server.timeouts(); err != nil {
+		problem("server: %w", err)
+	}
+	if !c.Server.TLSSelfSigned && (c.Server.TLSCert == "") != (c.Server.TLSKey == "") {
+		problem("server: tls_cert and tls_key must be set together")
+	}
+	if _, err := notify.NewDispatcher(c.Notifications); err != nil {
+		problem("notifications: %w", err)
+	}
+	return errors.Join(errs...)
+}
+
+// parseIndexMaxAge parses sonarr_index_max_age into sonarr.Client.IndexMaxAge.
+func parseIndexMaxAge(value string) (time.Duration, error) {
+	switch value {
+	case "":
+		return 0, nil
+	case "off":
+		return -1, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid sonarr_index_max_age %q, use a duration such as %q or \"off\"", value, sonarr.DefaultIndexMaxAge.String())
+	}
+	return d, nil
+}
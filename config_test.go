@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigReportsEveryProblem(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "misspelled key",
+			file:    "config.json",
+			content: `{"tmdb_api_key": "t", "radarr_api_key": "r", "sonar_api_key": "s"}`,
+			want:    []string{"sonar_api_key is not a setting", "sonarr_api_key is missing"},
+		},
+		{
+			name:    "nested unknown keys",
+			file:    "config.json",
+			content: `{"tmdb_api_key": "t", "radarr_api_key": "r", "sonarr_api_key": "s", "http": {"timout": "5s"}, "users": [{"username": "a", "pasword": "x"}]}`,
+			want:    []string{"http.timout is not a setting", "users[0].pasword is not a setting"},
+		},
+		{
+			name:    "type errors do not hide each other",
+			file:    "config.json",
+			content: `{"tmdb_api_key": 1, "radarr_api_key": "r", "sonarr_api_key": "s", "require_approval": "yes", "http": {"max_retries": "3"}}`,
+			want: []string{
+				"tmdb_api_key must be a string, not a number",
+				"require_approval must be a bool, not a string",
+				"http.max_retries must be a int, not a string",
+				"tmdb_api_key is missing",
+			},
+		},
+		{
+			name:    "yaml",
+			file:    "config.yaml",
+			content: "tmdb_api_key: t\nradarr_api_key: r\nsonarr_api_key: s\nport: 8080\nradarr_servrs: []\n",
+			want:    []string{"radarr_servrs is not a setting"},
+		},
+		{
+			name:    "toml",
+			file:    "config.toml",
+			content: "tmdb_api_key = \"t\"\nradarr_api_key = \"r\"\nsonarr_api_key = \"s\"\n[server]\ntls_cert = \"c\"\ntls_kye = \"k\"\n",
+			want:    []string{"server.tls_kye is not a setting", "tls_cert and tls_key must be set together"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(writeConfig(t, tt.file, tt.content))
+			if err == nil {
+				t.Fatal("loadConfig succeeded")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error does not mention %q:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigValid(t *testing.T) {
+	path := writeConfig(t, "config.json", `{"tmdb_api_key": "t", "radarr_api_key": "r", "sonarr_api_key": "s", "radarr_quality_profile": "HD-1080p", "users": [{"username": "alice", "api_key": "k"}]}`)
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.RadarrQualityProfile.Name != "HD-1080p" || c.Users[0].Username != "alice" || c.RadarrURL != "http://localhost:7878" {
+		t.Errorf("unexpected config: %+v", c)
+	}
+}
+
+func TestLoadConfigUnparsable(t *testing.T) {
+	_, err := loadConfig(writeConfig(t, "config.json", `{"tmdb_api_key": `))
+	if err == nil || !strings.Contains(err.Error(), "config.json") {
+		t.Fatalf("got %v, want a syntax error naming the file", err)
+	}
+}
+
+func TestValidateUsernamesIgnoreCase(t *testing.T) {
+	c := Config{TMDBApiKey: "t", RadarrApiKey: "r", SonarrApiKey: "s", Users: []User{{Username: "alice"}, {Username: "ALICE"}}}
+	c.setDefaults(t.TempDir())
+	err := c.validate()
+	if err == nil || !strings.Contains(err.Error(), `username "ALICE" is used twice`) {
+		t.Fatalf("got %v, want a duplicate username error", err)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(c Config) bool
+	}{
+		{"string", map[string]string{"GOPHERSEERR_TMDB_API_KEY": "from-env"},
+			func(c Config) bool { return c.TMDBApiKey == "from-env" }},
+		{"bool", map[string]string{"GOPHERSEERR_REQUIRE_APPROVAL": "true"},
+			func(c Config) bool { return c.RequireApproval }},
+		{"nested int", map[string]string{"GOPHERSEERR_HTTP_MAX_RETRIES": "-1"},
+			func(c Config) bool { return c.HTTP.MaxRetries == -1 }},
+		{"nested sections", map[string]string{"GOPHERSEERR_SERVER_TLS_CERT": "cert.pem", "GOPHERSEERR_SONARR_HTTP_PROXY": "http://proxy:3128"},
+			func(c Config) bool {
+				return c.Server.TLSCert == "cert.pem" && c.SonarrHTTP.Proxy == "http://proxy:3128"
+			}},
+		{"JSON", map[string]string{"GOPHERSEERR_USERS": `[{"username": "alice", "admin": true}]`},
+			func(c Config) bool { return len(c.Users) == 1 && c.Users[0].Username == "alice" && c.Users[0].Admin }},
+		{"profile by name", map[string]string{"GOPHERSEERR_RADARR_QUALITY_PROFILE": "HD-1080p"},
+			func(c Config) bool { return c.RadarrQualityProfile == ProfileRef{Name: "HD-1080p"} }},
+		{"profile by ID", map[string]string{"GOPHERSEERR_SONARR_QUALITY_PROFILE": "4"},
+			func(c Config) bool { return c.SonarrQualityProfile == ProfileRef{ID: 4} }},
+		{"unset variables change nothing", nil,
+			func(c Config) bool { return c.TMDBApiKey == "from-file" && c.HTTP.MaxRetries == 3 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			c := Config{TMDBApiKey: "from-file", HTTP: HTTPConfig{MaxRetries: 3}}
+			if errs := applyEnv(reflect.ValueOf(&c).Elem(), envPrefix); len(errs) != 0 {
+				t.Fatal(errs)
+			}
+			if !tt.check(c) {
+				t.Errorf("config after the overrides: %+v", c)
+			}
+		})
+	}
+}
+
+func TestApplyEnvInvalidValues(t *testing.T) {
+	t.Setenv("GOPHERSEERR_REQUIRE_APPROVAL", "yes")
+	t.Setenv("GOPHERSEERR_HTTP_MAX_RETRIES", "three")
+	t.Setenv("GOPHERSEERR_USERS", `[{"username": "alice"`)
+	t.Setenv("GOPHERSEERR_TMDB_API_KEY", "still-applied")
+
+	var c Config
+	err := errors.Join(applyEnv(reflect.ValueOf(&c).Elem(), envPrefix)...)
+	for _, want := range []string{
+		`GOPHERSEERR_REQUIRE_APPROVAL: "yes" is not true or false`,
+		`GOPHERSEERR_HTTP_MAX_RETRIES: "three" is not a number`,
+		`GOPHERSEERR_USERS: invalid value`,
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+	if c.TMDBApiKey != "still-applied" {
+		t.Errorf("a valid override was dropped next to invalid ones: %+v", c)
+	}
+}
+
+func TestLoadConfigEnvOverridesFile(t *testing.T) {
+	t.Setenv("GOPHERSEERR_SONARR_API_KEY", "from-env")
+	t.Setenv("GOPHERSEERR_HTTP_TIMEOUT", "5s")
+	path := writeConfig(t, "config.json", `{"tmdb_api_key": "t", "radarr_api_key": "r", "sonarr_api_key": "s", "http": {"timeout": "30s", "max_retries": 2}}`)
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.SonarrApiKey != "from-env" || c.HTTP.Timeout != "5s" || c.HTTP.MaxRetries != 2 || c.TMDBApiKey != "t" {
+		t.Errorf("unexpected config: %+v", c)
+	}
+}
+
+func TestFindConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if path, err := findConfig(""); path != "" || err != nil {
+		t.Errorf("without config files: got %q, %v, want none", path, err)
+	}
+
+	os.WriteFile("config.toml", nil, 0o600)
+	os.WriteFile("config.yaml", nil, 0o600)
+	if path, err := findConfig(""); path != "config.yaml" || err != nil {
+		t.Errorf("got %q, %v, want config.yaml before config.toml", path, err)
+	}
+	os.WriteFile("config.json", nil, 0o600)
+	if path, _ := findConfig(""); path != "config.json" {
+		t.Errorf("got %q, want config.json first", path)
+	}
+
+	explicit := filepath.Join(dir, "elsewhere.yml")
+	os.WriteFile(explicit, nil, 0o600)
+	if path, err := findConfig(explicit); path != explicit || err != nil {
+		t.Errorf("explicit path: got %q, %v", path, err)
+	}
+	if _, err := findConfig(filepath.Join(dir, "missing.json")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing explicit path: got %v, want a not-exist error", err)
+	}
+}
+
+func TestLoadConfigInsecureSkipVerify(t *testing.T) {
+	t.Setenv("GOPHERSEERR_RADARR_HTTP_INSECURE_SKIP_VERIFY", "false")
+	path := writeConfig(t, "config.json", `{"tmdb_api_key": "t", "radarr_api_key": "r", "sonarr_api_key": "s", "http": {"insecure_skip_verify": true}}`)
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.HTTP.InsecureSkipVerify == nil || !*c.HTTP.InsecureSkipVerify ||
+		c.RadarrHTTP.InsecureSkipVerify == nil || *c.RadarrHTTP.InsecureSkipVerify || c.SonarrHTTP.InsecureSkipVerify != nil {
+		t.Errorf("got http %v, radarr_http %v, sonarr_http %v", c.HTTP.InsecureSkipVerify, c.RadarrHTTP.InsecureSkipVerify, c.SonarrHTTP.InsecureSkipVerify)
+	}
+}
@@ -0,0 +1,74 @@
+// Package fake provides in-memory stand-ins for TMDB, Radarr and Sonarr.
+//
+// Each fake can be used in two ways: directly, in place of the real client,
+// or through its simulator, an httptest server that serves the service's API
+// from the same state so the real clients can be exercised end to end.
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// calls records what a fake was asked to do and holds the errors it has
+// been told to return.
+type calls struct {
+	mu   sync.Mutex
+	log  []string
+	errs map[string]error
+}
+
+// record logs a call and returns the error set for it with FailWith.
+func (c *calls) record(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.log = append(c.log, name)
+	return c.errs[name]
+}
+
+// Calls returns the methods called so far in order, e.g. "AddSeries", or
+// for a simulator the requests it served, e.g. "POST /api/v3/series".
+func (c *calls) Calls() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.log)
+}
+
+// FailWith makes the method or simulator request name return err from now
+// on; a nil err clears it. Simulators answer failed requests with a 500.
+func (c *calls) FailWith(name string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.errs == nil {
+		c.errs = make(map[string]error)
+	}
+	if err == nil {
+		delete(c.errs, name)
+		return
+	}
+	c.errs[name] = err
+}
+
+// route wraps a simulator handler: it checks the API key with authorized,
+// logs the request under its pattern and fails it when FailWith says so.
+func (c *calls) route(authorized func(r *http.Request) bool, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Unauthorized"})
+			return
+		}
+		if err := c.record(r.Pattern); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+			return
+		}
+		h(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,175 @@
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/bpouw/gopherseerr/radarr"
+)
+
+// Radarr is an in-memory Radarr library.
+type Radarr struct {
+	calls
+	APIKey          string // the key the simulator expects, none when empty
+	QualityProfiles []radarr.QualityProfile
+	RootFolders     []radarr.RootFolder
+
+	mu     sync.Mutex
+	movies []radarr.Movie
+	nextID int
+}
+
+// NewRadarr returns an empty library with one quality profile, "HD-1080p",
+// and one root folder, /movies.
+func NewRadarr() *Radarr {
+	return &Radarr{
+		QualityProfiles: []radarr.QualityProfile{{ID: 1, Name: "HD-1080p"}},
+		RootFolders:     []radarr.RootFolder{{ID: 1, Path: "/movies", Accessible: true, FreeSpace: 1 << 40}},
+	}
+}
+
+// Put adds movie to the library as if it had been added in Radarr, and
+// returns it with its ID.
+func (f *Radarr) Put(movie radarr.Movie) radarr.Movie {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.put(movie)
+}
+
+func (f *Radarr) put(movie radarr.Movie) radarr.Movie {
+	f.nextID++
+	movie.ID = f.nextID
+	f.movies = append(f.movies, movie)
+	return movie
+}
+
+// Movies returns the library.
+func (f *Radarr) Movies() []radarr.Movie {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.movies)
+}
+
+func (f *Radarr) AddMovie(ctx context.Context, opts radarr.AddMovieOptions) error {
+	if err := f.record("AddMovie"); err != nil {
+		return err
+	}
+	_, err := f.add(opts.Movie())
+	return err
+}
+
+func (f *Radarr) GetMovies(ctx context.Context) ([]radarr.Movie, error) {
+	if err := f.record("GetMovies"); err != nil {
+		return nil, err
+	}
+	return f.Movies(), nil
+}
+
+// GetMovieByTMDB returns the movie with the TMDB ID, or nil.
+func (f *Radarr) GetMovieByTMDB(ctx context.Context, tmdbID int) (*radarr.Movie, error) {
+	if err := f.record("GetMovieByTMDB"); err != nil {
+		return nil, err
+	}
+	if movies := f.byTMDB(tmdbID); len(movies) > 0 {
+		return &movies[0], nil
+	}
+	return nil, nil
+}
+
+func (f *Radarr) GetQualityProfiles(ctx context.Context) ([]radarr.QualityProfile, error) {
+	if err := f.record("GetQualityProfiles"); err != nil {
+		return nil, err
+	}
+	return slices.Clone(f.QualityProfiles), nil
+}
+
+func (f *Radarr) GetRootFolders(ctx context.Context) ([]radarr.RootFolder, error) {
+	if err := f.record("GetRootFolders"); err != nil {
+		return nil, err
+	}
+	return slices.Clone(f.RootFolders), nil
+}
+
+// add adds movie unless the library already has it, in which case it fails
+// the way Radarr does.
+func (f *Radarr) add(movie radarr.Movie) (radarr.Movie, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if slices.ContainsFunc(f.movies, func(m radarr.Movie) bool { return m.TmdbID == movie.TmdbID }) {
+		return movie, &radarr.APIError{
+			Service:    radarr.Service,
+			StatusCode: http.StatusBadRequest,
+			Method:     http.MethodPost,
+			Endpoint:   "/api/v3/movie",
+			Validation: []radarr.ValidationFailure{{
+				PropertyName: "TmdbId",
+				ErrorMessage: "This movie has already been added",
+				ErrorCode:    "MovieExistsValidator",
+			}},
+		}
+	}
+	return f.put(movie), nil
+}
+
+func (f *Radarr) byTMDB(tmdbID int) []radarr.Movie {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []radarr.Movie
+	for _, m := range f.movies {
+		if m.TmdbID == tmdbID {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// NewRadarrServer starts a simulator serving the Radarr API from f. Close it
+// when done.
+func NewRadarrServer(f *Radarr) *httptest.Server {
+	return httptest.NewServer(f.Handler())
+}
+
+// Handler serves the parts of the Radarr v3 API the client uses from f.
+func (f *Radarr) Handler() http.Handler {
+	authorized := func(r *http.Request) bool {
+		return f.APIKey == "" || r.URL.Query().Get("apikey") == f.APIKey || r.Header.Get("X-Api-Key") == f.APIKey
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v3/movie", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		movies := f.Movies()
+		if v := r.URL.Query().Get("tmdbId"); v != "" {
+			tmdbID, _ := strconv.Atoi(v)
+			movies = f.byTMDB(tmdbID)
+		}
+		if movies == nil {
+			movies = []radarr.Movie{}
+		}
+		writeJSON(w, http.StatusOK, movies)
+	}))
+	mux.HandleFunc("POST /api/v3/movie", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		var movie radarr.Movie
+		if err := json.NewDecoder(r.Body).Decode(&movie); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+			return
+		}
+		added, err := f.add(movie)
+		if apiErr, ok := err.(*radarr.APIError); ok {
+			writeJSON(w, apiErr.StatusCode, apiErr.Validation)
+			return
+		}
+		writeJSON(w, http.StatusCreated, added)
+	}))
+	mux.HandleFunc("GET /api/v3/qualityprofile", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, f.QualityProfiles)
+	}))
+	mux.HandleFunc("GET /api/v3/rootfolder", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, f.RootFolders)
+	}))
+	return mux
+}
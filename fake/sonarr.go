@@ -0,0 +1,475 @@
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bpouw/gopherseerr/sonarr"
+)
+
+// Sonarr is an in-memory Sonarr: a catalog of shows its series lookup finds,
+// and the library of series added from it.
+type Sonarr struct {
+	calls
+	APIKey           string // the key the simulator expects, none when empty
+	QualityProfiles  []sonarr.QualityProfile
+	LanguageProfiles []sonarr.LanguageProfile // empty like Sonarr v4 by default
+	RootFolders      []sonarr.RootFolder
+
+	mu       sync.Mutex
+	catalog  map[int]sonarr.Series    // by TMDB ID
+	episodes map[int][]sonarr.Episode // by TVDB ID
+	library  []sonarr.Series
+	searched []int
+	nextID   int
+	nextEpID int
+}
+
+// NewSonarr returns an empty Sonarr with one quality profile, "HD-1080p",
+// and one root folder, /tv.
+func NewSonarr() *Sonarr {
+	return &Sonarr{
+		QualityProfiles:  []sonarr.QualityProfile{{ID: 1, Name: "HD-1080p"}},
+		LanguageProfiles: []sonarr.LanguageProfile{},
+		RootFolders:      []sonarr.RootFolder{{ID: 1, Path: "/tv", Accessible: true, FreeSpace: 1 << 40}},
+		catalog:          make(map[int]sonarr.Series),
+		episodes:         make(map[int][]sonarr.Episode),
+	}
+}
+
+// Catalog makes a show known to the series lookup under its TMDB and TVDB
+// IDs, with its episodes. Episodes without an ID are given one.
+func (f *Sonarr) Catalog(series sonarr.Series, episodes []sonarr.Episode) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	series.ID = 0
+	f.catalog[series.TmdbID] = cloneSeries(series)
+	eps := slices.Clone(episodes)
+	for i := range eps {
+		if eps[i].ID == 0 {
+			f.nextEpID++
+			eps[i].ID = f.nextEpID
+		}
+	}
+	f.episodes[series.TvdbID] = eps
+}
+
+// Put adds series to the library as if it had been added in Sonarr, and
+// returns it with its ID.
+func (f *Sonarr) Put(series sonarr.Series) sonarr.Series {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.put(series)
+}
+
+func (f *Sonarr) put(series sonarr.Series) sonarr.Series {
+	f.nextID++
+	series.ID = f.nextID
+	series.AddOptions = nil
+	f.library = append(f.library, cloneSeries(series))
+	return cloneSeries(series)
+}
+
+// Series returns the library.
+func (f *Sonarr) Series() []sonarr.Series {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]sonarr.Series, len(f.library))
+	for i, s := range f.library {
+		out[i] = cloneSeries(s)
+	}
+	return out
+}
+
+// Searched returns the IDs of the episodes searched for so far.
+func (f *Sonarr) Searched() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.searched)
+}
+
+// LookupSeries returns the library series with the TMDB ID, or else the
+// catalog entry.
+func (f *Sonarr) LookupSeries(ctx context.Context, tmdbID int) (*sonarr.Series, error) {
+	if err := f.record("LookupSeries"); err != nil {
+		return nil, err
+	}
+	return f.lookupOne(tmdbID)
+}
+
+// AddSeries adds the catalog show with the TMDB ID the way the client does.
+func (f *Sonarr) AddSeries(ctx context.Context, opts sonarr.AddSeriesOptions) (int, error) {
+	if err := f.record("AddSeries"); err != nil {
+		return 0, err
+	}
+	lookup, err := f.lookupOne(opts.TMDBID)
+	if err != nil {
+		return 0, err
+	}
+	added, err := f.add(opts.Series(*lookup))
+	return added.ID, err
+}
+
+func (f *Sonarr) UpdateSeries(ctx context.Context, series *sonarr.Series) error {
+	if err := f.record("UpdateSeries"); err != nil {
+		return err
+	}
+	series.AddOptions = nil
+	_, err := f.update(*series)
+	return err
+}
+
+func (f *Sonarr) GetSeries(ctx context.Context, id int) (*sonarr.Series, error) {
+	if err := f.record("GetSeries"); err != nil {
+		return nil, err
+	}
+	return f.get(id)
+}
+
+func (f *Sonarr) GetSeriesByTMDB(ctx context.Context, tmdbID int) (*sonarr.Series, error) {
+	if err := f.record("GetSeriesByTMDB"); err != nil {
+		return nil, err
+	}
+	series, err := f.find(tmdbID)
+	if err != nil {
+		return nil, err
+	}
+	if series == nil {
+		return nil, fmt.Errorf("series with TMDB ID %d is not in the library: %w", tmdbID, sonarr.ErrNotFound)
+	}
+	return series, nil
+}
+
+// FindSeriesByTMDB returns the library series with the TMDB ID, or nil when
+// the show is in the catalog but has not been added.
+func (f *Sonarr) FindSeriesByTMDB(ctx context.Context, tmdbID int) (*sonarr.Series, error) {
+	if err := f.record("FindSeriesByTMDB"); err != nil {
+		return nil, err
+	}
+	return f.find(tmdbID)
+}
+
+func (f *Sonarr) Library(ctx context.Context) ([]sonarr.Series, error) {
+	if err := f.record("Library"); err != nil {
+		return nil, err
+	}
+	return f.Series(), nil
+}
+
+// InvalidateSeries only records the call; the fake has no index.
+func (f *Sonarr) InvalidateSeries(tvdbID int) {
+	f.record("InvalidateSeries")
+}
+
+func (f *Sonarr) GetEpisodes(ctx context.Context, seriesID int) ([]sonarr.Episode, error) {
+	if err := f.record("GetEpisodes"); err != nil {
+		return nil, err
+	}
+	return f.seriesEpisodes(seriesID), nil
+}
+
+func (f *Sonarr) MonitorEpisodes(ctx context.Context, episodeIDs []int, monitored bool) error {
+	if err := f.record("MonitorEpisodes"); err != nil {
+		return err
+	}
+	f.monitor(episodeIDs, monitored)
+	return nil
+}
+
+func (f *Sonarr) SearchEpisodes(ctx context.Context, episodeIDs []int) error {
+	if err := f.record("SearchEpisodes"); err != nil {
+		return err
+	}
+	f.search(episodeIDs)
+	return nil
+}
+
+func (f *Sonarr) GetQualityProfiles(ctx context.Context) ([]sonarr.QualityProfile, error) {
+	if err := f.record("GetQualityProfiles"); err != nil {
+		return nil, err
+	}
+	return slices.Clone(f.QualityProfiles), nil
+}
+
+func (f *Sonarr) GetLanguageProfiles(ctx context.Context) ([]sonarr.LanguageProfile, error) {
+	if err := f.record("GetLanguageProfiles"); err != nil {
+		return nil, err
+	}
+	return slices.Clone(f.LanguageProfiles), nil
+}
+
+func (f *Sonarr) GetRootFolders(ctx context.Context) ([]sonarr.RootFolder, error) {
+	if err := f.record("GetRootFolders"); err != nil {
+		return nil, err
+	}
+	return slices.Clone(f.RootFolders), nil
+}
+
+// lookup returns what the series lookup finds for a TMDB ID: the library
+// series if it was added, otherwise the catalog entry.
+func (f *Sonarr) lookup(tmdbID int) []sonarr.Series {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range f.library {
+		if s.TmdbID == tmdbID {
+			return []sonarr.Series{cloneSeries(s)}
+		}
+	}
+	if s, ok := f.catalog[tmdbID]; ok {
+		return []sonarr.Series{cloneSeries(s)}
+	}
+	return []sonarr.Series{}
+}
+
+// add adds series unless the library already has it, in which case it fails
+// the way Sonarr does. The episodes of its monitored seasons are monitored,
+// the others are not.
+func (f *Sonarr) add(series sonarr.Series) (sonarr.Series, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if slices.ContainsFunc(f.library, func(s sonarr.Series) bool { return s.TvdbID == series.TvdbID }) {
+		return series, &sonarr.APIError{
+			Service:    sonarr.Service,
+			StatusCode: http.StatusBadRequest,
+			Method:     http.MethodPost,
+			Endpoint:   "/api/v3/series",
+			Validation: []sonarr.ValidationFailure{{
+				PropertyName: "TvdbId",
+				ErrorMessage: "This series has already been added",
+				ErrorCode:    "SeriesExistsValidator",
+			}},
+		}
+	}
+	eps := f.episodes[series.TvdbID]
+	for i := range eps {
+		eps[i].Monitored = slices.ContainsFunc(series.Seasons, func(s sonarr.SonarrSeason) bool {
+			return s.SeasonNumber == eps[i].SeasonNumber && s.Monitored
+		})
+	}
+	return f.put(series), nil
+}
+
+// update replaces a library series. Like in Sonarr, monitoring a season
+// monitors its episodes and unmonitoring it unmonitors them.
+func (f *Sonarr) update(series sonarr.Series) (sonarr.Series, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.library {
+		if f.library[i].ID == series.ID {
+			eps := f.episodes[series.TvdbID]
+			for _, season := range series.Seasons {
+				j := slices.IndexFunc(f.library[i].Seasons, func(s sonarr.SonarrSeason) bool { return s.SeasonNumber == season.SeasonNumber })
+				if j >= 0 && f.library[i].Seasons[j].Monitored == season.Monitored {
+					continue
+				}
+				for k := range eps {
+					if eps[k].SeasonNumber == season.SeasonNumber {
+						eps[k].Monitored = season.Monitored
+					}
+				}
+			}
+			f.library[i] = cloneSeries(series)
+			return cloneSeries(series), nil
+		}
+	}
+	return series, notInLibrary(http.MethodPut, series.ID)
+}
+
+func (f *Sonarr) get(id int) (*sonarr.Series, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range f.library {
+		if s.ID == id {
+			s = cloneSeries(s)
+			return &s, nil
+		}
+	}
+	return nil, notInLibrary(http.MethodGet, id)
+}
+
+func (f *Sonarr) lookupOne(tmdbID int) (*sonarr.Series, error) {
+	lookup := f.lookup(tmdbID)
+	if len(lookup) == 0 {
+		return nil, fmt.Errorf("no series found for tmdb id %d: %w", tmdbID, sonarr.ErrNotFound)
+	}
+	return &lookup[0], nil
+}
+
+func (f *Sonarr) find(tmdbID int) (*sonarr.Series, error) {
+	series, err := f.lookupOne(tmdbID)
+	if err != nil || series.ID == 0 {
+		return nil, err
+	}
+	return series, nil
+}
+
+func (f *Sonarr) seriesEpisodes(seriesID int) []sonarr.Episode {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range f.library {
+		if s.ID == seriesID {
+			return slices.Clone(f.episodes[s.TvdbID])
+		}
+	}
+	return []sonarr.Episode{}
+}
+
+func (f *Sonarr) monitor(episodeIDs []int, monitored bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, eps := range f.episodes {
+		for i := range eps {
+			if slices.Contains(episodeIDs, eps[i].ID) {
+				eps[i].Monitored = monitored
+			}
+		}
+	}
+}
+
+func (f *Sonarr) search(episodeIDs []int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.searched = append(f.searched, episodeIDs...)
+}
+
+func notInLibrary(method string, id int) error {
+	return &sonarr.APIError{
+		Service:    sonarr.Service,
+		StatusCode: http.StatusNotFound,
+		Method:     method,
+		Endpoint:   fmt.Sprintf("/api/v3/series/%d", id),
+		Message:    "NotFound",
+	}
+}
+
+func cloneSeries(s sonarr.Series) sonarr.Series {
+	s.Seasons = slices.Clone(s.Seasons)
+	s.Images = slices.Clone(s.Images)
+	s.Tags = slices.Clone(s.Tags)
+	return s
+}
+
+// NewSonarrServer starts a simulator serving the Sonarr API from f. Close it
+// when done.
+func NewSonarrServer(f *Sonarr) *httptest.Server {
+	return httptest.NewServer(f.Handler())
+}
+
+// Handler serves the parts of the Sonarr v3 API the client uses from f.
+func (f *Sonarr) Handler() http.Handler {
+	authorized := func(r *http.Request) bool {
+		return f.APIKey == "" || r.Header.Get("X-Api-Key") == f.APIKey || r.URL.Query().Get("apikey") == f.APIKey
+	}
+	fail := func(w http.ResponseWriter, err error) {
+		if apiErr, ok := err.(*sonarr.APIError); ok {
+			if len(apiErr.Validation) > 0 {
+				writeJSON(w, apiErr.StatusCode, apiErr.Validation)
+			} else {
+				writeJSON(w, apiErr.StatusCode, map[string]string{"message": apiErr.Message})
+			}
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+	}
+	id := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v3/series/lookup", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		term := r.URL.Query().Get("term")
+		if !strings.HasPrefix(term, "tmdb:") {
+			writeJSON(w, http.StatusOK, []sonarr.Series{})
+			return
+		}
+		writeJSON(w, http.StatusOK, f.lookup(id(strings.TrimPrefix(term, "tmdb:"))))
+	}))
+	mux.HandleFunc("GET /api/v3/series", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		library := f.Series()
+		if v := r.URL.Query().Get("tvdbId"); v != "" {
+			tvdbID := id(v)
+			library = slices.DeleteFunc(library, func(s sonarr.Series) bool { return s.TvdbID != tvdbID })
+		}
+		writeJSON(w, http.StatusOK, library)
+	}))
+	mux.HandleFunc("POST /api/v3/series", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		var series sonarr.Series
+		if err := json.NewDecoder(r.Body).Decode(&series); err != nil {
+			fail(w, err)
+			return
+		}
+		added, err := f.add(series)
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, added)
+	}))
+	mux.HandleFunc("GET /api/v3/series/{id}", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		series, err := f.get(id(r.PathValue("id")))
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, series)
+	}))
+	mux.HandleFunc("PUT /api/v3/series/{id}", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		var series sonarr.Series
+		if err := json.NewDecoder(r.Body).Decode(&series); err != nil {
+			fail(w, err)
+			return
+		}
+		series.ID = id(r.PathValue("id"))
+		updated, err := f.update(series)
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, updated)
+	}))
+	mux.HandleFunc("GET /api/v3/episode", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, f.seriesEpisodes(id(r.URL.Query().Get("seriesId"))))
+	}))
+	mux.HandleFunc("PUT /api/v3/episode/monitor", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		var body sonarr.EpisodesMonitored
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			fail(w, err)
+			return
+		}
+		f.monitor(body.EpisodeIDs, body.Monitored)
+		writeJSON(w, http.StatusAccepted, body.EpisodeIDs)
+	}))
+	mux.HandleFunc("POST /api/v3/command", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		var cmd sonarr.CommandRequest
+		if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+			fail(w, err)
+			return
+		}
+		if cmd.Name == "EpisodeSearch" {
+			f.search(cmd.EpisodeIDs)
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{"id": 1, "name": cmd.Name, "status": "queued"})
+	}))
+	mux.HandleFunc("GET /api/v3/qualityprofile", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, f.QualityProfiles)
+	}))
+	mux.HandleFunc("GET /api/v3/languageprofile", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		if len(f.LanguageProfiles) == 0 {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "NotFound"}) // Sonarr v4
+			return
+		}
+		writeJSON(w, http.StatusOK, f.LanguageProfiles)
+	}))
+	mux.HandleFunc("GET /api/v3/rootfolder", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, f.RootFolders)
+	}))
+	return mux
+}
@@ -0,0 +1,230 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bpouw/gopherseerr/tmdb"
+)
+
+// TMDB is an in-memory TMDB with the movies and shows added to it.
+type TMDB struct {
+	calls
+	APIKey string // the key the simulator expects, none when empty
+
+	mu      sync.Mutex
+	movies  map[int]tmdb.MovieDetails
+	shows   map[int]tmdb.TVShowDetails
+	seasons map[[2]int]tmdb.SeasonDetails // by show and season number
+	tvdbIDs map[int]int                   // TVDB ID to TMDB ID
+}
+
+// NewTMDB returns an empty TMDB.
+func NewTMDB() *TMDB {
+	return &TMDB{
+		movies:  make(map[int]tmdb.MovieDetails),
+		shows:   make(map[int]tmdb.TVShowDetails),
+		seasons: make(map[[2]int]tmdb.SeasonDetails),
+		tvdbIDs: make(map[int]int),
+	}
+}
+
+// AddMovie makes movie known to TMDB.
+func (f *TMDB) AddMovie(movie tmdb.MovieDetails) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.movies[movie.ID] = movie
+}
+
+// AddShow makes show known to TMDB under its TVDB ID, with the episodes of
+// each of its seasons, in the order of show.Seasons. Seasons without
+// episodes given get EpisodeCount numbered ones.
+func (f *TMDB) AddShow(show tmdb.TVShowDetails, tvdbID int, episodes ...[]tmdb.TMDbEpisode) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.shows[show.ID] = show
+	if tvdbID != 0 {
+		f.tvdbIDs[tvdbID] = show.ID
+	}
+	for i, season := range show.Seasons {
+		var eps []tmdb.TMDbEpisode
+		if i < len(episodes) {
+			eps = episodes[i]
+		} else {
+			for n := 1; n <= season.EpisodeCount; n++ {
+				eps = append(eps, tmdb.TMDbEpisode{EpisodeNumber: n, Name: fmt.Sprintf("Episode %d", n)})
+			}
+		}
+		f.seasons[[2]int{show.ID, season.SeasonNumber}] = tmdb.SeasonDetails{Episodes: eps}
+	}
+}
+
+// notFound mirrors the error the TMDB client returns for a 404.
+func notFound(what string) error {
+	return fmt.Errorf("TMDB API returned non-200 status for %s: %d", what, http.StatusNotFound)
+}
+
+// Search returns the movies and then the shows whose title contains query,
+// ignoring case.
+func (f *TMDB) Search(ctx context.Context, query string) ([]tmdb.MediaBasic, error) {
+	if err := f.record("Search"); err != nil {
+		return nil, err
+	}
+	return f.search(query), nil
+}
+
+func (f *TMDB) GetMovieDetails(ctx context.Context, movieID int) (*tmdb.MovieDetails, error) {
+	if err := f.record("GetMovieDetails"); err != nil {
+		return nil, err
+	}
+	return f.movie(movieID)
+}
+
+func (f *TMDB) GetTVShowDetails(ctx context.Context, tvID int) (*tmdb.TVShowDetails, error) {
+	if err := f.record("GetTVShowDetails"); err != nil {
+		return nil, err
+	}
+	return f.show(tvID)
+}
+
+func (f *TMDB) GetSeasonDetails(ctx context.Context, tvID int, seasonNumber int) (*tmdb.SeasonDetails, error) {
+	if err := f.record("GetSeasonDetails"); err != nil {
+		return nil, err
+	}
+	return f.season(tvID, seasonNumber)
+}
+
+// FindTVByTVDB returns the TMDB ID of the show added with tvdbID, or 0.
+func (f *TMDB) FindTVByTVDB(ctx context.Context, tvdbID int) (int, error) {
+	if err := f.record("FindTVByTVDB"); err != nil {
+		return 0, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tvdbIDs[tvdbID], nil
+}
+
+func (f *TMDB) search(query string) []tmdb.MediaBasic {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	query = strings.ToLower(query)
+	var movies, shows []tmdb.MediaBasic
+	for _, m := range f.movies {
+		if strings.Contains(strings.ToLower(m.Title), query) {
+			movies = append(movies, tmdb.MediaBasic{ID: m.ID, Title: m.Title, MediaType: "movie", Overview: m.Overview, PosterPath: m.PosterPath, ReleaseDate: m.ReleaseDate})
+		}
+	}
+	for _, s := range f.shows {
+		if strings.Contains(strings.ToLower(s.Name), query) {
+			shows = append(shows, tmdb.MediaBasic{ID: s.ID, Name: s.Name, MediaType: "tv", Overview: s.Overview, PosterPath: s.PosterPath, FirstAirDate: s.FirstAirDate})
+		}
+	}
+	byID := func(a, b tmdb.MediaBasic) int { return a.ID - b.ID }
+	slices.SortFunc(movies, byID)
+	slices.SortFunc(shows, byID)
+	return append(append([]tmdb.MediaBasic{}, movies...), shows...)
+}
+
+func (f *TMDB) movie(id int) (*tmdb.MovieDetails, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	movie, ok := f.movies[id]
+	if !ok {
+		return nil, notFound("movie details")
+	}
+	return &movie, nil
+}
+
+func (f *TMDB) show(id int) (*tmdb.TVShowDetails, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	show, ok := f.shows[id]
+	if !ok {
+		return nil, notFound("show details")
+	}
+	return &show, nil
+}
+
+func (f *TMDB) season(id, seasonNumber int) (*tmdb.SeasonDetails, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	season, ok := f.seasons[[2]int{id, seasonNumber}]
+	if !ok {
+		return nil, notFound("season details")
+	}
+	return &season, nil
+}
+
+// NewTMDBServer starts a simulator serving the TMDB API from f. Point a
+// tmdb.Client's BaseURL at its URL and Close it when done.
+func NewTMDBServer(f *TMDB) *httptest.Server {
+	return httptest.NewServer(f.Handler())
+}
+
+// Handler serves the parts of the TMDB API the client uses from f.
+func (f *TMDB) Handler() http.Handler {
+	authorized := func(r *http.Request) bool {
+		return f.APIKey == "" || r.URL.Query().Get("api_key") == f.APIKey
+	}
+	// TMDB reports errors with a status_message; the client only looks at
+	// the status code.
+	fail := func(w http.ResponseWriter, err error) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"status_code": 34, "status_message": err.Error()})
+	}
+	id := func(r *http.Request, name string) int {
+		n, _ := strconv.Atoi(r.PathValue(name))
+		return n
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /search/multi", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		results := f.search(r.URL.Query().Get("query"))
+		writeJSON(w, http.StatusOK, tmdb.SearchResult{Page: 1, Results: results, TotalResults: len(results), TotalPages: 1})
+	}))
+	mux.HandleFunc("GET /movie/{id}", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		movie, err := f.movie(id(r, "id"))
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, movie)
+	}))
+	mux.HandleFunc("GET /tv/{id}", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		show, err := f.show(id(r, "id"))
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, show)
+	}))
+	mux.HandleFunc("GET /tv/{id}/season/{season}", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		season, err := f.season(id(r, "id"), id(r, "season"))
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, season)
+	}))
+	mux.HandleFunc("GET /find/{id}", f.route(authorized, func(w http.ResponseWriter, r *http.Request) {
+		var result struct {
+			TVResults []tmdb.MediaBasic `json:"tv_results"`
+		}
+		result.TVResults = []tmdb.MediaBasic{}
+		if r.URL.Query().Get("external_source") == "tvdb_id" {
+			f.mu.Lock()
+			tmdbID := f.tvdbIDs[id(r, "id")]
+			f.mu.Unlock()
+			if tmdbID != 0 {
+				result.TVResults = append(result.TVResults, tmdb.MediaBasic{ID: tmdbID, MediaType: "tv"})
+			}
+		}
+		writeJSON(w, http.StatusOK, result)
+	}))
+	return mux
+}
@@ -1,3 +1,11 @@
 module github.com/bpouw/gopherseerr
 
 go 1.24.4
+
+require golang.org/x/crypto v0.40.0
+
+require (
+	github.com/BurntSushi/toml v1.4.0
+	golang.org/x/sync v0.16.0
+	gopkg.in/yaml.v3 v3.0.1
+)
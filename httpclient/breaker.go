@@ -0,0 +1,92 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// UnreachableError is returned without contacting the service while its
+// circuit breaker is open.
+type UnreachableError struct {
+	Service string
+	Until   time.Time // when the next call is let through to try again
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("%s is unreachable", e.Service)
+}
+
+// breakerTransport stops calling a service after threshold consecutive
+// failures. Once cooldown has passed a single call is let through; if it
+// succeeds the service is considered back.
+type breakerTransport struct {
+	base      http.RoundTripper
+	service   string
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case err != nil && errors.Is(req.Context().Err(), context.Canceled):
+		t.release() // the caller gave up, that says nothing about the service
+	case err != nil || resp.StatusCode >= 500:
+		t.record(false)
+	default:
+		t.record(true)
+	}
+	return resp, err
+}
+
+func (t *breakerTransport) allow() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failures < t.threshold {
+		return nil
+	}
+	if time.Now().Before(t.openUntil) || t.probing {
+		return &UnreachableError{Service: t.service, Until: t.openUntil}
+	}
+	t.probing = true
+	return nil
+}
+
+func (t *breakerTransport) release() {
+	t.mu.Lock()
+	t.probing = false
+	t.mu.Unlock()
+}
+
+func (t *breakerTransport) record(ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.probing = false
+	if ok {
+		if t.failures >= t.threshold {
+			log.Printf("%s is reachable again", t.service)
+		}
+		t.failures = 0
+		return
+	}
+	t.failures++
+	if t.failures >= t.threshold {
+		if t.failures == t.threshold {
+			log.Printf("%s failed %d times in a row, pausing calls for %s", t.service, t.failures, t.cooldown)
+		}
+		t.openUntil = time.Now().Add(t.cooldown)
+	}
+}
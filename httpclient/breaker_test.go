@@ -0,0 +1,173 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// stubService answers every call with the status or error currently set,
+// counting the calls that reach it.
+type stubService struct {
+	mu     sync.Mutex
+	status int
+	err    error
+	calls  int
+}
+
+func (s *stubService) set(status int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status, s.err = status, err
+}
+
+func (s *stubService) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	w := httptest.NewRecorder()
+	w.WriteHeader(s.status)
+	return w.Result(), nil
+}
+
+func (s *stubService) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func get(t *testing.T, rt http.RoundTripper) error {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "http://sonarr.local/api/v3/series", nil)
+	resp, err := rt.RoundTrip(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+func TestBreakerOpens(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		err    error
+	}{
+		{"5xx", http.StatusInternalServerError, nil},
+		{"network error", 0, errors.New("connection refused")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubService{status: tt.status, err: tt.err}
+			b := &breakerTransport{base: svc, service: "Sonarr", threshold: 3, cooldown: time.Hour}
+			for range 3 {
+				get(t, b)
+			}
+			var unreachable *UnreachableError
+			if err := get(t, b); !errors.As(err, &unreachable) || unreachable.Service != "Sonarr" {
+				t.Fatalf("got %v, want an UnreachableError for Sonarr", err)
+			}
+			if svc.count() != 3 {
+				t.Errorf("service got %d calls, want 3", svc.count())
+			}
+			if time.Until(unreachable.Until) < 59*time.Minute {
+				t.Errorf("open until %s, want an hour from now", unreachable.Until)
+			}
+		})
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	svc := &stubService{status: http.StatusBadGateway}
+	b := &breakerTransport{base: svc, service: "Sonarr", threshold: 2, cooldown: time.Hour}
+	get(t, b)
+	svc.set(http.StatusNotFound, nil) // a 4xx means the service is up
+	get(t, b)
+	svc.set(http.StatusBadGateway, nil)
+	get(t, b)
+	if err := get(t, b); err != nil {
+		t.Fatalf("breaker opened after one failure in a row: %v", err)
+	}
+	if err := get(t, b); err == nil || svc.count() != 4 {
+		t.Errorf("breaker did not open after two failures in a row, %d calls", svc.count())
+	}
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+	svc := &stubService{status: http.StatusServiceUnavailable}
+	b := &breakerTransport{base: svc, service: "Sonarr", threshold: 1, cooldown: 10 * time.Millisecond}
+	get(t, b)
+	if err := get(t, b); err == nil {
+		t.Fatal("breaker did not open")
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// The probe fails: the breaker stays open for another cooldown.
+	get(t, b)
+	if err := get(t, b); err == nil || svc.count() != 2 {
+		t.Fatalf("breaker closed after a failed probe, %d calls", svc.count())
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// While a probe is in flight, other calls are still turned away.
+	release := make(chan struct{})
+	probing := make(chan struct{})
+	svc.set(http.StatusOK, nil)
+	slow := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		close(probing)
+		<-release
+		return svc.RoundTrip(req)
+	})
+	b.base = slow
+	done := make(chan error)
+	go func() { done <- get(t, b) }()
+	<-probing
+	b.base = svc
+	if err := get(t, b); err == nil {
+		t.Error("a second call was let through during the probe")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	// The probe succeeded: the breaker is closed.
+	for range 3 {
+		if err := get(t, b); err != nil {
+			t.Fatalf("breaker still open after a successful probe: %v", err)
+		}
+	}
+}
+
+func TestBreakerReleasesCanceledProbe(t *testing.T) {
+	svc := &stubService{status: http.StatusInternalServerError}
+	b := &breakerTransport{base: svc, service: "Sonarr", threshold: 1, cooldown: time.Millisecond}
+	get(t, b)
+	time.Sleep(5 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.base = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		cancel() // the caller gives up during the probe
+		return nil, req.Context().Err()
+	})
+	req := httptest.NewRequest(http.MethodGet, "http://sonarr.local/", nil).WithContext(ctx)
+	if _, err := b.RoundTrip(req); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want the cancellation", err)
+	}
+
+	b.base = svc
+	svc.set(http.StatusOK, nil)
+	if err := get(t, b); err != nil {
+		t.Errorf("the canceled probe kept the breaker from probing again: %v", err)
+	}
+}
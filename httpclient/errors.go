@@ -0,0 +1,102 @@
+package httpclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Service describes a Radarr-like API to the errors of its responses: the
+// name used in messages, the errors they match with errors.Is and the
+// validation error code of an item that was already added.
+type Service struct {
+	Name       string // e.g. "Radarr"
+	ExistsCode string // e.g. "MovieExistsValidator"
+
+	AlreadyExists error
+	NotFound      error
+	Unauthorized  error
+}
+
+// ValidationFailure is one entry of the validation errors Radarr and Sonarr
+// return with a 400 response.
+type ValidationFailure struct {
+	PropertyName string `json:"propertyName"`
+	ErrorMessage string `json:"errorMessage"`
+	ErrorCode    string `json:"errorCode"`
+}
+
+// APIError is a response with an unexpected status code. It matches the
+// sentinel errors of its Service that fit its status code or validation
+// errors.
+type APIError struct {
+	Service    *Service
+	StatusCode int
+	Method     string
+	Endpoint   string // path of the call, without the API key
+	Message    string // message or raw body of the response
+	Validation []ValidationFailure
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if len(e.Validation) > 0 {
+		msg = e.ValidationMessage()
+	}
+	return fmt.Sprintf("%s API returned status %d for %s %s: %s", strings.ToLower(e.Service.Name), e.StatusCode, e.Method, e.Endpoint, msg)
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case nil:
+		return false
+	case e.Service.Unauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case e.Service.NotFound:
+		return e.StatusCode == http.StatusNotFound
+	case e.Service.AlreadyExists:
+		for _, v := range e.Validation {
+			if v.ErrorCode == e.Service.ExistsCode || strings.Contains(v.ErrorMessage, "already been added") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidationMessage joins the messages of the validation errors.
+func (e *APIError) ValidationMessage() string {
+	msgs := make([]string, len(e.Validation))
+	for i, v := range e.Validation {
+		msgs[i] = v.ErrorMessage
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// NewAPIError reads the body of a failed response from service.
+func NewAPIError(service *Service, resp *http.Response) *APIError {
+	e := &APIError{
+		Service:    service,
+		StatusCode: resp.StatusCode,
+		Method:     resp.Request.Method,
+		Endpoint:   resp.Request.URL.Path,
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		e.Message = "error reading response body: " + err.Error()
+		return e
+	}
+	var message struct {
+		Message string `json:"message"`
+	}
+	switch {
+	case json.Unmarshal(body, &e.Validation) == nil:
+	case json.Unmarshal(body, &message) == nil && message.Message != "":
+		e.Message = message.Message
+	default:
+		e.Message = strings.TrimSpace(string(body))
+	}
+	return e
+}
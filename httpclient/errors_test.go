@@ -0,0 +1,93 @@
+package httpclient_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bpouw/gopherseerr/httpclient"
+	"github.com/bpouw/gopherseerr/radarr"
+	"github.com/bpouw/gopherseerr/sonarr"
+)
+
+func failedResponse(t *testing.T, status int, body string) *http.Response {
+	t.Helper()
+	w := httptest.NewRecorder()
+	w.WriteHeader(status)
+	w.WriteString(body)
+	resp := w.Result()
+	resp.Request = httptest.NewRequest(http.MethodPost, "/api/v3/series?apikey=secret", nil)
+	return resp
+}
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		name    string
+		service *httpclient.Service
+		status  int
+		body    string
+		matches []error
+		message string
+	}{
+		{
+			name:    "already added",
+			service: sonarr.Service,
+			status:  http.StatusBadRequest,
+			body:    `[{"propertyName": "TvdbId", "errorMessage": "This series has already been added", "errorCode": "SeriesExistsValidator"}]`,
+			matches: []error{sonarr.ErrAlreadyExists},
+			message: "sonarr API returned status 400 for POST /api/v3/series: This series has already been added",
+		},
+		{
+			name:    "unauthorized",
+			service: radarr.Service,
+			status:  http.StatusUnauthorized,
+			body:    `{"message": "Unauthorized"}`,
+			matches: []error{radarr.ErrUnauthorized},
+			message: "radarr API returned status 401 for POST /api/v3/series: Unauthorized",
+		},
+		{
+			name:    "forbidden",
+			service: radarr.Service,
+			status:  http.StatusForbidden,
+			body:    "",
+			matches: []error{radarr.ErrUnauthorized},
+		},
+		{
+			name:    "not found",
+			service: sonarr.Service,
+			status:  http.StatusNotFound,
+			body:    "NotFound\n",
+			matches: []error{sonarr.ErrNotFound},
+			message: "sonarr API returned status 404 for POST /api/v3/series: NotFound",
+		},
+		{
+			name:    "server error",
+			service: radarr.Service,
+			status:  http.StatusInternalServerError,
+			body:    "<html>oops</html>",
+			message: "radarr API returned status 500 for POST /api/v3/series: <html>oops</html>",
+		},
+	}
+	sentinels := []error{
+		radarr.ErrAlreadyExists, radarr.ErrNotFound, radarr.ErrUnauthorized,
+		sonarr.ErrAlreadyExists, sonarr.ErrNotFound, sonarr.ErrUnauthorized,
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := httpclient.NewAPIError(tt.service, failedResponse(t, tt.status, tt.body))
+			for _, sentinel := range sentinels {
+				want := false
+				for _, m := range tt.matches {
+					want = want || m == sentinel
+				}
+				if got := errors.Is(err, sentinel); got != want {
+					t.Errorf("errors.Is(err, %q) = %v, want %v", sentinel, got, want)
+				}
+			}
+			if tt.message != "" && err.Error() != tt.message {
+				t.Errorf("message = %q, want %q", err.Error(), tt.message)
+			}
+		})
+	}
+}
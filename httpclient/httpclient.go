@@ -0,0 +1,157 @@
+// Package httpclient builds the HTTP clients the TMDB, Radarr and Sonarr
+// clients talk through, so timeouts, proxies and TLS settings are configured
+// in one place.
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Defaults used for zero Options fields.
+const (
+	DefaultTimeout     = 30 * time.Second
+	DefaultDialTimeout = 10 * time.Second
+	DefaultUserAgent   = "gopherseerr"
+
+	DefaultMaxRetries       = 3
+	DefaultRetryDelay       = 500 * time.Millisecond
+	DefaultMaxRetryDelay    = 10 * time.Second
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// Options configures the HTTP client of a service client.
+type Options struct {
+	// HTTPClient is used as is when set; the other fields are ignored.
+	HTTPClient *http.Client
+
+	Timeout               time.Duration // whole request including the body, defaults to DefaultTimeout
+	DialTimeout           time.Duration // establishing the TCP connection, defaults to DefaultDialTimeout
+	ResponseHeaderTimeout time.Duration // waiting for the response headers, 0 means only Timeout applies
+	UserAgent             string        // defaults to DefaultUserAgent
+
+	CAFile             string // PEM file with extra CA certificates, e.g. for a self-signed Sonarr
+	InsecureSkipVerify bool   // accept any certificate; prefer CAFile
+
+	Proxy string // proxy URL; empty uses the HTTP_PROXY/HTTPS_PROXY environment variables
+
+	// GET requests that fail with a network error, a 5xx or a 429 are retried
+	// up to MaxRetries times, backing off exponentially from RetryDelay up to
+	// MaxRetryDelay. A Retry-After header longer than MaxRetryDelay is not
+	// waited for. A negative MaxRetries disables retries.
+	MaxRetries    int
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+
+	// After BreakerThreshold failed calls in a row, calls fail straight away
+	// with an *UnreachableError for BreakerCooldown. A negative threshold
+	// disables the breaker. Service names the service in that error.
+	Service          string
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// New returns an *http.Client configured by opts.
+func New(opts Options) (*http.Client, error) {
+	if opts.HTTPClient != nil {
+		return opts.HTTPClient, nil
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = DefaultDialTimeout
+	}
+	if opts.UserAgent == "" {
+		opts.UserAgent = DefaultUserAgent
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultMaxRetries
+	}
+	if opts.RetryDelay == 0 {
+		opts.RetryDelay = DefaultRetryDelay
+	}
+	if opts.MaxRetryDelay == 0 {
+		opts.MaxRetryDelay = DefaultMaxRetryDelay
+	}
+	if opts.BreakerThreshold == 0 {
+		opts.BreakerThreshold = DefaultBreakerThreshold
+	}
+	if opts.BreakerCooldown == 0 {
+		opts.BreakerCooldown = DefaultBreakerCooldown
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   opts.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.ResponseHeaderTimeout = opts.ResponseHeaderTimeout
+
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %w", opts.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if opts.CAFile != "" || opts.InsecureSkipVerify {
+		tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+		if opts.CAFile != "" {
+			pool, err := loadCAFile(opts.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	var rt http.RoundTripper = &userAgentTransport{base: transport, userAgent: opts.UserAgent}
+	if opts.MaxRetries > 0 {
+		rt = &retryTransport{base: rt, maxRetries: opts.MaxRetries, delay: opts.RetryDelay, maxDelay: opts.MaxRetryDelay}
+	}
+	if opts.BreakerThreshold > 0 {
+		rt = &breakerTransport{base: rt, service: opts.Service, threshold: opts.BreakerThreshold, cooldown: opts.BreakerCooldown}
+	}
+	return &http.Client{Timeout: opts.Timeout, Transport: rt}, nil
+}
+
+// loadCAFile returns the system roots plus the certificates in path.
+func loadCAFile(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in CA file %s", path)
+	}
+	return pool, nil
+}
+
+// userAgentTransport sets the User-Agent header on requests that do not
+// carry one yet.
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.base.RoundTrip(req)
+}
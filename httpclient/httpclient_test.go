@@ -0,0 +1,213 @@
+package httpclient
+
+import (
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// noRetries are options that make every call exactly once.
+var noRetries = Options{MaxRetries: -1, BreakerThreshold: -1}
+
+// slowServer waits delay before answering, or until the client goes away.
+func slowServer(t *testing.T, delay time.Duration, headersFirst bool) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if headersFirst {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func timeoutError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func TestTimeouts(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         Options
+		headersFirst bool
+	}{
+		{"whole request", Options{Timeout: 50 * time.Millisecond}, false},
+		{"body after the headers", Options{Timeout: 50 * time.Millisecond}, true},
+		{"response headers", Options{ResponseHeaderTimeout: 50 * time.Millisecond}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := slowServer(t, 10*time.Second, tt.headersFirst)
+			opts := tt.opts
+			opts.MaxRetries, opts.BreakerThreshold = -1, -1
+			client, err := New(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			resp, err := client.Get(srv.URL)
+			if err == nil {
+				_, err = resp.Body.Read(make([]byte, 1))
+				resp.Body.Close()
+			}
+			if !timeoutError(err) {
+				t.Errorf("got %v, want a timeout", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("timed out after %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestDroppedConnection(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer srv.Close()
+	client, _ := New(noRetries)
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || string(body) != "partial" {
+		t.Errorf("read %q and %v, want the partial body and an unexpected EOF", body, err)
+	}
+}
+
+// writeCA writes the certificate of a TLS test server to a PEM file.
+func writeCA(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	ca := writeCA(t, srv)
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr string
+	}{
+		{"unknown CA", Options{}, "certificate"},
+		{"CA file", Options{CAFile: ca}, ""},
+		{"insecure_skip_verify", Options{InsecureSkipVerify: true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.MaxRetries, opts.BreakerThreshold = -1, -1
+			client, err := New(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("got %v, want success", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("got %v, want an error about the %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(notPEM, []byte("not a certificate"), 0o600)
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr string
+	}{
+		{"missing CA file", Options{CAFile: filepath.Join(t.TempDir(), "missing.pem")}, "failed to read CA file"},
+		{"CA file without certificates", Options{CAFile: notPEM}, "no PEM certificates found"},
+		{"proxy URL", Options{Proxy: "http://proxy:port"}, "invalid proxy URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.opts); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.Method+" "+r.URL.String())
+		w.Write([]byte("via proxy"))
+	}))
+	defer proxy.Close()
+
+	opts := noRetries
+	opts.Proxy = proxy.URL
+	client, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get("http://sonarr.invalid:8989/api/v3/system/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(proxied) != 1 || proxied[0] != "GET http://sonarr.invalid:8989/api/v3/system/status" {
+		t.Errorf("proxy saw %v", proxied)
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.UserAgent())
+	}))
+	defer srv.Close()
+	client, _ := New(noRetries)
+	client.Get(srv.URL)
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("User-Agent", "custom")
+	client.Do(req)
+	if len(got) != 2 || got[0] != DefaultUserAgent || got[1] != "custom" {
+		t.Errorf("user agents = %q", got)
+	}
+}
+
+func TestHTTPClientUsedAsIs(t *testing.T) {
+	own := &http.Client{}
+	if client, err := New(Options{HTTPClient: own, Proxy: "http://proxy:port"}); err != nil || client != own {
+		t.Errorf("got %p, %v; want the given client", client, err)
+	}
+}
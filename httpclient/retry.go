@@ -0,0 +1,91 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryTransport retries idempotent requests that failed with a network error,
+// a 5xx or a 429, waiting with jittered exponential backoff in between. A
+// Retry-After header takes precedence over the backoff.
+type retryTransport struct {
+	base       http.RoundTripper
+	maxRetries int
+	delay      time.Duration
+	maxDelay   time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.base.RoundTrip(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt == t.maxRetries || !retryable(req.Context(), resp, err) {
+			return resp, err
+		}
+
+		wait := t.backoff(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp); ok {
+				if after > t.maxDelay {
+					return resp, nil // not worth waiting for, let the caller see the 429/503
+				}
+				wait = after
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// backoff returns the delay before retry attempt+1: the exponential delay
+// with up to half of it taken off at random, so clients do not retry in step.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	d := t.delay << attempt
+	if d > t.maxDelay || d <= 0 {
+		d = t.maxDelay
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses the Retry-After header, given either in seconds or as an
+// HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
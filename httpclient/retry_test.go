@@ -0,0 +1,153 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer answers the first failures requests with status, or drops
+// their connection when status is 0, and the rest with 200. It counts the
+// requests it got in hits.
+func flakyServer(t *testing.T, failures int, status int, hits *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(hits.Add(1)) > failures {
+			w.Write([]byte("ok"))
+			return
+		}
+		if status == 0 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// fastRetries are options that retry without waiting long and without a
+// breaker getting in the way.
+func fastRetries(maxRetries int) Options {
+	return Options{MaxRetries: maxRetries, RetryDelay: time.Millisecond, MaxRetryDelay: 5 * time.Millisecond, BreakerThreshold: -1}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		failures   int
+		status     int // 0 drops the connection
+		maxRetries int
+		wantHits   int32
+		wantStatus int // 0 expects an error
+	}{
+		{"500", http.MethodGet, 2, http.StatusInternalServerError, 3, 3, http.StatusOK},
+		{"502", http.MethodGet, 1, http.StatusBadGateway, 3, 2, http.StatusOK},
+		{"503", http.MethodGet, 1, http.StatusServiceUnavailable, 3, 2, http.StatusOK},
+		{"504", http.MethodGet, 1, http.StatusGatewayTimeout, 3, 2, http.StatusOK},
+		{"429", http.MethodGet, 1, http.StatusTooManyRequests, 3, 2, http.StatusOK},
+		{"HEAD", http.MethodHead, 1, http.StatusServiceUnavailable, 3, 2, http.StatusOK},
+		{"dropped connection", http.MethodGet, 2, 0, 3, 3, http.StatusOK},
+		{"gives up", http.MethodGet, 5, http.StatusServiceUnavailable, 2, 3, http.StatusServiceUnavailable},
+		{"gives up on dropped connections", http.MethodGet, 5, 0, 2, 3, 0},
+		{"not a 4xx", http.MethodGet, 1, http.StatusNotFound, 3, 1, http.StatusNotFound},
+		{"not a 501", http.MethodGet, 1, http.StatusNotImplemented, 3, 1, http.StatusNotImplemented},
+		{"not a POST", http.MethodPost, 1, http.StatusServiceUnavailable, 3, 1, http.StatusServiceUnavailable},
+		{"not a POST with a dropped connection", http.MethodPost, 1, 0, 3, 1, 0},
+		{"not a PUT", http.MethodPut, 1, http.StatusInternalServerError, 3, 1, http.StatusInternalServerError},
+		{"not a DELETE", http.MethodDelete, 1, http.StatusBadGateway, 3, 1, http.StatusBadGateway},
+		{"disabled", http.MethodGet, 1, http.StatusServiceUnavailable, -1, 1, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+			srv := flakyServer(t, tt.failures, tt.status, &hits)
+			client, err := New(fastRetries(tt.maxRetries))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, _ := http.NewRequest(tt.method, srv.URL, nil)
+			resp, err := client.Do(req)
+			switch {
+			case tt.wantStatus == 0 && err == nil:
+				resp.Body.Close()
+				t.Errorf("got status %d, want an error", resp.StatusCode)
+			case tt.wantStatus != 0 && err != nil:
+				t.Errorf("got %v, want status %d", err, tt.wantStatus)
+			case tt.wantStatus != 0:
+				resp.Body.Close()
+				if resp.StatusCode != tt.wantStatus {
+					t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+				}
+			}
+			if got := hits.Load(); got != tt.wantHits {
+				t.Errorf("server got %d requests, want %d", got, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		wantHits   int32
+		wantStatus int
+	}{
+		{"short", "0", 2, http.StatusOK},
+		{"longer than the max delay", "3600", 1, http.StatusTooManyRequests},
+		{"HTTP date in the past", "Mon, 02 Jan 2006 15:04:05 GMT", 2, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if hits.Add(1) == 1 {
+					w.Header().Set("Retry-After", tt.retryAfter)
+					w.WriteHeader(http.StatusTooManyRequests)
+				}
+			}))
+			defer srv.Close()
+			client, _ := New(fastRetries(3))
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus || hits.Load() != tt.wantHits {
+				t.Errorf("got status %d after %d requests, want %d after %d", resp.StatusCode, hits.Load(), tt.wantStatus, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestRetryStopsWhenCanceled(t *testing.T) {
+	var hits atomic.Int32
+	srv := flakyServer(t, 10, http.StatusServiceUnavailable, &hits)
+	client, _ := New(Options{RetryDelay: time.Hour, MaxRetryDelay: time.Hour, BreakerThreshold: -1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	start := time.Now()
+	_, err := client.Do(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("returned after %s, want right after the deadline", elapsed)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("server got %d requests, want 1", hits.Load())
+	}
+}
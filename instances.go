@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/bpouw/gopherseerr/store"
+)
+
+// InstanceConfig is one entry of radarr_servers or sonarr_servers, e.g. a
+// separate 4K Radarr or an anime Sonarr, with its own defaults.
+type InstanceConfig struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	APIKey string `json:"api_key"`
+
+	// Requests without a chosen server go to the first server whose criteria
+	// all match the title, else to the default one (or the first listed).
+	Default          bool     `json:"default"`
+	Genres           []string `json:"genres"`            // TMDB genre names, any of them matches
+	OriginalLanguage string   `json:"original_language"` // ISO 639-1 code, e.g. "ja"
+
+	QualityProfile  ProfileRef       `json:"quality_profile"`
+	LanguageProfile ProfileRef       `json:"language_profile"` // Sonarr v3 only
+	RootFolder      string           `json:"root_folder"`
+	RootFolderRules []RootFolderRule `json:"root_folder_rules"`
+	HTTP            HTTPConfig       `json:"http"` // overrides http and radarr_http/sonarr_http
+}
+
+func (c InstanceConfig) hasCriteria() bool {
+	return len(c.Genres) > 0 || c.OriginalLanguage != ""
+}
+
+// radarrInstances returns radarr_servers, or else the single Radarr of the
+// radarr_* settings, which has no name.
+func (c *Config) radarrInstances() []InstanceConfig {
+	if len(c.RadarrServers) > 0 {
+		return c.RadarrServers
+	}
+	return []InstanceConfig{{
+		URL:             c.RadarrURL,
+		APIKey:          c.RadarrApiKey,
+		QualityProfile:  c.RadarrQualityProfile,
+		RootFolder:      c.RadarrRootFolder,
+		RootFolderRules: c.RadarrRootFolderRules,
+	}}
+}
+
+// sonarrInstances returns sonarr_servers, or else the single Sonarr of the
+// sonarr_* settings, which has no name.
+func (c *Config) sonarrInstances() []InstanceConfig {
+	if len(c.SonarrServers) > 0 {
+		return c.SonarrServers
+	}
+	return []InstanceConfig{{
+		URL:             c.SonarrURL,
+		APIKey:          c.SonarrApiKey,
+		QualityProfile:  c.SonarrQualityProfile,
+		LanguageProfile: c.SonarrLanguageProfile,
+		RootFolder:      c.SonarrRootFolder,
+		RootFolderRules: c.SonarrRootFolderRules,
+	}}
+}
+
+// validateServers checks a radarr_servers or sonarr_servers list. single
+// names the radarr_*/sonarr_* settings that are set as well, which the list
+// replaces.
+func validateServers(key string, servers []InstanceConfig, single []string) []error {
+	var errs []error
+	problem := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	if len(servers) == 0 {
+		return nil
+	}
+	if len(single) > 0 {
+		problem("%s replaces %s, set them per server instead", key, strings.Join(single, ", "))
+	}
+	names := make(map[string]bool)
+	defaults := 0
+	for i, srv := range servers {
+		switch {
+		case srv.Name == "":
+			problem("%s[%d]: name is missing", key, i)
+		case names[strings.ToLower(srv.Name)]:
+			problem("%s[%d]: name %q is used twice", key, i, srv.Name)
+		}
+		names[strings.ToLower(srv.Name)] = true
+		if parsed, err := url.Parse(srv.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			problem("%s[%d]: url %q is not an http:// or https:// URL", key, i, srv.URL)
+		}
+		if srv.APIKey == "" {
+			problem("%s[%d]: api_key is missing", key, i)
+		}
+		if _, err := srv.HTTP.options(); err != nil {
+			problem("%s[%d]: http: %w", key, i, err)
+		}
+		if srv.Default {
+			defaults++
+		}
+	}
+	if defaults > 1 {
+		problem("%s: only one server can be the default", key)
+	}
+	return errs
+}
+
+// setKeys returns the settings that are set, sorted.
+func setKeys(set map[string]bool) []string {
+	var keys []string
+	for k, ok := range set {
+		if ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// instance is what Radarr and Sonarr servers have in common: their name and
+// the rule that picks them for a request.
+type instance struct {
+	name      string // empty for the single server of the radarr_*/sonarr_* settings
+	label     string // for messages, e.g. "Radarr (4K)"
+	isDefault bool   // exactly one server per service is the default
+	config    InstanceConfig
+}
+
+func newInstance(service string, c InstanceConfig) instance {
+	label := service
+	if c.Name != "" {
+		label = fmt.Sprintf("%s (%s)", service, c.Name)
+	}
+	return instance{name: c.Name, label: label, config: c}
+}
+
+// markDefault makes the configured default, or else the first server, the
+// default one.
+func markDefault(list []*instance) {
+	for _, i := range list {
+		if i.config.Default {
+			i.isDefault = true
+			return
+		}
+	}
+	if len(list) > 0 {
+		list[0].isDefault = true
+	}
+}
+
+// owns reports whether a request was sent to this server. Requests recorded
+// without a server went to the default one.
+func (i *instance) owns(req store.Request) bool {
+	if req.Server == "" {
+		return i.isDefault
+	}
+	return strings.EqualFold(req.Server, i.name)
+}
+
+// unknownServerError is returned for a request or webhook naming a server
+// that is not configured.
+type unknownServerError struct {
+	service string
+	name    string
+}
+
+func (e *unknownServerError) Error() string {
+	return fmt.Sprintf("There is no %s server named %q", e.service, e.name)
+}
+
+// findInstance returns the index of the server called name, or of the
+// default server when name is empty.
+func findInstance(service string, list []*instance, name string) (int, error) {
+	for i, inst := range list {
+		if (name == "" && inst.isDefault) || (name != "" && strings.EqualFold(inst.name, name)) {
+			return i, nil
+		}
+	}
+	return 0, &unknownServerError{service: service, name: name}
+}
+
+// chooseInstance returns the index of the server a request goes to: the one
+// it names, else the first whose criteria match the title, else the default.
+func chooseInstance(ctx context.Context, service string, list []*instance, req store.Request, details func(ctx context.Context, tmdbID int) (mediaDetails, error)) (int, error) {
+	if req.Server != "" || len(list) == 1 {
+		return findInstance(service, list, req.Server)
+	}
+	var d *mediaDetails
+	for i, inst := range list {
+		if !inst.config.hasCriteria() {
+			continue
+		}
+		if d == nil {
+			got, err := details(ctx, req.TMDBID)
+			if err != nil {
+				log.Printf("Failed to fetch TMDB details to choose a %s server: %v", service, err)
+				break
+			}
+			d = &got
+		}
+		if inst.config.OriginalLanguage != "" && !strings.EqualFold(inst.config.OriginalLanguage, d.OriginalLanguage) {
+			continue
+		}
+		if len(inst.config.Genres) > 0 && !containsAnyFold(d.Genres, inst.config.Genres) {
+			continue
+		}
+		return i, nil
+	}
+	return findInstance(service, list, "")
+}
+
+// radarrInstance is one configured Radarr.
+type radarrInstance struct {
+	MovieManager
+	instance
+	qualityProfile *profileResolver
+	rootFolders    *rootFolderSet
+}
+
+// sonarrInstance is one configured Sonarr.
+type sonarrInstance struct {
+	SeriesManager
+	instance
+	qualityProfile  *profileResolver
+	languageProfile *profileResolver
+	rootFolders     *rootFolderSet
+}
+
+func (s *server) radarrBases() []*instance {
+	out := make([]*instance, len(s.radarrs))
+	for i, r := range s.radarrs {
+		out[i] = &r.instance
+	}
+	return out
+}
+
+func (s *server) sonarrBases() []*instance {
+	out := make([]*instance, len(s.sonarrs))
+	for i, r := range s.sonarrs {
+		out[i] = &r.instance
+	}
+	return out
+}
+
+// radarrFor picks the Radarr a movie request goes to.
+func (s *server) radarrFor(ctx context.Context, req store.Request) (*radarrInstance, error) {
+	i, err := chooseInstance(ctx, "Radarr", s.radarrBases(), req, s.movieDetails)
+	if err != nil {
+		return nil, err
+	}
+	return s.radarrs[i], nil
+}
+
+// sonarrFor picks the Sonarr a TV request goes to. Without a chosen server a
+// Sonarr that already has the show wins, so seasons and episodes are added
+// to the series there.
+func (s *server) sonarrFor(ctx context.Context, req store.Request) (*sonarrInstance, error) {
+	if req.Server == "" && len(s.sonarrs) > 1 {
+		for _, son := range s.sonarrs {
+			series, err := son.FindSeriesByTMDB(ctx, req.TMDBID)
+			if err != nil {
+				log.Printf("Failed to look up the show in %s: %v", son.label, err)
+				continue
+			}
+			if series != nil {
+				return son, nil
+			}
+		}
+	}
+	i, err := chooseInstance(ctx, "Sonarr", s.sonarrBases(), req, s.showDetails)
+	if err != nil {
+		return nil, err
+	}
+	return s.sonarrs[i], nil
+}
+
+// checkServer reports a request naming a server that does not exist before
+// it is recorded.
+func (s *server) checkServer(req store.Request) error {
+	if req.Server == "" {
+		return nil
+	}
+	var err error
+	switch req.MediaType {
+	case "movie":
+		_, err = findInstance("Radarr", s.radarrBases(), req.Server)
+	case "tv":
+		_, err = findInstance("Sonarr", s.sonarrBases(), req.Server)
+	}
+	return err
+}
+
+// serverName is what a request records as the server it went to: nothing
+// while there is only one.
+func serverName(inst *instance, count int) string {
+	if count < 2 {
+		return ""
+	}
+	return inst.name
+}
+
+// serverOptions feeds the pickers of one server on request forms.
+type serverOptions struct {
+	Name         string
+	Default      bool
+	Picker       *profilePicker
+	FolderPicker *rootFolderPicker
+}
+
+// requestOptions feeds the server chooser, shown when there is more than one
+// server, and the profile and root folder pickers of each server.
+type requestOptions struct {
+	Servers []serverOptions
+}
+
+func (o *requestOptions) Chooser() bool {
+	return len(o.Servers) > 1
+}
+
+func (s *server) radarrOptions(r *http.Request) *requestOptions {
+	opts := &requestOptions{}
+	for _, inst := range s.radarrs {
+		opts.Servers = append(opts.Servers, serverOptions{
+			Name:         inst.name,
+			Default:      inst.isDefault,
+			Picker:       s.newProfilePicker(r.Context(), inst.qualityProfile),
+			FolderPicker: newRootFolderPicker(r, inst.rootFolders),
+		})
+	}
+	return opts
+}
+
+func (s *server) sonarrOptions(r *http.Request) *requestOptions {
+	opts := &requestOptions{}
+	for _, inst := range s.sonarrs {
+		opts.Servers = append(opts.Servers, serverOptions{
+			Name:         inst.name,
+			Default:      inst.isDefault,
+			Picker:       s.newProfilePicker(r.Context(), inst.qualityProfile),
+			FolderPicker: newRootFolderPicker(r, inst.rootFolders),
+		})
+	}
+	return opts
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bpouw/gopherseerr/sonarr"
+	"github.com/bpouw/gopherseerr/store"
+	"github.com/bpouw/gopherseerr/tmdb"
+)
+
+func TestChooseInstance(t *testing.T) {
+	configs := []InstanceConfig{
+		{Name: "main"},
+		{Name: "anime", Genres: []string{"Animation"}, OriginalLanguage: "ja"},
+		{Name: "docs", Genres: []string{"Documentary", "History"}},
+	}
+	tests := []struct {
+		name    string
+		req     store.Request
+		details mediaDetails
+		failing bool // the TMDB lookup fails
+		dflt    int  // the server configured as the default, if not the first
+		want    int
+	}{
+		{name: "genre and language match", details: mediaDetails{Genres: []string{"Action", "Animation"}, OriginalLanguage: "ja"}, want: 1},
+		{name: "language ignores case", details: mediaDetails{Genres: []string{"animation"}, OriginalLanguage: "JA"}, want: 1},
+		{name: "genre without the language", details: mediaDetails{Genres: []string{"Animation"}, OriginalLanguage: "en"}, want: 0},
+		{name: "language without the genre", details: mediaDetails{Genres: []string{"Drama"}, OriginalLanguage: "ja"}, want: 0},
+		{name: "any of the genres", details: mediaDetails{Genres: []string{"History"}, OriginalLanguage: "en"}, want: 2},
+		{name: "first match wins", details: mediaDetails{Genres: []string{"Animation", "Documentary"}, OriginalLanguage: "ja"}, want: 1},
+		{name: "no match goes to the default", details: mediaDetails{Genres: []string{"Drama"}}, dflt: 2, want: 2},
+		{name: "chosen server", req: store.Request{Server: "DOCS"}, details: mediaDetails{Genres: []string{"Animation"}, OriginalLanguage: "ja"}, want: 2},
+		{name: "lookup fails", failing: true, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var list []*instance
+			for i, c := range configs {
+				c.Default = i == tt.dflt && tt.dflt != 0
+				inst := newInstance("Sonarr", c)
+				list = append(list, &inst)
+			}
+			markDefault(list)
+			details := func(ctx context.Context, tmdbID int) (mediaDetails, error) {
+				if tt.failing {
+					return mediaDetails{}, errors.New("TMDB is down")
+				}
+				return tt.details, nil
+			}
+			got, err := chooseInstance(t.Context(), "Sonarr", list, tt.req, details)
+			if err != nil || got != tt.want {
+				t.Errorf("got %d, %v, want %s", got, err, configs[tt.want].Name)
+			}
+		})
+	}
+}
+
+func TestChooseInstanceUnknownServer(t *testing.T) {
+	main, anime := newInstance("Radarr", InstanceConfig{Name: "main"}), newInstance("Radarr", InstanceConfig{Name: "anime"})
+	list := []*instance{&main, &anime}
+	markDefault(list)
+	_, err := chooseInstance(t.Context(), "Radarr", list, store.Request{Server: "4k"}, nil)
+	var unknown *unknownServerError
+	if !errors.As(err, &unknown) || err.Error() != `There is no Radarr server named "4k"` {
+		t.Errorf("got %v, want an unknown server error", err)
+	}
+}
+
+func TestChooseInstanceSkipsLookupWithoutCriteria(t *testing.T) {
+	main, fourK := newInstance("Radarr", InstanceConfig{Name: "main"}), newInstance("Radarr", InstanceConfig{Name: "4k", Default: true})
+	list := []*instance{&main, &fourK}
+	markDefault(list)
+	details := func(ctx context.Context, tmdbID int) (mediaDetails, error) {
+		t.Error("looked up the title without any server criteria")
+		return mediaDetails{}, nil
+	}
+	if got, err := chooseInstance(t.Context(), "Radarr", list, store.Request{TMDBID: 603}, details); got != 1 || err != nil {
+		t.Errorf("got %d, %v, want the default 4k server", got, err)
+	}
+}
+
+func TestSonarrFor(t *testing.T) {
+	newServer := func(t *testing.T) *testServer {
+		ts := newTestServer(t)
+		ts.tmdb.AddShow(tmdb.TVShowDetails{ID: 1396, Name: "Breaking Bad", OriginalLanguage: "ja",
+			Genres: []tmdb.Genre{{Name: "Animation"}}}, 81189)
+		return ts
+	}
+	show := store.Request{TMDBID: 1396, MediaType: "tv"}
+
+	t.Run("by criteria", func(t *testing.T) {
+		ts := newServer(t)
+		ts.addBreakingBad(false)
+		ts.addSonarr(InstanceConfig{Name: "anime", Genres: []string{"Animation"}, OriginalLanguage: "ja"})
+		if got, err := ts.sonarrFor(t.Context(), show); err != nil || got.name != "anime" {
+			t.Errorf("got %v, %v, want anime", got, err)
+		}
+	})
+
+	// A show already in the main Sonarr stays there, so seasons requested
+	// later are added to the same series.
+	t.Run("the Sonarr with the show", func(t *testing.T) {
+		ts := newServer(t)
+		ts.addBreakingBad(true)
+		ts.addSonarr(InstanceConfig{Name: "anime", Genres: []string{"Animation"}, OriginalLanguage: "ja"})
+		got, err := ts.sonarrFor(t.Context(), show)
+		if err != nil || got != ts.sonarrs[0] {
+			t.Errorf("got %v, %v, want the main Sonarr", got, err)
+		}
+	})
+
+	t.Run("the show in a later Sonarr", func(t *testing.T) {
+		ts := newServer(t)
+		kids := ts.addSonarr(InstanceConfig{Name: "kids"})
+		kids.Catalog(sonarr.Series{TmdbID: 1396, TvdbID: 81189, Title: "Breaking Bad"}, nil)
+		kids.Put(sonarr.Series{TmdbID: 1396, TvdbID: 81189, Title: "Breaking Bad"})
+		ts.addSonarr(InstanceConfig{Name: "anime", Genres: []string{"Animation"}, OriginalLanguage: "ja"})
+		if got, err := ts.sonarrFor(t.Context(), show); err != nil || got.name != "kids" {
+			t.Errorf("got %v, %v, want kids", got, err)
+		}
+	})
+
+	t.Run("chosen server", func(t *testing.T) {
+		ts := newServer(t)
+		ts.addBreakingBad(true)
+		ts.addSonarr(InstanceConfig{Name: "anime"})
+		req := show
+		req.Server = "anime"
+		if got, err := ts.sonarrFor(t.Context(), req); err != nil || got.name != "anime" {
+			t.Errorf("got %v, %v, want the chosen anime server", got, err)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+// Package testutil holds the test servers shared by the tests of the TMDB,
+// Radarr and Sonarr clients.
+package testutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// StallingServer starts a server that accepts calls and never answers them.
+// Each call is announced on the returned channel once it has arrived. The
+// server is closed when the test ends.
+func StallingServer(t *testing.T) (*httptest.Server, <-chan struct{}) {
+	t.Helper()
+	arrived := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body) // the server only notices a dropped client once the body is read
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, arrived
+}
+
+// Call is one method of a client C under test.
+type Call[C any] struct {
+	Name string
+	Do   func(ctx context.Context, c C) error
+}
+
+// CheckContextAborts makes every call against a StallingServer twice, once
+// canceling its context after the call arrived and once with a short
+// deadline, and checks that it returns the context's error right away.
+// newClient returns a client for the server at url.
+func CheckContextAborts[C any](t *testing.T, newClient func(t *testing.T, url string) C, calls []Call[C]) {
+	t.Helper()
+	for _, call := range calls {
+		t.Run(call.Name+"/canceled", func(t *testing.T) {
+			srv, arrived := StallingServer(t)
+			c := newClient(t, srv.URL)
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				<-arrived
+				cancel()
+			}()
+			start := time.Now()
+			if err := call.Do(ctx, c); !errors.Is(err, context.Canceled) {
+				t.Errorf("got %v, want the cancellation", err)
+			}
+			if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+				t.Errorf("returned after %s, want right after the cancellation", elapsed)
+			}
+		})
+		t.Run(call.Name+"/deadline", func(t *testing.T) {
+			srv, _ := StallingServer(t)
+			c := newClient(t, srv.URL)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if err := call.Do(ctx, c); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got %v, want the deadline error", err)
+			}
+		})
+	}
+}
+
+// Response is what a Recorder answers a route with.
+type Response struct {
+	Status int // 200 when zero
+	Body   string
+}
+
+// Request is a call a Recorder received.
+type Request struct {
+	Method string
+	URI    string // path and query, e.g. "/api/v3/movie?tmdbId=603"
+	Header http.Header
+	Body   string
+}
+
+// Recorder is a server that answers calls from a fixed set of routes and
+// records them.
+type Recorder struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+// NewRecorder starts a Recorder answering each route, a pattern such as
+// "GET /api/v3/movie", with its response. Unknown routes get a 404. The
+// server is closed when the test ends.
+func NewRecorder(t *testing.T, routes map[string]Response) *Recorder {
+	t.Helper()
+	rec := &Recorder{}
+	mux := http.NewServeMux()
+	for pattern, resp := range routes {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			status := resp.Status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			w.WriteHeader(status)
+			io.WriteString(w, resp.Body)
+		})
+	}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, Request{r.Method, r.URL.RequestURI(), r.Header.Clone(), string(body)})
+		rec.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+// Requests returns the calls received so far.
+func (rec *Recorder) Requests() []Request {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]Request(nil), rec.requests...)
+}
@@ -1,25 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 
-	"github.com/bpouw/gopherseerr/radarr"
-	"github.com/bpouw/gopherseerr/sonarr"
-	"github.com/bpouw/gopherseerr/tmdb"
-)
-
-var (
-	config       Config
-	templates    *template.Template
-	tmdbClient   *tmdb.Client
-	radarrClient *radarr.Client
-	sonarrClient *sonarr.Client
+	"github.com/bpouw/gopherseerr/notify"
+	"github.com/bpouw/gopherseerr/store"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type Config struct {
@@ -31,63 +27,176 @@ type Config struct {
 	SonarrApiKey     string `json:"sonarr_api_key"`
 	RadarrRootFolder string `json:"radarr_root_folder"`
 	SonarrRootFolder string `json:"sonarr_root_folder"`
+	RequestsFile     string `json:"requests_file"`
+	Users            []User `json:"users"`
+	RequireApproval  bool   `json:"require_approval"`
+
+	RadarrQualityProfile  ProfileRef `json:"radarr_quality_profile"`
+	SonarrQualityProfile  ProfileRef `json:"sonarr_quality_profile"`
+	SonarrLanguageProfile ProfileRef `json:"sonarr_language_profile"`
+	ProfilePicker         bool       `json:"profile_picker"`
+
+	RadarrRootFolderRules []RootFolderRule `json:"radarr_root_folder_rules"`
+	SonarrRootFolderRules []RootFolderRule `json:"sonarr_root_folder_rules"`
+
+	// Several named Radarrs/Sonarrs, replacing the radarr_*/sonarr_* settings
+	// of a single one above.
+	RadarrServers []InstanceConfig `json:"radarr_servers"`
+	SonarrServers []InstanceConfig `json:"sonarr_servers"`
+
+	Notifications []notify.Target `json:"notifications"`
+	WebhookToken  string          `json:"webhook_token"`
+
+	HTTP       HTTPConfig `json:"http"`
+	TMDBHTTP   HTTPConfig `json:"tmdb_http"`
+	RadarrHTTP HTTPConfig `json:"radarr_http"`
+	SonarrHTTP HTTPConfig `json:"sonarr_http"`
+
+	TMDBCache         TMDBCacheConfig `json:"tmdb_cache"`
+	SonarrIndexMaxAge string          `json:"sonarr_index_max_age"` // e.g. "10m", "off" disables the series index
+
+	Server    ServerConfig `json:"server"`
+	AssetsDir string       `json:"assets_dir"` // templates/ and static/ files that replace the built-in ones
 }
 
 func main() {
-	f, err := os.Open("config.json")
+	hashPassword := flag.String("hash-password", "", "print a bcrypt hash of the given password for use in config.json and exit")
+	configPath := flag.String("config", os.Getenv("GOPHERSEERR_CONFIG"), "config file (.json, .yaml or .toml); by default config.json, config.yaml, config.yml or config.toml in the working directory or next to the executable")
+	flag.BoolVar(&devMode, "dev", false, "read templates and static files from disk on every request, for working on the UI")
+	flag.Parse()
+	if *hashPassword != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(*hashPassword), bcrypt.DefaultCost)
+		if err != nil {
+			log.Fatal("Error hashing password:", err)
+		}
+		fmt.Println(string(hash))
+		return
+	}
+
+	path, err := findConfig(*configPath)
+	if err != nil {
+		log.Fatal("Error loading config: ", err)
+	}
+	if path == "" {
+		log.Println("No config file found, using environment variables only")
+	}
+	c, err := loadConfig(path)
+	if err != nil {
+		log.Fatal("Invalid configuration:\n", err)
+	}
+	requests, err := store.Open(c.RequestsFile)
 	if err != nil {
-		log.Fatal("Error loading config:", err)
+		log.Fatal("Error opening request store:", err)
 	}
-	defer f.Close()
-	if err := json.NewDecoder(f).Decode(&config); err != nil {
-		log.Fatal("Error parsing config:", err)
+	s, err := buildServer(context.Background(), c, requests)
+	if err != nil {
+		log.Fatal(err)
+	}
+	current.Store(s)
+
+	if !s.authEnabled() {
+		log.Println("No users configured, the request UI is open to anyone who can reach it")
 	}
-	templates = template.Must(template.ParseGlob("templates/*.gohtml"))
-	tmdbClient = tmdb.NewClient(config.TMDBApiKey)
-	radarrClient = radarr.NewClient(config.RadarrURL, config.RadarrApiKey)
-	sonarrClient = sonarr.NewClient(config.SonarrURL, config.SonarrApiKey)
 
-	http.HandleFunc("/", handleSearch)
-	http.HandleFunc("/show", handleShowDetails)
-	http.HandleFunc("/episodes", handleGetEpisodes)
-	http.HandleFunc("/request", handleRequest)
-	log.Println("Starting server on port", config.Port)
-	log.Fatal(http.ListenAndServe(":"+config.Port, nil))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/static/", handle((*server).handleStatic))
+	mux.HandleFunc("/login", handle((*server).handleLogin))
+	mux.HandleFunc("/logout", handleLogout)
+	mux.HandleFunc("/", requireLogin(handle((*server).handleSearch)))
+	mux.HandleFunc("/show", requireLogin(handle((*server).handleShowDetails)))
+	mux.HandleFunc("/movie", requireLogin(handle((*server).handleMovieDetails)))
+	mux.HandleFunc("/episodes", requireLogin(handle((*server).handleGetEpisodes)))
+	mux.HandleFunc("/request", requireLogin(handle((*server).handleRequest)))
+	mux.HandleFunc("/requests", requireLogin(handle((*server).handleListRequests)))
+	mux.HandleFunc("/requests.json", requireLogin(handle((*server).handleListRequests)))
+	mux.HandleFunc("/admin/requests", requireAdmin(handle((*server).handleApprovalQueue)))
+	mux.HandleFunc("/admin/requests/approve", requireAdmin(handle((*server).handleApproveRequest)))
+	mux.HandleFunc("/admin/requests/deny", requireAdmin(handle((*server).handleDenyRequest)))
+	mux.HandleFunc("/admin/cache", requireAdmin(handle((*server).handleTMDBCacheStats)))
+	mux.HandleFunc("/admin/cache/flush", requireAdmin(handle((*server).handleTMDBCacheFlush)))
+	mux.HandleFunc("/webhook/radarr", handle((*server).handleRadarrWebhook))
+	mux.HandleFunc("/webhook/sonarr", handle((*server).handleSonarrWebhook))
+	registerAPIRoutes(mux)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go refreshSonarrIndex(ctx)
+	go watchConfig(ctx, path)
+	var handler http.Handler = mux
+	if devMode {
+		handler = withDevTemplates(handler)
+	}
+	if err := serve(ctx, withServer(handler)); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Stopped")
 }
 
-func handleSearch(w http.ResponseWriter, r *http.Request) {
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query().Get("q")
 	if q == "" {
-		templates.ExecuteTemplate(w, "search.gohtml", nil)
+		s.templates.ExecuteTemplate(w, "search.gohtml", struct{ User *User }{currentUser(r)})
 		return
 	}
-	results, err := tmdbClient.Search(q)
+	results, err := s.tmdb.Search(r.Context(), q)
 	if err != nil {
-		http.Error(w, "TMDB search error: "+err.Error(), http.StatusInternalServerError)
+		writeUpstreamError(w, err, http.StatusInternalServerError, "TMDB search error: ")
 		return
 	}
-	templates.ExecuteTemplate(w, "results.gohtml", results)
+	data := struct {
+		Results []searchResult
+		Options *requestOptions
+	}{Results: s.enrichSearchResults(r.Context(), results)}
+	for _, item := range results {
+		if item.MediaType == "movie" {
+			data.Options = s.radarrOptions(r)
+			break
+		}
+	}
+	s.templates.ExecuteTemplate(w, "results.gohtml", data)
 }
 
-func handleShowDetails(w http.ResponseWriter, r *http.Request) {
+func (s *server) handleShowDetails(w http.ResponseWriter, r *http.Request) {
 	tmdbIDStr := r.URL.Query().Get("tmdb_id")
 	tmdbID, err := strconv.Atoi(tmdbIDStr)
 	if err != nil {
 		http.Error(w, "Invalid tmdb_id", http.StatusBadRequest)
 		return
 	}
-	showDetails, err := tmdbClient.GetTVShowDetails(tmdbID)
+	showDetails, err := s.tmdb.GetTVShowDetails(r.Context(), tmdbID)
 	if err != nil {
-		http.Error(w, "Failed to get show details from TMDB: "+err.Error(), http.StatusInternalServerError)
+		writeUpstreamError(w, err, http.StatusInternalServerError, "Failed to get show details from TMDB: ")
 		return
 	}
-	err = templates.ExecuteTemplate(w, "show.gohtml", showDetails)
+	page := s.enrichShowDetails(r.Context(), showDetails)
+	page.Options = s.sonarrOptions(r)
+	err = s.templates.ExecuteTemplate(w, "show.gohtml", page)
 	if err != nil {
 		http.Error(w, "Failed to render template: "+err.Error(), http.StatusInternalServerError)
 	}
 }
 
-func handleGetEpisodes(w http.ResponseWriter, r *http.Request) {
+func (s *server) handleMovieDetails(w http.ResponseWriter, r *http.Request) {
+	tmdbIDStr := r.URL.Query().Get("tmdb_id")
+	tmdbID, err := strconv.Atoi(tmdbIDStr)
+	if err != nil {
+		http.Error(w, "Invalid tmdb_id", http.StatusBadRequest)
+		return
+	}
+	movieDetails, err := s.tmdb.GetMovieDetails(r.Context(), tmdbID)
+	if err != nil {
+		writeUpstreamError(w, err, http.StatusInternalServerError, "Failed to get movie details from TMDB: ")
+		return
+	}
+	page := s.enrichMovieDetails(r.Context(), movieDetails)
+	page.Options = s.radarrOptions(r)
+	err = s.templates.ExecuteTemplate(w, "movie.gohtml", page)
+	if err != nil {
+		http.Error(w, "Failed to render template: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *server) handleGetEpisodes(w http.ResponseWriter, r *http.Request) {
 	tmdbIDStr := r.URL.Query().Get("tmdb_id")
 	seasonNumberStr := r.URL.Query().Get("season")
 	tmdbID, err1 := strconv.Atoi(tmdbIDStr)
@@ -97,9 +206,9 @@ func handleGetEpisodes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	seasonDetails, err := tmdbClient.GetSeasonDetails(tmdbID, seasonNumber)
+	seasonDetails, err := s.tmdb.GetSeasonDetails(r.Context(), tmdbID, seasonNumber)
 	if err != nil {
-		http.Error(w, "Failed to get season details: "+err.Error(), http.StatusInternalServerError)
+		writeUpstreamError(w, err, http.StatusInternalServerError, "Failed to get season details: ")
 		return
 	}
 
@@ -107,196 +216,70 @@ func handleGetEpisodes(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(seasonDetails.Episodes)
 }
 
-func handleRequest(w http.ResponseWriter, r *http.Request) {
+func (s *server) handleRequest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 	r.ParseForm()
 
-	mediaType := r.FormValue("type")
-	tmdbIDStr := r.FormValue("tmdb_id")
-	tmdbID, err := strconv.Atoi(tmdbIDStr)
+	req, err := parseRequestForm(r)
 	if err != nil {
-		http.Error(w, "Invalid tmdb_id", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var errAdd error
-	var successMessage string
-
-	switch mediaType {
-	case "movie":
-		rootFolder := config.RadarrRootFolder
-		errAdd = radarrClient.AddMovieByTMDB(tmdbID, 7, rootFolder)
-		successMessage = "Movie request successfully submitted!"
-
-	case "tv":
-		rootFolder := config.SonarrRootFolder
-		requestType := r.FormValue("request_type")
-
-		opts := sonarr.AddSeriesOptions{
-			TMDBID:           tmdbID,
-			QualityProfileID: 7,
-			RootFolder:       rootFolder,
-			SeasonsToMonitor: make(map[int]bool),
-		}
-
-		switch requestType {
-		case "full_show":
-			opts.AddEntireShow = true
-			_, errAdd = sonarrClient.AddSeries(opts)
-			successMessage = "Request to add the full show has been submitted!"
-			if errAdd != nil && errAdd.Error() == "series already exists" {
-				// Handle existing series: ensure all seasons are monitored
-				log.Println("Series exists, ensuring all seasons are monitored...")
-				series, findErr := sonarrClient.GetSeriesByTMDB(tmdbID)
-				if findErr != nil {
-					http.Error(w, "Series exists, but failed to look it up: "+findErr.Error(), http.StatusInternalServerError)
-					return
-				}
-				for i := range series.Seasons {
-					if series.Seasons[i].SeasonNumber > 0 {
-						series.Seasons[i].Monitored = true
-					}
-				}
-				errAdd = sonarrClient.UpdateSeries(series)
-			}
-
-		case "season":
-			seasonNumberStr := r.FormValue("season_number")
-			seasonNumber, err := strconv.Atoi(seasonNumberStr)
-			if err != nil {
-				http.Error(w, "Invalid season_number", http.StatusBadRequest)
-				return
-			}
-			opts.SeasonsToMonitor[seasonNumber] = true
-			_, errAdd = sonarrClient.AddSeries(opts)
-			successMessage = fmt.Sprintf("Request to add Season %d has been submitted!", seasonNumber)
-			if errAdd != nil && errAdd.Error() == "series already exists" {
-				log.Printf("Series exists, ensuring season %d is monitored...", seasonNumber)
-				series, findErr := sonarrClient.GetSeriesByTMDB(tmdbID)
-				if findErr != nil {
-					http.Error(w, "Series exists, but failed to look it up: "+findErr.Error(), http.StatusInternalServerError)
-					return
-				}
-				var seasonUpdated = false
-				for i := range series.Seasons {
-					if series.Seasons[i].SeasonNumber == seasonNumber {
-						series.Seasons[i].Monitored = true
-						seasonUpdated = true
-						break
-					}
-				}
-				if seasonUpdated {
-					errAdd = sonarrClient.UpdateSeries(series)
-				} else {
-					errAdd = fmt.Errorf("could not find season %d in existing series to update", seasonNumber)
-				}
-			}
-
-		case "episode":
-			seasonNumberStr := r.FormValue("season_number")
-			episodeNumberStr := r.FormValue("episode_number")
-			seasonNumber, err1 := strconv.Atoi(seasonNumberStr)
-			episodeNumber, err2 := strconv.Atoi(episodeNumberStr)
-			if err1 != nil || err2 != nil {
-				http.Error(w, "Invalid season or episode number", http.StatusBadRequest)
-				return
-			}
-
-			// Try to find the series first.
-			series, err := sonarrClient.GetSeriesByTMDB(tmdbID)
-			if err != nil {
-				// If not found, add it for the first time.
-				log.Println("Series not found in Sonarr, adding it...")
-				opts.SeasonsToMonitor[seasonNumber] = true // Monitor the season
-				id, addErr := sonarrClient.AddSeries(opts)
-				if addErr != nil {
-					http.Error(w, "Failed to add new series for episode request: "+addErr.Error(), http.StatusInternalServerError)
-					return
-				}
-				// After adding, we need to fetch it again to get the full series object
-				series, err = sonarrClient.GetSeriesByTMDB(tmdbID)
-				if err != nil {
-					http.Error(w, "Added series but could not immediately re-fetch it: "+err.Error(), http.StatusInternalServerError)
-					return
-				}
-				series.ID = id
-			} else {
-				// Series exists, ensure the season is monitored.
-				log.Println("Series found in Sonarr, ensuring season is monitored...")
-				var needsUpdate = false
-				for i := range series.Seasons {
-					if series.Seasons[i].SeasonNumber == seasonNumber && !series.Seasons[i].Monitored {
-						series.Seasons[i].Monitored = true
-						needsUpdate = true
-						break
-					}
-				}
-				if needsUpdate {
-					log.Println("Updating series to monitor new season...")
-					if updateErr := sonarrClient.UpdateSeries(series); updateErr != nil {
-						http.Error(w, "Failed to update series monitoring status: "+updateErr.Error(), http.StatusInternalServerError)
-						return
-					}
-				}
-			}
-
-			// Now that the series exists and the season is monitored, search for the episode.
-			allEpisodes, epErr := sonarrClient.GetEpisodes(series.ID)
-			if epErr != nil {
-				http.Error(w, "Failed to get episodes from Sonarr: "+epErr.Error(), http.StatusInternalServerError)
-				return
-			}
-			var targetEpisodeID = -1
-			for _, ep := range allEpisodes {
-				if ep.SeasonNumber == seasonNumber && ep.EpisodeNumber == episodeNumber {
-					targetEpisodeID = ep.ID
-					break
-				}
-			}
-
-			if targetEpisodeID == -1 {
-				http.Error(w, "Could not find the specified episode in Sonarr.", http.StatusInternalServerError)
-				return
-			}
-
-			errAdd = sonarrClient.SearchEpisodes([]int{targetEpisodeID})
-			successMessage = fmt.Sprintf("Search for S%02dE%02d has been triggered!", seasonNumber, episodeNumber)
+	redirectURL := r.Header.Get("Referer")
+	if redirectURL == "" {
+		redirectURL = "/"
+	}
 
-		default:
-			http.Error(w, "Unsupported TV request type", http.StatusBadRequest)
-			return
-		}
-	default:
-		http.Error(w, "Unsupported media type", http.StatusBadRequest)
+	_, successMessage, errAdd := s.submitRequest(r, req)
+	if errAdd != nil {
+		status, message := requestError(errAdd, http.StatusInternalServerError)
+		http.Error(w, message, status)
 		return
 	}
+	showPopupAndRedirect(w, successMessage, redirectURL)
+}
 
-	if errAdd != nil {
-		http.Error(w, "Failed to process request: "+errAdd.Error(), http.StatusInternalServerError)
+func (s *server) handleListRequests(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseRequestFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	redirectURL := r.Header.Get("Referer")
-	if redirectURL == "" {
-		redirectURL = "/"
+	requests := s.requests.List(filter)
+	if r.URL.Path == "/requests.json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(requests)
+		return
+	}
+	u := currentUser(r)
+	data := struct {
+		Filter        store.Filter
+		Requests      []store.Request
+		ShowApprovals bool
+	}{filter, requests, s.config.RequireApproval && u != nil && u.Admin}
+	if err := s.templates.ExecuteTemplate(w, "requests.gohtml", data); err != nil {
+		http.Error(w, "Failed to render template: "+err.Error(), http.StatusInternalServerError)
 	}
-	showPopupAndRedirect(w, successMessage, redirectURL)
 }
 
+// popupTemplate shows a message and moves on to another page. The message
+// may quote Radarr or Sonarr, so it is escaped for the script it goes into.
+var popupTemplate = template.Must(template.New("popup").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="UTF-8"><title>Notification</title></head>
+<body>
+<script>
+	alert({{.Message}});
+	window.location.href = {{.RedirectURL}};
+</script>
+</body>
+</html>`))
+
 func showPopupAndRedirect(w http.ResponseWriter, message, redirectURL string) {
 	w.Header().Set("Content-Type", "text/html")
-	html := fmt.Sprintf(`
-		<!DOCTYPE html>
-		<html lang="en">
-		<head><meta charset="UTF-8"><title>Notification</title></head>
-		<body>
-		<script>
-			alert(%q);
-			window.location.href = %q;
-		</script>
-		</body>
-		</html>`, message, redirectURL)
-	w.Write([]byte(html))
+	popupTemplate.Execute(w, struct{ Message, RedirectURL string }{message, redirectURL})
 }
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bpouw/gopherseerr/fake"
+	"github.com/bpouw/gopherseerr/radarr"
+	"github.com/bpouw/gopherseerr/sonarr"
+	"github.com/bpouw/gopherseerr/store"
+)
+
+// testServer is a server on the fakes, with one Radarr and one Sonarr set up
+// the way buildServer sets up live ones.
+type testServer struct {
+	*server
+	tmdb   *fake.TMDB
+	radarr *fake.Radarr
+	sonarr *fake.Sonarr
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	templates, err := parseTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests, err := store.Open(filepath.Join(t.TempDir(), "requests.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &testServer{tmdb: fake.NewTMDB(), radarr: fake.NewRadarr(), sonarr: fake.NewSonarr()}
+	ts.server = &server{
+		templates: templates,
+		tmdb:      ts.tmdb,
+		radarrs:   []*radarrInstance{{MovieManager: ts.radarr, instance: newInstance("Radarr", InstanceConfig{})}},
+		sonarrs:   []*sonarrInstance{{SeriesManager: ts.sonarr, instance: newInstance("Sonarr", InstanceConfig{})}},
+		requests:  requests,
+	}
+	markDefault(ts.radarrBases())
+	markDefault(ts.sonarrBases())
+	setupProfiles(ts.server)
+	setupRootFolders(ts.server)
+	return ts
+}
+
+// addSonarr adds another Sonarr configured with c after the first one, and
+// returns its fake.
+func (ts *testServer) addSonarr(c InstanceConfig) *fake.Sonarr {
+	f := fake.NewSonarr()
+	ts.sonarrs = append(ts.sonarrs, &sonarrInstance{SeriesManager: f, instance: newInstance("Sonarr", c)})
+	setupProfiles(ts.server)
+	setupRootFolders(ts.server)
+	return f
+}
+
+// do runs the handler h for r on ts, like handle does on the current server.
+func (ts *testServer) do(h func(*server, http.ResponseWriter, *http.Request), r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h(ts.server, w, r.WithContext(context.WithValue(r.Context(), serverContextKey, ts.server)))
+	return w
+}
+
+// addBreakingBad makes a show with specials and two seasons of two episodes
+// known to Sonarr. When added it is in the library with season 1 monitored
+// and S01E01 downloaded.
+func (ts *testServer) addBreakingBad(added bool) {
+	series := sonarr.Series{TmdbID: 1396, TvdbID: 81189, Title: "Breaking Bad", Seasons: []sonarr.SonarrSeason{
+		{SeasonNumber: 0}, {SeasonNumber: 1}, {SeasonNumber: 2},
+	}}
+	episodes := []sonarr.Episode{
+		{ID: 11, SeasonNumber: 1, EpisodeNumber: 1},
+		{ID: 12, SeasonNumber: 1, EpisodeNumber: 2},
+		{ID: 21, SeasonNumber: 2, EpisodeNumber: 1},
+		{ID: 22, SeasonNumber: 2, EpisodeNumber: 2},
+	}
+	if added {
+		series.Seasons[1].Monitored = true
+		episodes[0].Monitored, episodes[0].HasFile = true, true
+		episodes[1].Monitored = true
+	}
+	ts.sonarr.Catalog(series, episodes)
+	if added {
+		ts.sonarr.Put(series)
+	}
+}
+
+// monitored returns the monitored seasons and episode IDs of the only series
+// in the Sonarr library.
+func (ts *testServer) monitored(t *testing.T) (seasons, episodes []int) {
+	t.Helper()
+	library := ts.sonarr.Series()
+	if len(library) != 1 {
+		t.Fatalf("library has %d series, want 1", len(library))
+	}
+	for _, s := range library[0].Seasons {
+		if s.Monitored {
+			seasons = append(seasons, s.SeasonNumber)
+		}
+	}
+	eps, _ := ts.sonarr.GetEpisodes(context.Background(), library[0].ID)
+	for _, e := range eps {
+		if e.Monitored {
+			episodes = append(episodes, e.ID)
+		}
+	}
+	return seasons, episodes
+}
+
+func postForm(target string, form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestHandleRequestTV(t *testing.T) {
+	tests := []struct {
+		name         string
+		form         url.Values
+		added        bool
+		wantSeasons  []int
+		wantEpisodes []int
+		wantSearched []int
+		wantMessage  string
+	}{
+		{
+			name:         "full show, new series",
+			form:         url.Values{"request_type": {"full_show"}},
+			wantSeasons:  []int{1, 2},
+			wantEpisodes: []int{11, 12, 21, 22},
+			wantMessage:  "Request to add the full show has been submitted!",
+		},
+		{
+			name:         "full show, existing series",
+			form:         url.Values{"request_type": {"full_show"}},
+			added:        true,
+			wantSeasons:  []int{1, 2},
+			wantEpisodes: []int{11, 12, 21, 22},
+			wantSearched: []int{21, 22},
+			wantMessage:  "Request to add the full show has been submitted!",
+		},
+		{
+			name:         "season, new series",
+			form:         url.Values{"request_type": {"season"}, "season_number": {"2"}},
+			wantSeasons:  []int{2},
+			wantEpisodes: []int{21, 22},
+			wantMessage:  "Request to add Season 2 has been submitted!",
+		},
+		{
+			name:         "season, existing series",
+			form:         url.Values{"request_type": {"season"}, "season_number": {"2"}},
+			added:        true,
+			wantSeasons:  []int{1, 2},
+			wantEpisodes: []int{11, 12, 21, 22},
+			wantSearched: []int{21, 22},
+			wantMessage:  "Request to add Season 2 has been submitted!",
+		},
+		{
+			name:         "episode, new series",
+			form:         url.Values{"request_type": {"episode"}, "season_number": {"2"}, "episode_number": {"1"}},
+			wantEpisodes: []int{21},
+			wantSearched: []int{21},
+			wantMessage:  "Search for S02E01 has been triggered!",
+		},
+		{
+			name:         "episode, existing series",
+			form:         url.Values{"request_type": {"episode"}, "season_number": {"2"}, "episode_number": {"2"}},
+			added:        true,
+			wantSeasons:  []int{1},
+			wantEpisodes: []int{11, 12, 22},
+			wantSearched: []int{22},
+			wantMessage:  "Search for S02E02 has been triggered!",
+		},
+		{
+			name:         "batch, existing series",
+			form:         url.Values{"request_type": {"batch"}, "season": {"2"}, "episode": {"S01E02"}},
+			added:        true,
+			wantSeasons:  []int{1, 2},
+			wantEpisodes: []int{11, 12, 21, 22},
+			wantSearched: []int{21, 22, 12},
+			wantMessage:  "Request for Season 2, S01E02 has been submitted!",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.addBreakingBad(tt.added)
+			tt.form.Set("type", "tv")
+			tt.form.Set("tmdb_id", "1396")
+
+			w := ts.do((*server).handleRequest, postForm("/request", tt.form))
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), tt.wantMessage) {
+				t.Fatalf("got %d %s, want the message %q", w.Code, w.Body, tt.wantMessage)
+			}
+			seasons, episodes := ts.monitored(t)
+			if !slices.Equal(seasons, tt.wantSeasons) || !slices.Equal(episodes, tt.wantEpisodes) {
+				t.Errorf("monitored seasons %v and episodes %v, want %v and %v", seasons, episodes, tt.wantSeasons, tt.wantEpisodes)
+			}
+			if searched := ts.sonarr.Searched(); !slices.Equal(searched, tt.wantSearched) {
+				t.Errorf("searched for %v, want %v", searched, tt.wantSearched)
+			}
+			recorded := ts.requests.List(store.Filter{})
+			if len(recorded) != 1 || recorded[0].Status != store.StatusSubmitted {
+				t.Errorf("ledger = %+v, want one submitted request", recorded)
+			}
+		})
+	}
+}
+
+func TestHandleRequestFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		form       url.Values
+		setup      func(ts *testServer)
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "unknown season",
+			form:       url.Values{"type": {"tv"}, "tmdb_id": {"1396"}, "request_type": {"season"}, "season_number": {"7"}},
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "Breaking Bad has no season 7 in Sonarr",
+		},
+		{
+			name: "Sonarr rejects the API key",
+			form: url.Values{"type": {"tv"}, "tmdb_id": {"1396"}, "request_type": {"full_show"}},
+			setup: func(ts *testServer) {
+				ts.sonarr.FailWith("AddSeries", sonarr.ErrUnauthorized)
+			},
+			wantStatus: http.StatusBadGateway,
+			wantBody:   "Sonarr rejected the API key",
+		},
+		{
+			name: "movie already in Radarr",
+			form: url.Values{"type": {"movie"}, "tmdb_id": {"603"}},
+			setup: func(ts *testServer) {
+				ts.radarr.Put(radarr.Movie{TmdbID: 603, Title: "The Matrix"})
+			},
+			wantStatus: http.StatusConflict,
+			wantBody:   "This movie is already in Radarr.",
+		},
+		{
+			name:       "unknown server",
+			form:       url.Values{"type": {"movie"}, "tmdb_id": {"603"}, "server": {"4k"}},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `There is no Radarr server named "4k"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.addBreakingBad(false)
+			if tt.setup != nil {
+				tt.setup(ts)
+			}
+			w := ts.do((*server).handleRequest, postForm("/request", tt.form))
+			if w.Code != tt.wantStatus || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("got %d %q, want %d %q", w.Code, w.Body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestHandleRequestMovie(t *testing.T) {
+	ts := newTestServer(t)
+	w := ts.do((*server).handleRequest, postForm("/request", url.Values{
+		"type": {"movie"}, "tmdb_id": {"603"}, "minimum_availability": {"released"},
+	}))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Movie request successfully submitted!") {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	movies := ts.radarr.Movies()
+	if len(movies) != 1 || movies[0].TmdbID != 603 || movies[0].Quality != 1 || movies[0].RootFolder != "/movies" || movies[0].MinimumAvailability != "released" {
+		t.Errorf("library = %+v, want The Matrix with the default profile and folder", movies)
+	}
+}
+
+func TestShowPopupAndRedirectEscapes(t *testing.T) {
+	w := httptest.NewRecorder()
+	showPopupAndRedirect(w, `Radarr said: </script><script>alert("owned")</script>`, `/show?tmdb_id=1"; alert(1); "`)
+	body := w.Body.String()
+	if strings.Count(body, "<script>") != 1 || strings.Count(body, "</script>") != 1 {
+		t.Errorf("the message broke out of the script:\n%s", body)
+	}
+	if strings.Contains(body, `alert(1); "`) {
+		t.Errorf("the redirect URL broke out of its string:\n%s", body)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/bpouw/gopherseerr/notify"
+	"github.com/bpouw/gopherseerr/store"
+	"github.com/bpouw/gopherseerr/tmdb"
+)
+
+// pendingNotifications tracks notifications still being sent, so shutting
+// down does not drop them.
+var pendingNotifications sync.WaitGroup
+
+// userNotifiers builds the dispatchers for the users with notification
+// targets of their own.
+func userNotifiers(users []User) (map[string]*notify.Dispatcher, error) {
+	out := make(map[string]*notify.Dispatcher)
+	for i, u := range users {
+		if len(u.Notifications) == 0 {
+			continue
+		}
+		d, err := notify.NewDispatcher(u.Notifications)
+		if err != nil {
+			return nil, fmt.Errorf("users[%d]: %w", i, err)
+		}
+		out[strings.ToLower(u.Username)] = d
+	}
+	return out, nil
+}
+
+// notifyRequest tells the configured notification targets, and those of the
+// user who made req, about a change to req. It returns immediately; the
+// title lookup and delivery run in the background.
+func (s *server) notifyRequest(typ notify.EventType, req store.Request) {
+	var targets []*notify.Dispatcher
+	if s.notifier != nil && s.notifier.Wants(typ) {
+		targets = append(targets, s.notifier)
+	}
+	if d := s.userNotifiers[strings.ToLower(req.User)]; d != nil && req.User != "" && d.Wants(typ) {
+		targets = append(targets, d)
+	}
+	if len(targets) == 0 {
+		return
+	}
+	pendingNotifications.Add(1)
+	go func() {
+		defer pendingNotifications.Done()
+		title := mediaTitle(context.Background(), s.tmdb, req)
+		e := notify.Event{Type: typ, Request: req}
+		switch typ {
+		case notify.EventCreated:
+			e.Subject = "New request: " + title
+			e.Message = fmt.Sprintf("%s requested %s.", requester(req), title)
+			if req.Status == store.StatusPending {
+				e.Message += " It is waiting for approval."
+			}
+		case notify.EventApproved:
+			e.Subject = "Request approved: " + title
+			e.Message = fmt.Sprintf("%s approved the request from %s for %s.", req.ReviewedBy, requester(req), title)
+		case notify.EventDenied:
+			e.Subject = "Request denied: " + title
+			e.Message = fmt.Sprintf("%s denied the request from %s for %s.", req.ReviewedBy, requester(req), title)
+			if req.DenyReason != "" {
+				e.Message += "\nReason: " + req.DenyReason
+			}
+		case notify.EventFailed:
+			e.Subject = "Request failed: " + title
+			e.Message = fmt.Sprintf("The request from %s for %s could not be sent to %s.\nError: %s", requester(req), title, serviceName(req), req.Error)
+		case notify.EventAvailable:
+			e.Subject = "Now available: " + title
+			e.Message = fmt.Sprintf("%s, requested by %s, is now available.", title, requester(req))
+		}
+		for _, d := range targets {
+			d.Send(e)
+		}
+	}()
+}
+
+// waitForNotifications waits until every notification has been sent or ctx
+// is done.
+func waitForNotifications(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		pendingNotifications.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// mediaTitle describes what a request is for, e.g. "Breaking Bad (2008),
+// season 2". It falls back to the TMDB ID when TMDB cannot be reached.
+func mediaTitle(ctx context.Context, client MetadataProvider, req store.Request) string {
+	var title string
+	var err error
+	if req.MediaType == "movie" {
+		var movie *tmdb.MovieDetails
+		if movie, err = client.GetMovieDetails(ctx, req.TMDBID); err == nil {
+			title = withYear(movie.Title, movie.ReleaseDate)
+		}
+	} else {
+		var show *tmdb.TVShowDetails
+		if show, err = client.GetTVShowDetails(ctx, req.TMDBID); err == nil {
+			title = withYear(show.Name, show.FirstAirDate)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to look up the title of request #%d for a notification: %v", req.ID, err)
+		title = fmt.Sprintf("TMDB %d", req.TMDBID)
+	}
+
+	switch req.RequestType {
+	case "season":
+		title += fmt.Sprintf(", season %d", req.SeasonNumber)
+	case "episode":
+		title += fmt.Sprintf(", S%02dE%02d", req.SeasonNumber, req.EpisodeNumber)
+	case "batch":
+		title += ", " + req.Selection()
+	}
+	return title
+}
+
+func withYear(title, date string) string {
+	if len(date) >= 4 {
+		return fmt.Sprintf("%s (%s)", title, date[:4])
+	}
+	return title
+}
+
+func requester(req store.Request) string {
+	if req.User == "" {
+		return "Anonymous"
+	}
+	return req.User
+}
+
+func serviceName(req store.Request) string {
+	if req.MediaType == "movie" {
+		return "Radarr"
+	}
+	return "Sonarr"
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/bpouw/gopherseerr/notify"
+	"github.com/bpouw/gopherseerr/store"
+	"github.com/bpouw/gopherseerr/tmdb"
+)
+
+// inbox is a webhook receiver that keeps the events it gets.
+type inbox struct {
+	mu     sync.Mutex
+	events []notify.Event
+}
+
+func newInbox(t *testing.T) (*inbox, notify.Target) {
+	t.Helper()
+	in := &inbox{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e notify.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		in.mu.Lock()
+		in.events = append(in.events, e)
+		in.mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	return in, notify.Target{Type: "webhook", URL: srv.URL}
+}
+
+func (in *inbox) subjects() []string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	var out []string
+	for _, e := range in.events {
+		out = append(out, e.Subject)
+	}
+	return out
+}
+
+func TestNotifyRequestTellsTheRequester(t *testing.T) {
+	admins, adminTarget := newInbox(t)
+	alice, aliceTarget := newInbox(t)
+	aliceTarget.Events = []notify.EventType{notify.EventDenied, notify.EventAvailable}
+
+	ts := newTestServer(t)
+	ts.tmdb.AddMovie(tmdb.MovieDetails{ID: 603, Title: "The Matrix", ReleaseDate: "1999-03-30"})
+	var err error
+	if ts.notifier, err = notify.NewDispatcher([]notify.Target{adminTarget}); err != nil {
+		t.Fatal(err)
+	}
+	if ts.userNotifiers, err = userNotifiers([]User{
+		{Username: "alice", Notifications: []notify.Target{aliceTarget}},
+		{Username: "bob"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	movie := store.Request{ID: 1, TMDBID: 603, MediaType: "movie", User: "Alice"}
+	ts.notifyRequest(notify.EventCreated, movie)
+	ts.notifyRequest(notify.EventDenied, movie)
+	movie.User = "bob"
+	ts.notifyRequest(notify.EventAvailable, movie)
+	movie.User = ""
+	ts.notifyRequest(notify.EventAvailable, movie)
+	if err := waitForNotifications(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := alice.subjects(); len(got) != 1 || got[0] != "Request denied: The Matrix (1999)" {
+		t.Errorf("alice got %q, want only the denial of the request they made", got)
+	}
+	if got := admins.subjects(); len(got) != 4 {
+		t.Errorf("the global target got %q, want every event", got)
+	}
+}
+
+func TestUserNotifiersRejectInvalidTargets(t *testing.T) {
+	_, err := userNotifiers([]User{{Username: "alice", Notifications: []notify.Target{{Type: "discord"}}}})
+	if err == nil {
+		t.Error("got no error for a target without url")
+	}
+}
@@ -0,0 +1,41 @@
+package notify
+
+import (
+	"context"
+	"strings"
+)
+
+// Apprise sends events through an Apprise API server, which forwards them to
+// any of the services Apprise supports.
+type Apprise struct {
+	URL  string   // e.g. http://apprise:8000/notify or http://apprise:8000/notify/{key}
+	URLs []string // notification URLs for stateless calls
+	Tag  string
+}
+
+type apprisePayload struct {
+	URLs  string `json:"urls,omitempty"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Type  string `json:"type"`
+	Tag   string `json:"tag,omitempty"`
+}
+
+// appriseTypes maps events to Apprise notification types.
+var appriseTypes = map[EventType]string{
+	EventCreated:   "info",
+	EventApproved:  "success",
+	EventDenied:    "warning",
+	EventFailed:    "failure",
+	EventAvailable: "success",
+}
+
+func (a *Apprise) Notify(ctx context.Context, e Event) error {
+	return postJSON(ctx, a.URL, nil, apprisePayload{
+		URLs:  strings.Join(a.URLs, ","),
+		Title: e.Subject,
+		Body:  e.Message,
+		Type:  appriseTypes[e.Type],
+		Tag:   a.Tag,
+	})
+}
@@ -0,0 +1,180 @@
+// Package notify sends request lifecycle events to webhooks, chat services,
+// email and Apprise.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bpouw/gopherseerr/store"
+)
+
+type EventType string
+
+// Request lifecycle events.
+const (
+	EventCreated   EventType = "request_created"
+	EventApproved  EventType = "request_approved"
+	EventDenied    EventType = "request_denied"
+	EventFailed    EventType = "request_failed"
+	EventAvailable EventType = "request_available"
+)
+
+var eventTypes = []EventType{EventCreated, EventApproved, EventDenied, EventFailed, EventAvailable}
+
+// Event is a single notification. Subject and Message are ready to show to a
+// person; Request carries the ledger entry for machines.
+type Event struct {
+	Type    EventType     `json:"event"`
+	Subject string        `json:"subject"`
+	Message string        `json:"message"`
+	Request store.Request `json:"request"`
+	Time    time.Time     `json:"time"`
+}
+
+// Notifier delivers events to one target.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// Target configures one notification target in config.json. Which fields are
+// used depends on Type.
+type Target struct {
+	Type   string      `json:"type"`   // "webhook", "discord", "slack", "email" or "apprise"
+	Name   string      `json:"name"`   // shown in logs, defaults to Type
+	Events []EventType `json:"events"` // empty means every event
+
+	// webhook, discord, slack and apprise
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"` // webhook only
+
+	// apprise: notification URLs for a stateless Apprise API call. Leave
+	// empty when URL points at a stored configuration (/notify/{key}).
+	AppriseURLs []string `json:"apprise_urls"`
+	Tag         string   `json:"tag"`
+
+	// email
+	SMTPHost string   `json:"smtp_host"`
+	SMTPPort int      `json:"smtp_port"` // defaults to 587
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// New returns the notifier for a target.
+func New(t Target) (Notifier, error) {
+	switch t.Type {
+	case "webhook", "discord", "slack", "apprise":
+		if t.URL == "" {
+			return nil, fmt.Errorf("%s notification target is missing url", t.Type)
+		}
+	}
+	switch t.Type {
+	case "webhook":
+		return &Webhook{URL: t.URL, Headers: t.Headers}, nil
+	case "discord":
+		return &Discord{URL: t.URL}, nil
+	case "slack":
+		return &Slack{URL: t.URL}, nil
+	case "apprise":
+		return &Apprise{URL: t.URL, URLs: t.AppriseURLs, Tag: t.Tag}, nil
+	case "email":
+		if t.SMTPHost == "" || t.From == "" || len(t.To) == 0 {
+			return nil, errors.New("email notification target needs smtp_host, from and to")
+		}
+		port := t.SMTPPort
+		if port == 0 {
+			port = 587
+		}
+		return &Email{Host: t.SMTPHost, Port: port, Username: t.Username, Password: t.Password, From: t.From, To: t.To}, nil
+	case "":
+		return nil, errors.New("notification target is missing type")
+	default:
+		return nil, fmt.Errorf("unknown notification target type %q", t.Type)
+	}
+}
+
+type target struct {
+	name     string
+	events   []EventType
+	notifier Notifier
+}
+
+func (t target) wants(typ EventType) bool {
+	return len(t.events) == 0 || slices.Contains(t.events, typ)
+}
+
+// Dispatcher fans events out to every target that subscribed to them.
+type Dispatcher struct {
+	targets []target
+	timeout time.Duration
+}
+
+// NewDispatcher builds the notifiers for targets. All configuration errors are
+// reported at once.
+func NewDispatcher(targets []Target) (*Dispatcher, error) {
+	d := &Dispatcher{timeout: 30 * time.Second}
+	var errs []error
+	for i, t := range targets {
+		name := t.Name
+		if name == "" {
+			name = fmt.Sprintf("%s #%d", t.Type, i+1)
+		}
+		for _, typ := range t.Events {
+			if !slices.Contains(eventTypes, typ) {
+				errs = append(errs, fmt.Errorf("%s: unknown event %q", name, typ))
+			}
+		}
+		n, err := New(t)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		d.targets = append(d.targets, target{name: name, events: t.Events, notifier: n})
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Wants reports whether any target subscribed to typ, so callers can skip
+// building events nobody receives.
+func (d *Dispatcher) Wants(typ EventType) bool {
+	for _, t := range d.targets {
+		if t.wants(typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// Send delivers e to every subscribed target in parallel and waits for them.
+// Failures are logged; one broken target never blocks the others.
+func (d *Dispatcher) Send(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	var wg sync.WaitGroup
+	for _, t := range d.targets {
+		if !t.wants(e.Type) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+			defer cancel()
+			if err := t.notifier.Notify(ctx, e); err != nil {
+				log.Printf("Failed to send %s notification to %s: %v", e.Type, t.name, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,290 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bpouw/gopherseerr/store"
+)
+
+// delivery is one notification a receiver got.
+type delivery struct {
+	Header http.Header
+	Body   map[string]any
+}
+
+// receiver stands in for a webhook, Discord, Slack or Apprise endpoint. It
+// answers with status and records what it was sent.
+type receiver struct {
+	*httptest.Server
+	status int
+
+	mu         sync.Mutex
+	deliveries []delivery
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	t.Helper()
+	rcv := &receiver{status: status}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&body) != nil {
+			http.Error(w, "want a JSON POST", http.StatusBadRequest)
+			return
+		}
+		rcv.mu.Lock()
+		rcv.deliveries = append(rcv.deliveries, delivery{r.Header.Clone(), body})
+		rcv.mu.Unlock()
+		w.WriteHeader(rcv.status)
+		if rcv.status != http.StatusOK {
+			io.WriteString(w, `{"error": "rate limited"}`)
+		}
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+func (rcv *receiver) received() []delivery {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return rcv.deliveries
+}
+
+func testEvent() Event {
+	return Event{
+		Type:    EventApproved,
+		Subject: "Request approved: Breaking Bad",
+		Message: "alice's request for Season 2 was approved.",
+		Request: store.Request{ID: 7, TMDBID: 1396, MediaType: "tv", RequestType: "season", SeasonNumber: 2, Status: store.StatusApproved},
+		Time:    time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+// roundTrip decodes v the way a receiver does.
+func roundTrip(t *testing.T, v any) map[string]any {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestPayloads(t *testing.T) {
+	e := testEvent()
+	tests := []struct {
+		name       string
+		target     Target
+		want       any
+		wantHeader map[string]string
+	}{
+		{
+			name:       "webhook",
+			target:     Target{Type: "webhook", Headers: map[string]string{"Authorization": "Bearer s3cret"}},
+			want:       e,
+			wantHeader: map[string]string{"Authorization": "Bearer s3cret"},
+		},
+		{
+			name:   "discord",
+			target: Target{Type: "discord"},
+			want: map[string]any{
+				"username": "Gopherseerr",
+				"embeds": []any{map[string]any{
+					"title":       e.Subject,
+					"description": e.Message,
+					"color":       0x4a8a4a,
+					"timestamp":   "2024-05-01T12:00:00Z",
+				}},
+			},
+		},
+		{
+			name:   "slack",
+			target: Target{Type: "slack"},
+			want: map[string]any{
+				"text": e.Subject,
+				"attachments": []any{map[string]any{
+					"color": "#4a8a4a",
+					"title": e.Subject,
+					"text":  e.Message,
+				}},
+			},
+		},
+		{
+			name:   "apprise, stateless",
+			target: Target{Type: "apprise", AppriseURLs: []string{"tgram://bot/chat", "mailto://me@example.com"}, Tag: "media"},
+			want: map[string]any{
+				"urls":  "tgram://bot/chat,mailto://me@example.com",
+				"title": e.Subject,
+				"body":  e.Message,
+				"type":  "success",
+				"tag":   "media",
+			},
+		},
+		{
+			name:   "apprise, stored configuration",
+			target: Target{Type: "apprise"},
+			want: map[string]any{
+				"title": e.Subject,
+				"body":  e.Message,
+				"type":  "success",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rcv := newReceiver(t, http.StatusOK)
+			tt.target.URL = rcv.URL
+			n, err := New(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := n.Notify(context.Background(), e); err != nil {
+				t.Fatal(err)
+			}
+			got := rcv.received()
+			if len(got) != 1 {
+				t.Fatalf("receiver got %d notifications, want 1", len(got))
+			}
+			if ct := got[0].Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			for k, v := range tt.wantHeader {
+				if got[0].Header.Get(k) != v {
+					t.Errorf("header %s = %q, want %q", k, got[0].Header.Get(k), v)
+				}
+			}
+			if want := roundTrip(t, tt.want); !reflect.DeepEqual(got[0].Body, want) {
+				t.Errorf("payload = %v\nwant %v", got[0].Body, want)
+			}
+		})
+	}
+}
+
+func TestNotifyErrors(t *testing.T) {
+	rejecting := newReceiver(t, http.StatusTooManyRequests)
+	gone := newReceiver(t, http.StatusOK)
+	gone.Close()
+	stalling := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body) // the server only notices a dropped client once the body is read
+		<-r.Context().Done()
+	}))
+	t.Cleanup(stalling.Close)
+
+	for _, typ := range []string{"webhook", "discord", "slack", "apprise"} {
+		t.Run(typ, func(t *testing.T) {
+			notifier := func(url string) Notifier {
+				n, err := New(Target{Type: typ, URL: url})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return n
+			}
+
+			err := notifier(rejecting.URL).Notify(context.Background(), testEvent())
+			if err == nil || !strings.Contains(err.Error(), "returned status 429") || !strings.Contains(err.Error(), "rate limited") {
+				t.Errorf("rejected: got %v, want the status and response", err)
+			}
+
+			if err := notifier(gone.URL).Notify(context.Background(), testEvent()); err == nil {
+				t.Error("unreachable: got no error")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if err := notifier(stalling.URL).Notify(ctx, testEvent()); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("timed out: got %v, want %v", err, context.DeadlineExceeded)
+			}
+		})
+	}
+}
+
+func TestNewRejectsIncompleteTargets(t *testing.T) {
+	tests := []struct {
+		target Target
+		want   string
+	}{
+		{Target{Type: "webhook"}, "webhook notification target is missing url"},
+		{Target{Type: "discord"}, "discord notification target is missing url"},
+		{Target{Type: "slack"}, "slack notification target is missing url"},
+		{Target{Type: "apprise"}, "apprise notification target is missing url"},
+		{Target{Type: "email", SMTPHost: "smtp.example.com"}, "needs smtp_host, from and to"},
+		{Target{URL: "http://example.com"}, "missing type"},
+		{Target{Type: "gotify", URL: "http://example.com"}, `unknown notification target type "gotify"`},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.target); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("New(%+v) = %v, want an error containing %q", tt.target, err, tt.want)
+		}
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	all := newReceiver(t, http.StatusOK)
+	denials := newReceiver(t, http.StatusOK)
+	broken := newReceiver(t, http.StatusInternalServerError)
+	d, err := NewDispatcher([]Target{
+		{Type: "webhook", URL: broken.URL},
+		{Type: "webhook", URL: all.URL},
+		{Type: "discord", URL: denials.URL, Events: []EventType{EventDenied}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Wants(EventDenied) || !d.Wants(EventCreated) {
+		t.Error("Wants is false for a subscribed event")
+	}
+
+	e := testEvent()
+	e.Time = time.Time{}
+	d.Send(e)
+	if len(all.received()) != 1 || len(denials.received()) != 0 || len(broken.received()) != 1 {
+		t.Fatalf("got %d, %d and %d notifications, want 1, 0 and 1", len(all.received()), len(denials.received()), len(broken.received()))
+	}
+	if sent, ok := all.received()[0].Body["time"].(string); !ok || strings.HasPrefix(sent, "0001") {
+		t.Errorf("sent time %v, want it filled in", all.received()[0].Body["time"])
+	}
+
+	e.Type = EventDenied
+	d.Send(e)
+	if len(all.received()) != 2 || len(denials.received()) != 1 {
+		t.Errorf("got %d and %d notifications, want 2 and 1", len(all.received()), len(denials.received()))
+	}
+}
+
+func TestNewDispatcherReportsEveryError(t *testing.T) {
+	_, err := NewDispatcher([]Target{
+		{Type: "webhook", Name: "ops", URL: "http://example.com", Events: []EventType{"request_lost"}},
+		{Type: "slack"},
+	})
+	if err == nil {
+		t.Fatal("got no error")
+	}
+	for _, want := range []string{`ops: unknown event "request_lost"`, "slack #2: slack notification target is missing url"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestDispatcherWantsNothingUnsubscribed(t *testing.T) {
+	d, err := NewDispatcher([]Target{{Type: "webhook", URL: "http://example.com", Events: []EventType{EventAvailable}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Wants(EventCreated) {
+		t.Error("Wants(request_created) with only request_available subscribed")
+	}
+}
@@ -0,0 +1,114 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Webhook POSTs the event as JSON to any URL.
+type Webhook struct {
+	URL     string
+	Headers map[string]string
+}
+
+func (w *Webhook) Notify(ctx context.Context, e Event) error {
+	return postJSON(ctx, w.URL, w.Headers, e)
+}
+
+// Discord posts an embed to a Discord webhook.
+type Discord struct {
+	URL string
+}
+
+type discordPayload struct {
+	Username string         `json:"username"`
+	Embeds   []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Color       int    `json:"color"`
+	Timestamp   string `json:"timestamp"`
+}
+
+// eventColors are the embed/attachment colours per event.
+var eventColors = map[EventType]int{
+	EventCreated:   0x3a5a8a,
+	EventApproved:  0x4a8a4a,
+	EventDenied:    0x8a3a3a,
+	EventFailed:    0x8a3a3a,
+	EventAvailable: 0x4a8a4a,
+}
+
+func (d *Discord) Notify(ctx context.Context, e Event) error {
+	return postJSON(ctx, d.URL, nil, discordPayload{
+		Username: "Gopherseerr",
+		Embeds: []discordEmbed{{
+			Title:       e.Subject,
+			Description: e.Message,
+			Color:       eventColors[e.Type],
+			Timestamp:   e.Time.Format(time.RFC3339),
+		}},
+	})
+}
+
+// Slack posts a message to a Slack incoming webhook. Mattermost and
+// Rocket.Chat accept the same payload.
+type Slack struct {
+	URL string
+}
+
+type slackPayload struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color string `json:"color"`
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+func (s *Slack) Notify(ctx context.Context, e Event) error {
+	return postJSON(ctx, s.URL, nil, slackPayload{
+		Text: e.Subject,
+		Attachments: []slackAttachment{{
+			Color: fmt.Sprintf("#%06x", eventColors[e.Type]),
+			Title: e.Subject,
+			Text:  e.Message,
+		}},
+	})
+}
+
+func postJSON(ctx context.Context, url string, headers map[string]string, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s returned status %d: %s", req.URL.Host, resp.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
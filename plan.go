@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/bpouw/gopherseerr/sonarr"
+	"github.com/bpouw/gopherseerr/store"
+)
+
+// A tvPlan lists what a TV request does in Sonarr. It is worked out before
+// anything changes, so it can be previewed (POST /api/v1/requests/plan) as
+// well as run.
+type tvPlan struct {
+	SeriesID int        `json:"series_id,omitempty"` // 0 when the series has to be added
+	TVDBID   int        `json:"tvdb_id"`
+	Title    string     `json:"title"`
+	Server   string     `json:"server,omitempty"` // the Sonarr it runs on, when there are several
+	Steps    []planStep `json:"steps"`            // empty when Sonarr already does what the request asks
+	Message  string     `json:"message"`
+
+	sonarr *sonarrInstance
+}
+
+// Operations of a plan step.
+const (
+	opAddSeries       = "add_series"
+	opMonitorSeasons  = "monitor_seasons"
+	opMonitorEpisodes = "monitor_episodes"
+	opSearchEpisodes  = "search_episodes"
+)
+
+type planStep struct {
+	Op       string       `json:"op"`
+	Seasons  []int        `json:"seasons,omitempty"`  // seasons to monitor
+	Episodes []episodeRef `json:"episodes,omitempty"` // episodes to monitor or search for
+
+	// Only set for add_series.
+	QualityProfileID  int    `json:"quality_profile_id,omitempty"`
+	LanguageProfileID int    `json:"language_profile_id,omitempty"`
+	RootFolder        string `json:"root_folder,omitempty"`
+}
+
+type episodeRef struct {
+	Season  int `json:"season"`
+	Episode int `json:"episode"`
+	ID      int `json:"id,omitempty"` // Sonarr episode ID, unknown until the series is added
+}
+
+func (e episodeRef) String() string {
+	return fmt.Sprintf("S%02dE%02d", e.Season, e.Episode)
+}
+
+// planTV works out the steps for a TV request from the series as Sonarr has
+// it: the library entry, or the lookup result (ID 0) when it has not been
+// added. episodes are the series' episodes in Sonarr, needed for requests
+// on a series in the library. Requested episodes are monitored one by one,
+// so Sonarr leaves the rest of their season alone.
+// However many seasons and episodes a batch request selects, the plan has
+// at most one step of each kind.
+func planTV(req store.Request, series *sonarr.Series, episodes []sonarr.Episode) (*tvPlan, error) {
+	plan := &tvPlan{SeriesID: series.ID, TVDBID: series.TvdbID, Title: series.Title}
+
+	var seasons []int
+	var eps []episodeRef
+	switch req.RequestType {
+	case "full_show":
+		for _, s := range series.Seasons {
+			if s.SeasonNumber > 0 {
+				seasons = append(seasons, s.SeasonNumber)
+			}
+		}
+		plan.Message = "Request to add the full show has been submitted!"
+	case "season":
+		seasons = []int{req.SeasonNumber}
+		plan.Message = fmt.Sprintf("Request to add Season %d has been submitted!", req.SeasonNumber)
+	case "episode":
+		eps = []episodeRef{{Season: req.SeasonNumber, Episode: req.EpisodeNumber}}
+		plan.Message = fmt.Sprintf("Search for %s has been triggered!", eps[0])
+	case "batch":
+		seasons = req.Seasons
+		for _, e := range req.Episodes {
+			eps = append(eps, episodeRef{Season: e.Season, Episode: e.Episode})
+		}
+		plan.Message = fmt.Sprintf("Request for %s has been submitted!", req.Selection())
+	default:
+		return nil, fmt.Errorf("unsupported TV request type %q", req.RequestType)
+	}
+
+	hasSeason := func(n int) bool {
+		return slices.ContainsFunc(series.Seasons, func(s sonarr.SonarrSeason) bool { return s.SeasonNumber == n })
+	}
+	for _, n := range seasons {
+		if !hasSeason(n) {
+			return nil, fmt.Errorf("%s has no season %d in Sonarr", series.Title, n)
+		}
+	}
+	for _, ep := range eps {
+		if !hasSeason(ep.Season) {
+			return nil, fmt.Errorf("%s has no season %d in Sonarr", series.Title, ep.Season)
+		}
+	}
+
+	var search []episodeRef
+	if series.ID == 0 {
+		plan.Steps = append(plan.Steps, planStep{Op: opAddSeries, Seasons: seasons})
+	} else {
+		var unmonitored []int
+		for _, s := range series.Seasons {
+			if slices.Contains(seasons, s.SeasonNumber) && !s.Monitored {
+				unmonitored = append(unmonitored, s.SeasonNumber)
+			}
+		}
+		if len(unmonitored) > 0 {
+			plan.Steps = append(plan.Steps, planStep{Op: opMonitorSeasons, Seasons: unmonitored})
+		}
+		// Like a series added with seasons, the seasons that get monitored
+		// are searched for their missing episodes.
+		for _, e := range episodes {
+			if slices.Contains(unmonitored, e.SeasonNumber) && !e.HasFile {
+				search = append(search, episodeRef{Season: e.SeasonNumber, Episode: e.EpisodeNumber, ID: e.ID})
+			}
+		}
+	}
+
+	if len(eps) > 0 {
+		// Until the series is added the episode IDs are unknown; both steps
+		// then share the slice, so the IDs are looked up once.
+		unmonitored := eps
+		if series.ID != 0 {
+			unmonitored = nil
+			for i, ep := range eps {
+				j := slices.IndexFunc(episodes, func(e sonarr.Episode) bool {
+					return e.SeasonNumber == ep.Season && e.EpisodeNumber == ep.Episode
+				})
+				if j < 0 {
+					return nil, fmt.Errorf("could not find %s in Sonarr", ep)
+				}
+				eps[i].ID = episodes[j].ID
+				if !episodes[j].Monitored {
+					unmonitored = append(unmonitored, eps[i])
+				}
+			}
+		}
+		if len(unmonitored) > 0 {
+			plan.Steps = append(plan.Steps, planStep{Op: opMonitorEpisodes, Episodes: unmonitored})
+		}
+		if search == nil {
+			search = eps
+		}
+		for _, ep := range eps {
+			if !slices.Contains(search, ep) {
+				search = append(search, ep)
+			}
+		}
+	}
+	if len(search) > 0 {
+		plan.Steps = append(plan.Steps, planStep{Op: opSearchEpisodes, Episodes: search})
+	}
+
+	if len(plan.Steps) == 0 {
+		switch req.RequestType {
+		case "full_show":
+			plan.Message = "Every season is already monitored in Sonarr."
+		case "season":
+			plan.Message = fmt.Sprintf("Season %d is already monitored in Sonarr.", req.SeasonNumber)
+		default:
+			plan.Message = fmt.Sprintf("%s are already monitored in Sonarr.", req.Selection())
+		}
+	}
+	return plan, nil
+}
+
+// planRequest picks the Sonarr for a TV request, looks the series up in it
+// and plans the request against it, without changing anything.
+func (s *server) planRequest(ctx context.Context, req store.Request) (*tvPlan, error) {
+	son, err := s.sonarrFor(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	series, err := son.FindSeriesByTMDB(ctx, req.TMDBID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up series: %w", err)
+	}
+	if series == nil {
+		if series, err = son.LookupSeries(ctx, req.TMDBID); err != nil {
+			return nil, err
+		}
+	}
+	var episodes []sonarr.Episode
+	if series.ID != 0 {
+		if episodes, err = son.GetEpisodes(ctx, series.ID); err != nil {
+			return nil, fmt.Errorf("failed to get episodes from Sonarr: %w", err)
+		}
+	}
+	plan, err := planTV(req, series, episodes)
+	if err != nil {
+		return nil, err
+	}
+	plan.Server, plan.sonarr = serverName(&son.instance, len(s.sonarrs)), son
+
+	for i := range plan.Steps {
+		if plan.Steps[i].Op == opAddSeries {
+			if err := son.fillAddSeries(ctx, req, &plan.Steps[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return plan, nil
+}
+
+// fillAddSeries picks the profiles and root folder a series is added with.
+func (son *sonarrInstance) fillAddSeries(ctx context.Context, req store.Request, step *planStep) error {
+	profileID := req.QualityProfileID
+	if profileID == 0 {
+		var err error
+		if profileID, err = son.qualityProfile.resolve(ctx); err != nil {
+			return err
+		}
+	}
+	rootFolder, err := son.rootFolders.choose(ctx, req, profileID)
+	if err != nil {
+		return err
+	}
+	languageProfileID, err := son.languageProfile.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	step.QualityProfileID, step.LanguageProfileID, step.RootFolder = profileID, languageProfileID, rootFolder
+	return nil
+}
+
+// runPlan carries out a plan and returns the message to show the user. If
+// the series was added to Sonarr after the plan was made, the request is
+// planned again against it, once.
+func (s *server) runPlan(ctx context.Context, req store.Request, plan *tvPlan, replanned bool) (string, error) {
+	son := plan.sonarr
+	seriesID := plan.SeriesID
+	for _, step := range plan.Steps {
+		switch step.Op {
+		case opAddSeries:
+			opts := sonarr.AddSeriesOptions{
+				TMDBID:            req.TMDBID,
+				QualityProfileID:  step.QualityProfileID,
+				LanguageProfileID: step.LanguageProfileID,
+				RootFolder:        step.RootFolder,
+				SeasonsToMonitor:  make(map[int]bool),
+			}
+			for _, n := range step.Seasons {
+				opts.SeasonsToMonitor[n] = true
+			}
+			id, err := son.AddSeries(ctx, opts)
+			if errors.Is(err, sonarr.ErrAlreadyExists) && !replanned {
+				log.Printf("Series was added to %s in the meantime, planning the request again...", son.label)
+				req.Server = son.name
+				again, err := s.planRequest(ctx, req)
+				if err != nil {
+					return "", err
+				}
+				return s.runPlan(ctx, req, again, true)
+			}
+			if err != nil {
+				return "", err
+			}
+			seriesID = id
+
+		case opMonitorSeasons:
+			series, err := son.GetSeries(ctx, seriesID)
+			if err != nil {
+				return "", fmt.Errorf("failed to look up series: %w", err)
+			}
+			for i := range series.Seasons {
+				if slices.Contains(step.Seasons, series.Seasons[i].SeasonNumber) {
+					series.Seasons[i].Monitored = true
+				}
+			}
+			if err := son.UpdateSeries(ctx, series); err != nil {
+				return "", fmt.Errorf("failed to update series monitoring status: %w", err)
+			}
+
+		case opMonitorEpisodes:
+			ids, err := son.episodeIDs(ctx, seriesID, step.Episodes)
+			if err != nil {
+				return "", err
+			}
+			if err := son.MonitorEpisodes(ctx, ids, true); err != nil {
+				return "", fmt.Errorf("failed to monitor episodes: %w", err)
+			}
+			son.InvalidateSeries(plan.TVDBID)
+
+		case opSearchEpisodes:
+			ids, err := son.episodeIDs(ctx, seriesID, step.Episodes)
+			if err != nil {
+				return "", err
+			}
+			if err := son.SearchEpisodes(ctx, ids); err != nil {
+				return "", err
+			}
+		}
+	}
+	return plan.Message, nil
+}
+
+// episodeIDs returns the Sonarr IDs of episodes, looking up (and filling in)
+// the ones that were not known when the plan was made.
+func (son *sonarrInstance) episodeIDs(ctx context.Context, seriesID int, episodes []episodeRef) ([]int, error) {
+	var all []sonarr.Episode
+	ids := make([]int, len(episodes))
+	for i, ep := range episodes {
+		if ep.ID != 0 {
+			ids[i] = ep.ID
+			continue
+		}
+		if all == nil {
+			var err error
+			if all, err = son.GetEpisodes(ctx, seriesID); err != nil {
+				return nil, fmt.Errorf("failed to get episodes from Sonarr: %w", err)
+			}
+		}
+		j := slices.IndexFunc(all, func(e sonarr.Episode) bool {
+			return e.SeasonNumber == ep.Season && e.EpisodeNumber == ep.Episode
+		})
+		if j < 0 {
+			return nil, fmt.Errorf("could not find %s in Sonarr", ep)
+		}
+		ids[i] = all[j].ID
+		episodes[i].ID = ids[i]
+	}
+	return ids, nil
+}
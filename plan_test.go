@@ -0,0 +1,207 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bpouw/gopherseerr/sonarr"
+	"github.com/bpouw/gopherseerr/store"
+)
+
+// planSeries returns a show with specials and three seasons of two episodes,
+// of which only season 1 is monitored, as Sonarr has it: added with the ID,
+// or only looked up when id is 0.
+func planSeries(id int) (*sonarr.Series, []sonarr.Episode) {
+	series := &sonarr.Series{ID: id, TvdbID: 81189, Title: "Breaking Bad", Seasons: []sonarr.SonarrSeason{
+		{SeasonNumber: 0},
+		{SeasonNumber: 1, Monitored: true},
+		{SeasonNumber: 2},
+		{SeasonNumber: 3},
+	}}
+	if id == 0 {
+		return series, nil
+	}
+	return series, []sonarr.Episode{
+		{ID: 11, SeasonNumber: 1, EpisodeNumber: 1, Monitored: true, HasFile: true},
+		{ID: 12, SeasonNumber: 1, EpisodeNumber: 2, Monitored: true},
+		{ID: 21, SeasonNumber: 2, EpisodeNumber: 1, HasFile: true},
+		{ID: 22, SeasonNumber: 2, EpisodeNumber: 2},
+		{ID: 31, SeasonNumber: 3, EpisodeNumber: 1},
+		{ID: 32, SeasonNumber: 3, EpisodeNumber: 2},
+	}
+}
+
+func TestPlanTV(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     store.Request
+		added   bool
+		want    []planStep
+		message string
+	}{
+		{
+			name:    "full show, new series",
+			req:     store.Request{RequestType: "full_show"},
+			want:    []planStep{{Op: opAddSeries, Seasons: []int{1, 2, 3}}},
+			message: "Request to add the full show has been submitted!",
+		},
+		{
+			name:  "full show, existing series",
+			req:   store.Request{RequestType: "full_show"},
+			added: true,
+			want: []planStep{
+				{Op: opMonitorSeasons, Seasons: []int{2, 3}},
+				{Op: opSearchEpisodes, Episodes: []episodeRef{{2, 2, 22}, {3, 1, 31}, {3, 2, 32}}},
+			},
+			message: "Request to add the full show has been submitted!",
+		},
+		{
+			name:    "season, new series",
+			req:     store.Request{RequestType: "season", SeasonNumber: 2},
+			want:    []planStep{{Op: opAddSeries, Seasons: []int{2}}},
+			message: "Request to add Season 2 has been submitted!",
+		},
+		{
+			name:  "season, existing series",
+			req:   store.Request{RequestType: "season", SeasonNumber: 2},
+			added: true,
+			want: []planStep{
+				{Op: opMonitorSeasons, Seasons: []int{2}},
+				{Op: opSearchEpisodes, Episodes: []episodeRef{{2, 2, 22}}},
+			},
+			message: "Request to add Season 2 has been submitted!",
+		},
+		{
+			name:    "season already monitored",
+			req:     store.Request{RequestType: "season", SeasonNumber: 1},
+			added:   true,
+			message: "Season 1 is already monitored in Sonarr.",
+		},
+		{
+			name: "episode, new series",
+			req:  store.Request{RequestType: "episode", SeasonNumber: 2, EpisodeNumber: 1},
+			want: []planStep{
+				{Op: opAddSeries},
+				{Op: opMonitorEpisodes, Episodes: []episodeRef{{Season: 2, Episode: 1}}},
+				{Op: opSearchEpisodes, Episodes: []episodeRef{{Season: 2, Episode: 1}}},
+			},
+			message: "Search for S02E01 has been triggered!",
+		},
+		{
+			name:  "episode, existing series",
+			req:   store.Request{RequestType: "episode", SeasonNumber: 2, EpisodeNumber: 1},
+			added: true,
+			want: []planStep{
+				{Op: opMonitorEpisodes, Episodes: []episodeRef{{2, 1, 21}}},
+				{Op: opSearchEpisodes, Episodes: []episodeRef{{2, 1, 21}}},
+			},
+			message: "Search for S02E01 has been triggered!",
+		},
+		{
+			name:    "episode already monitored is still searched",
+			req:     store.Request{RequestType: "episode", SeasonNumber: 1, EpisodeNumber: 2},
+			added:   true,
+			want:    []planStep{{Op: opSearchEpisodes, Episodes: []episodeRef{{1, 2, 12}}}},
+			message: "Search for S01E02 has been triggered!",
+		},
+		{
+			name: "batch, new series",
+			req: store.Request{RequestType: "batch", Seasons: []int{1, 3}, Episodes: []store.Episode{
+				{Season: 2, Episode: 1}, {Season: 2, Episode: 2},
+			}},
+			want: []planStep{
+				{Op: opAddSeries, Seasons: []int{1, 3}},
+				{Op: opMonitorEpisodes, Episodes: []episodeRef{{Season: 2, Episode: 1}, {Season: 2, Episode: 2}}},
+				{Op: opSearchEpisodes, Episodes: []episodeRef{{Season: 2, Episode: 1}, {Season: 2, Episode: 2}}},
+			},
+			message: "Request for Seasons 1, 3, S02E01–E02 has been submitted!",
+		},
+		{
+			name: "batch collapses into one step of each kind",
+			req: store.Request{RequestType: "batch", Seasons: []int{1, 2, 3}, Episodes: []store.Episode{
+				{Season: 1, Episode: 2}, {Season: 3, Episode: 1},
+			}},
+			added: true,
+			want: []planStep{
+				{Op: opMonitorSeasons, Seasons: []int{2, 3}},
+				{Op: opMonitorEpisodes, Episodes: []episodeRef{{3, 1, 31}}},
+				{Op: opSearchEpisodes, Episodes: []episodeRef{{2, 2, 22}, {3, 1, 31}, {3, 2, 32}, {1, 2, 12}}},
+			},
+			message: "Request for Seasons 1, 2, 3, S01E02, S03E01 has been submitted!",
+		},
+		{
+			name: "batch already monitored",
+			req: store.Request{RequestType: "batch", Seasons: []int{1}, Episodes: []store.Episode{
+				{Season: 1, Episode: 1},
+			}},
+			added:   true,
+			want:    []planStep{{Op: opSearchEpisodes, Episodes: []episodeRef{{1, 1, 11}}}},
+			message: "Request for Season 1, S01E01 has been submitted!",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := 0
+			if tt.added {
+				id = 7
+			}
+			series, episodes := planSeries(id)
+			plan, err := planTV(tt.req, series, episodes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(plan.Steps, tt.want) {
+				t.Errorf("steps = %+v\nwant %+v", plan.Steps, tt.want)
+			}
+			if plan.Message != tt.message {
+				t.Errorf("message = %q, want %q", plan.Message, tt.message)
+			}
+			if plan.SeriesID != id || plan.TVDBID != 81189 {
+				t.Errorf("plan is for series %d (TVDB %d)", plan.SeriesID, plan.TVDBID)
+			}
+		})
+	}
+}
+
+func TestPlanTVFullShowAlreadyMonitored(t *testing.T) {
+	series, episodes := planSeries(7)
+	for i := range series.Seasons {
+		series.Seasons[i].Monitored = series.Seasons[i].SeasonNumber > 0
+	}
+	plan, err := planTV(store.Request{RequestType: "full_show"}, series, episodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Steps) != 0 || plan.Message != "Every season is already monitored in Sonarr." {
+		t.Errorf("got steps %+v and message %q, want none", plan.Steps, plan.Message)
+	}
+}
+
+func TestPlanTVErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   store.Request
+		added bool
+		want  string
+	}{
+		{"unknown season", store.Request{RequestType: "season", SeasonNumber: 9}, false, "has no season 9"},
+		{"unknown season of an episode", store.Request{RequestType: "episode", SeasonNumber: 9, EpisodeNumber: 1}, true, "has no season 9"},
+		{"unknown season in a batch", store.Request{RequestType: "batch", Seasons: []int{1, 9}}, true, "has no season 9"},
+		{"unknown episode", store.Request{RequestType: "episode", SeasonNumber: 2, EpisodeNumber: 5}, true, "could not find S02E05"},
+		{"unknown request type", store.Request{RequestType: "movie"}, false, "unsupported TV request type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := 0
+			if tt.added {
+				id = 7
+			}
+			series, episodes := planSeries(id)
+			_, err := planTV(tt.req, series, episodes)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProfileRef points at a Radarr/Sonarr profile from config.json. It accepts
+// either the numeric profile ID or the profile name as shown in the UI.
+type ProfileRef struct {
+	ID   int
+	Name string
+}
+
+func (p *ProfileRef) UnmarshalJSON(data []byte) error {
+	*p = ProfileRef{}
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if err := json.Unmarshal(data, &p.ID); err == nil {
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("profile must be a name or an ID, got %s", data)
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		p.ID = id
+		return nil
+	}
+	p.Name = name
+	return nil
+}
+
+func (p ProfileRef) MarshalJSON() ([]byte, error) {
+	if p.Name != "" {
+		return json.Marshal(p.Name)
+	}
+	if p.ID != 0 {
+		return json.Marshal(p.ID)
+	}
+	return []byte("null"), nil
+}
+
+func (p ProfileRef) IsZero() bool {
+	return p.ID == 0 && p.Name == ""
+}
+
+func (p ProfileRef) String() string {
+	if p.Name != "" {
+		return strconv.Quote(p.Name)
+	}
+	return strconv.Itoa(p.ID)
+}
+
+// namedProfile is the shape shared by Radarr and Sonarr quality and language
+// profiles.
+type namedProfile struct {
+	ID   int
+	Name string
+}
+
+type profileNotFoundError struct {
+	kind      string
+	ref       ProfileRef
+	available []string
+}
+
+func (e *profileNotFoundError) Error() string {
+	return fmt.Sprintf("%s %s does not exist (available: %s)", e.kind, e.ref, strings.Join(e.available, ", "))
+}
+
+// profileResolver turns a configured ProfileRef into a profile ID. The result
+// is cached once the service could be reached, so a Radarr/Sonarr that is down
+// at startup is resolved on first use instead.
+type profileResolver struct {
+	kind     string
+	ref      ProfileRef
+	fetch    func(ctx context.Context) ([]namedProfile, error)
+	optional bool // an empty profile list is fine, e.g. language profiles on Sonarr v4
+
+	mu sync.Mutex
+	id int
+}
+
+func (r *profileResolver) resolve(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.id != 0 {
+		return r.id, nil
+	}
+
+	profiles, err := r.fetch(ctx)
+	if err != nil {
+		if r.ref.ID != 0 {
+			return r.ref.ID, nil
+		}
+		return 0, fmt.Errorf("failed to fetch %ss: %w", r.kind, err)
+	}
+	if len(profiles) == 0 {
+		if r.optional {
+			return r.ref.ID, nil
+		}
+		return 0, fmt.Errorf("no %ss are configured", r.kind)
+	}
+
+	if r.ref.IsZero() {
+		r.id = profiles[0].ID
+		log.Printf("No %s configured, using %q", r.kind, profiles[0].Name)
+		return r.id, nil
+	}
+
+	var names []string
+	for _, p := range profiles {
+		if (r.ref.ID != 0 && p.ID == r.ref.ID) || (r.ref.Name != "" && strings.EqualFold(p.Name, r.ref.Name)) {
+			r.id = p.ID
+			return r.id, nil
+		}
+		names = append(names, fmt.Sprintf("%q (%d)", p.Name, p.ID))
+	}
+	return 0, &profileNotFoundError{kind: r.kind, ref: r.ref, available: names}
+}
+
+// matches reports whether ref names the profile with the given ID.
+func (r *profileResolver) matches(ctx context.Context, ref ProfileRef, id int) bool {
+	if ref.ID != 0 {
+		return ref.ID == id
+	}
+	profiles, err := r.fetch(ctx)
+	if err != nil {
+		return false
+	}
+	for _, p := range profiles {
+		if p.ID == id && strings.EqualFold(p.Name, ref.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// listTTL is how long profile and root folder lists are reused, so pages
+// with pickers do not call every Radarr and Sonarr on each render.
+const listTTL = time.Minute
+
+// cacheList wraps fetch so that a successful result is reused for ttl.
+// Concurrent callers wait for the same fetch.
+func cacheList[T any](ttl time.Duration, fetch func(ctx context.Context) ([]T, error)) func(ctx context.Context) ([]T, error) {
+	var mu sync.Mutex
+	var items []T
+	var fetched time.Time
+	return func(ctx context.Context) ([]T, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !fetched.IsZero() && time.Since(fetched) < ttl {
+			return slices.Clone(items), nil
+		}
+		got, err := fetch(ctx)
+		if err != nil {
+			return got, err
+		}
+		items, fetched = got, time.Now()
+		return slices.Clone(items), nil
+	}
+}
+
+// setupProfiles creates the profile resolvers of every Radarr and Sonarr of
+// s.
+func setupProfiles(s *server) {
+	for _, inst := range s.radarrs {
+		client := inst.MovieManager
+		inst.qualityProfile = &profileResolver{
+			kind: inst.label + " quality profile",
+			ref:  inst.config.QualityProfile,
+			fetch: cacheList(listTTL, func(ctx context.Context) ([]namedProfile, error) {
+				profiles, err := client.GetQualityProfiles(ctx)
+				out := make([]namedProfile, len(profiles))
+				for i, p := range profiles {
+					out[i] = namedProfile(p)
+				}
+				return out, err
+			}),
+		}
+	}
+	for _, inst := range s.sonarrs {
+		client := inst.SeriesManager
+		inst.qualityProfile = &profileResolver{
+			kind: inst.label + " quality profile",
+			ref:  inst.config.QualityProfile,
+			fetch: cacheList(listTTL, func(ctx context.Context) ([]namedProfile, error) {
+				profiles, err := client.GetQualityProfiles(ctx)
+				out := make([]namedProfile, len(profiles))
+				for i, p := range profiles {
+					out[i] = namedProfile(p)
+				}
+				return out, err
+			}),
+		}
+		inst.languageProfile = &profileResolver{
+			kind:     inst.label + " language profile",
+			ref:      inst.config.LanguageProfile,
+			optional: true,
+			fetch: cacheList(listTTL, func(ctx context.Context) ([]namedProfile, error) {
+				profiles, err := client.GetLanguageProfiles(ctx)
+				out := make([]namedProfile, len(profiles))
+				for i, p := range profiles {
+					out[i] = namedProfile(p)
+				}
+				return out, err
+			}),
+		}
+	}
+}
+
+// validateProfiles checks the configured profiles against every Radarr and
+// Sonarr. Profiles that do not exist are returned as an error; services that
+// cannot be reached are only logged.
+func validateProfiles(ctx context.Context, s *server) error {
+	var resolvers []*profileResolver
+	for _, inst := range s.radarrs {
+		resolvers = append(resolvers, inst.qualityProfile)
+	}
+	for _, inst := range s.sonarrs {
+		resolvers = append(resolvers, inst.qualityProfile, inst.languageProfile)
+	}
+	var errs []error
+	for _, r := range resolvers {
+		_, err := r.resolve(ctx)
+		var notFound *profileNotFoundError
+		switch {
+		case errors.As(err, &notFound):
+			errs = append(errs, err)
+		case err != nil:
+			log.Printf("Could not validate %s: %v", r.kind, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// profilePicker feeds the optional quality profile dropdown on request forms.
+type profilePicker struct {
+	Profiles []namedProfile
+	Default  int
+}
+
+// newProfilePicker returns nil when the picker is disabled or the profiles
+// cannot be loaded, in which case the forms fall back to the default profile.
+func (s *server) newProfilePicker(ctx context.Context, r *profileResolver) *profilePicker {
+	if !s.config.ProfilePicker {
+		return nil
+	}
+	profiles, err := r.fetch(ctx)
+	if err != nil {
+		log.Printf("Failed to load %ss for the profile picker: %v", r.kind, err)
+		return nil
+	}
+	def, _ := r.resolve(ctx)
+	return &profilePicker{Profiles: profiles, Default: def}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCacheList(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	var fail error
+	fetch := cacheList(time.Hour, func(ctx context.Context) ([]int, error) {
+		calls++
+		return []int{calls}, fail
+	})
+
+	fail = errors.New("down")
+	if _, err := fetch(ctx); err == nil {
+		t.Fatal("error not returned")
+	}
+	fail = nil
+	first, _ := fetch(ctx)
+	second, _ := fetch(ctx)
+	if calls != 2 || first[0] != 2 || second[0] != 2 {
+		t.Errorf("calls = %d, lists %v %v; want failures not cached and the second list reused", calls, first, second)
+	}
+	second[0] = 99
+	if third, _ := fetch(ctx); third[0] != 2 {
+		t.Error("callers share the cached slice")
+	}
+
+	expired := cacheList(0, func(ctx context.Context) ([]int, error) {
+		calls++
+		return nil, nil
+	})
+	expired(ctx)
+	expired(ctx)
+	if calls != 4 {
+		t.Errorf("calls = %d, want every call to fetch with a zero TTL", calls)
+	}
+}
@@ -2,23 +2,37 @@ package radarr
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+
+	"github.com/bpouw/gopherseerr/httpclient"
 )
 
 type Client struct {
-	BaseURL string
-	APIKey  string
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
 }
 
-func NewClient(baseURL, apiKey string) *Client {
-	return &Client{
-		BaseURL: baseURL,
-		APIKey:  apiKey,
+// NewClient returns a client for the Radarr instance at baseURL. opts configures
+// timeouts, TLS and proxying of every call.
+func NewClient(baseURL, apiKey string, opts httpclient.Options) (*Client, error) {
+	if opts.Service == "" {
+		opts.Service = "Radarr"
+	}
+	hc, err := httpclient.New(opts)
+	if err != nil {
+		return nil, err
 	}
+	return &Client{
+		BaseURL:    baseURL,
+		APIKey:     apiKey,
+		HTTPClient: hc,
+	}, nil
 }
 
 type AddOptions struct {
@@ -27,6 +41,7 @@ type AddOptions struct {
 }
 
 type Movie struct {
+	ID                  int        `json:"id,omitempty"`
 	Title               string     `json:"title,omitempty"`
 	TmdbID              int        `json:"tmdbId"`
 	Quality             int        `json:"qualityProfileId,omitempty"`
@@ -34,54 +49,161 @@ type Movie struct {
 	Monitored           bool       `json:"monitored"`
 	AddOptions          AddOptions `json:"addOptions"`
 	MinimumAvailability string     `json:"minimumAvailability,omitempty"` // Optional: e.g., "released"
+	HasFile             bool       `json:"hasFile,omitempty"`
 }
 
-func (c *Client) AddMovieByTMDB(tmdbID int, qualityProfileID int, rootFolder string) error {
+// Minimum availability values accepted by Radarr.
+const (
+	AvailabilityAnnounced = "announced"
+	AvailabilityInCinemas = "inCinemas"
+	AvailabilityReleased  = "released"
+)
+
+type AddMovieOptions struct {
+	TMDBID              int
+	QualityProfileID    int
+	RootFolder          string
+	MinimumAvailability string // defaults to AvailabilityReleased
+}
+
+func (c *Client) AddMovieByTMDB(ctx context.Context, tmdbID int, qualityProfileID int, rootFolder string) error {
+	return c.AddMovie(ctx, AddMovieOptions{
+		TMDBID:           tmdbID,
+		QualityProfileID: qualityProfileID,
+		RootFolder:       rootFolder,
+	})
+}
+
+// Movie returns the movie to POST to Radarr for opts.
+func (opts AddMovieOptions) Movie() Movie {
 	movie := Movie{
-		TmdbID:     tmdbID,
-		Quality:    qualityProfileID,
-		RootFolder: rootFolder,
+		TmdbID:     opts.TMDBID,
+		Quality:    opts.QualityProfileID,
+		RootFolder: opts.RootFolder,
 		Monitored:  true,
 		AddOptions: AddOptions{
 			SearchForMovie: true,
 			Monitor:        "movieOnly",
 		},
-		MinimumAvailability: "released", // Optional, avoids grabbing pre-releases
+		MinimumAvailability: opts.MinimumAvailability,
 	}
-
-	endpoint := fmt.Sprintf("%s/api/v3/movie", c.BaseURL)
-	u, err := url.Parse(endpoint)
-	if err != nil {
-		return err
+	if movie.MinimumAvailability == "" {
+		movie.MinimumAvailability = AvailabilityReleased // avoids grabbing pre-releases
 	}
-	query := u.Query()
-	query.Set("apikey", c.APIKey)
-	u.RawQuery = query.Encode()
+	return movie
+}
 
+func (c *Client) AddMovie(ctx context.Context, opts AddMovieOptions) error {
+	movie := opts.Movie()
+
+	endpoint := fmt.Sprintf("%s/api/v3/movie", c.BaseURL)
 	payload, err := json.Marshal(movie)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(payload))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(payload))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", c.APIKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-		bodyBytes, err2 := io.ReadAll(resp.Body)
-		if err2 != nil {
-			return fmt.Errorf("radarr API returned status %d and error reading response body: %v", resp.StatusCode, err2)
-		}
-		return fmt.Errorf("radarr API returned status %d: %s", resp.StatusCode, string(bodyBytes))
+		return newAPIError(resp)
 	}
 
 	return nil
 }
+
+// GetMovies returns every movie in the Radarr library.
+func (c *Client) GetMovies(ctx context.Context) ([]Movie, error) {
+	return c.getMovies(ctx, url.Values{})
+}
+
+// GetMovieByTMDB returns the library entry for a TMDB ID, or nil if Radarr
+// does not have the movie.
+func (c *Client) GetMovieByTMDB(ctx context.Context, tmdbID int) (*Movie, error) {
+	movies, err := c.getMovies(ctx, url.Values{"tmdbId": {strconv.Itoa(tmdbID)}})
+	if err != nil {
+		return nil, err
+	}
+	for i := range movies {
+		if movies[i].TmdbID == tmdbID {
+			return &movies[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *Client) getMovies(ctx context.Context, query url.Values) ([]Movie, error) {
+	var movies []Movie
+	if err := c.get(ctx, "/api/v3/movie", query, &movies); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+type QualityProfile struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// GetQualityProfiles returns the quality profiles configured in Radarr.
+func (c *Client) GetQualityProfiles(ctx context.Context) ([]QualityProfile, error) {
+	var profiles []QualityProfile
+	if err := c.get(ctx, "/api/v3/qualityprofile", url.Values{}, &profiles); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+type RootFolder struct {
+	ID         int    `json:"id"`
+	Path       string `json:"path"`
+	Accessible bool   `json:"accessible"`
+	FreeSpace  int64  `json:"freeSpace"`
+}
+
+// GetRootFolders returns the root folders configured in Radarr.
+func (c *Client) GetRootFolders(ctx context.Context) ([]RootFolder, error) {
+	var folders []RootFolder
+	if err := c.get(ctx, "/api/v3/rootfolder", url.Values{}, &folders); err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+// get performs a GET against the Radarr API and decodes the JSON response
+// into out.
+func (c *Client) get(ctx context.Context, path string, query url.Values, out any) error {
+	u, err := url.Parse(c.BaseURL + path)
+	if err != nil {
+		return err
+	}
+	u.RawQuery = query.Encode()
+
+	// The key goes in a header: errors quote the URL, and they are shown
+	// to users.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Api-Key", c.APIKey)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,154 @@
+package radarr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bpouw/gopherseerr/httpclient"
+	"github.com/bpouw/gopherseerr/internal/testutil"
+)
+
+func newTestClient(t *testing.T, url string) *Client {
+	t.Helper()
+	c, err := NewClient(url, "key", httpclient.Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestContextAbortsCalls(t *testing.T) {
+	testutil.CheckContextAborts(t, newTestClient, []testutil.Call[*Client]{
+		{Name: "AddMovie", Do: func(ctx context.Context, c *Client) error {
+			return c.AddMovie(ctx, AddMovieOptions{TMDBID: 603, QualityProfileID: 1, RootFolder: "/movies"})
+		}},
+		{Name: "GetMovies", Do: func(ctx context.Context, c *Client) error { _, err := c.GetMovies(ctx); return err }},
+		{Name: "GetMovieByTMDB", Do: func(ctx context.Context, c *Client) error { _, err := c.GetMovieByTMDB(ctx, 603); return err }},
+		{Name: "GetQualityProfiles", Do: func(ctx context.Context, c *Client) error { _, err := c.GetQualityProfiles(ctx); return err }},
+		{Name: "GetRootFolders", Do: func(ctx context.Context, c *Client) error { _, err := c.GetRootFolders(ctx); return err }},
+	})
+}
+
+func TestAddMovie(t *testing.T) {
+	srv := testutil.NewRecorder(t, map[string]testutil.Response{
+		"POST /api/v3/movie": {Status: http.StatusCreated, Body: `{"id": 1, "tmdbId": 603}`},
+	})
+	c := newTestClient(t, srv.URL)
+	if err := c.AddMovie(t.Context(), AddMovieOptions{TMDBID: 603, QualityProfileID: 4, RootFolder: "/movies"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := srv.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d requests, want 1", len(reqs))
+	}
+	req := reqs[0]
+	if req.URI != "/api/v3/movie" || req.Header.Get("X-Api-Key") != "key" || req.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("request = %s %s with key %q", req.Method, req.URI, req.Header.Get("X-Api-Key"))
+	}
+	var movie Movie
+	if err := json.Unmarshal([]byte(req.Body), &movie); err != nil {
+		t.Fatal(err)
+	}
+	want := Movie{
+		TmdbID: 603, Quality: 4, RootFolder: "/movies", Monitored: true,
+		AddOptions:          AddOptions{SearchForMovie: true, Monitor: "movieOnly"},
+		MinimumAvailability: AvailabilityReleased,
+	}
+	if movie != want {
+		t.Errorf("posted %+v\nwant %+v", movie, want)
+	}
+}
+
+func TestAddMovieErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		resp testutil.Response
+		want error
+	}{
+		{"already added", testutil.Response{Status: http.StatusBadRequest,
+			Body: `[{"propertyName": "TmdbId", "errorMessage": "This movie has already been added", "errorCode": "MovieExistsValidator"}]`},
+			ErrAlreadyExists},
+		{"wrong key", testutil.Response{Status: http.StatusUnauthorized}, ErrUnauthorized},
+		{"not found", testutil.Response{Status: http.StatusNotFound}, ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := testutil.NewRecorder(t, map[string]testutil.Response{"POST /api/v3/movie": tt.resp})
+			err := newTestClient(t, srv.URL).AddMovie(t.Context(), AddMovieOptions{TMDBID: 603})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.resp.Status {
+				t.Errorf("got %v, want an *APIError with status %d", err, tt.resp.Status)
+			}
+		})
+	}
+}
+
+func TestGetMovieByTMDB(t *testing.T) {
+	// Radarr versions that ignore the tmdbId filter answer with the whole
+	// library.
+	srv := testutil.NewRecorder(t, map[string]testutil.Response{
+		"GET /api/v3/movie": {Body: `[{"id": 1, "tmdbId": 604, "title": "The Matrix Reloaded"}, {"id": 2, "tmdbId": 603, "title": "The Matrix", "hasFile": true}]`},
+	})
+	c := newTestClient(t, srv.URL)
+
+	movie, err := c.GetMovieByTMDB(t.Context(), 603)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie == nil || movie.ID != 2 || movie.Title != "The Matrix" || !movie.HasFile {
+		t.Errorf("got %+v, want The Matrix", movie)
+	}
+	if movie, err := c.GetMovieByTMDB(t.Context(), 605); movie != nil || err != nil {
+		t.Errorf("unknown movie: got %+v, %v, want nil", movie, err)
+	}
+
+	reqs := srv.Requests()
+	if reqs[0].URI != "/api/v3/movie?tmdbId=603" || reqs[0].Header.Get("X-Api-Key") != "key" {
+		t.Errorf("request = %s with key %q", reqs[0].URI, reqs[0].Header.Get("X-Api-Key"))
+	}
+}
+
+func TestGetProfilesAndFolders(t *testing.T) {
+	srv := testutil.NewRecorder(t, map[string]testutil.Response{
+		"GET /api/v3/qualityprofile": {Body: `[{"id": 1, "name": "Any"}, {"id": 4, "name": "HD-1080p"}]`},
+		"GET /api/v3/rootfolder":     {Body: `[{"id": 1, "path": "/movies", "accessible": true, "freeSpace": 1024}]`},
+	})
+	c := newTestClient(t, srv.URL)
+
+	profiles, err := c.GetQualityProfiles(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 || profiles[1] != (QualityProfile{ID: 4, Name: "HD-1080p"}) {
+		t.Errorf("profiles = %+v", profiles)
+	}
+	folders, err := c.GetRootFolders(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(folders) != 1 || folders[0] != (RootFolder{ID: 1, Path: "/movies", Accessible: true, FreeSpace: 1024}) {
+		t.Errorf("folders = %+v", folders)
+	}
+}
+
+func TestErrorsHideAPIKey(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	c, _ := NewClient(srv.URL, "s3cret-key", httpclient.Options{MaxRetries: -1})
+	_, err := c.GetMovieByTMDB(context.Background(), 603)
+	if err == nil {
+		t.Fatal("got no error from a closed server")
+	}
+	if strings.Contains(err.Error(), "s3cret-key") {
+		t.Errorf("error = %q, want no API key in it", err)
+	}
+}
@@ -0,0 +1,36 @@
+package radarr
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bpouw/gopherseerr/httpclient"
+)
+
+// Errors returned by the client, for use with errors.Is. Responses from
+// Radarr that are not a success are returned as *APIError, which matches the
+// sentinel that fits its status code or validation errors.
+var (
+	ErrAlreadyExists = errors.New("movie already exists in Radarr")
+	ErrNotFound      = errors.New("not found in Radarr")
+	ErrUnauthorized  = errors.New("radarr rejected the API key")
+)
+
+// Service ties the errors of Radarr responses to the sentinels above.
+var Service = &httpclient.Service{
+	Name:          "Radarr",
+	ExistsCode:    "MovieExistsValidator",
+	AlreadyExists: ErrAlreadyExists,
+	NotFound:      ErrNotFound,
+	Unauthorized:  ErrUnauthorized,
+}
+
+type (
+	APIError          = httpclient.APIError
+	ValidationFailure = httpclient.ValidationFailure
+)
+
+// newAPIError reads the body of a failed response.
+func newAPIError(resp *http.Response) *APIError {
+	return httpclient.NewAPIError(Service, resp)
+}
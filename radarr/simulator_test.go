@@ -0,0 +1,92 @@
+package radarr_test
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/bpouw/gopherseerr/fake"
+	"github.com/bpouw/gopherseerr/httpclient"
+	"github.com/bpouw/gopherseerr/radarr"
+)
+
+// simulated returns a client for a simulator serving f with the API key.
+func simulated(t *testing.T, f *fake.Radarr, apiKey string) *radarr.Client {
+	t.Helper()
+	srv := fake.NewRadarrServer(f)
+	t.Cleanup(srv.Close)
+	c, err := radarr.NewClient(srv.URL, apiKey, httpclient.Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSimulator(t *testing.T) {
+	f := fake.NewRadarr()
+	f.APIKey = "key"
+	f.Put(radarr.Movie{TmdbID: 604, Title: "The Matrix Reloaded", Monitored: true})
+	c := simulated(t, f, "key")
+	ctx := t.Context()
+
+	if err := c.AddMovie(ctx, radarr.AddMovieOptions{TMDBID: 603, QualityProfileID: 1, RootFolder: "/movies"}); err != nil {
+		t.Fatal(err)
+	}
+	movie, err := c.GetMovieByTMDB(ctx, 603)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie == nil || movie.ID != 2 || movie.RootFolder != "/movies" || !movie.Monitored ||
+		movie.MinimumAvailability != radarr.AvailabilityReleased {
+		t.Errorf("added movie = %+v", movie)
+	}
+	if movie, err := c.GetMovieByTMDB(ctx, 605); movie != nil || err != nil {
+		t.Errorf("unknown movie: got %+v, %v", movie, err)
+	}
+	if movies, err := c.GetMovies(ctx); err != nil || len(movies) != 2 {
+		t.Errorf("GetMovies = %+v, %v, want both movies", movies, err)
+	}
+
+	err = c.AddMovie(ctx, radarr.AddMovieOptions{TMDBID: 603, QualityProfileID: 1, RootFolder: "/movies"})
+	if !errors.Is(err, radarr.ErrAlreadyExists) {
+		t.Errorf("adding twice = %v, want ErrAlreadyExists", err)
+	}
+	if n := len(f.Movies()); n != 2 {
+		t.Errorf("library has %d movies after adding twice, want 2", n)
+	}
+
+	profiles, err := c.GetQualityProfiles(ctx)
+	if err != nil || !slices.Equal(profiles, f.QualityProfiles) {
+		t.Errorf("GetQualityProfiles = %+v, %v", profiles, err)
+	}
+	folders, err := c.GetRootFolders(ctx)
+	if err != nil || !slices.Equal(folders, f.RootFolders) {
+		t.Errorf("GetRootFolders = %+v, %v", folders, err)
+	}
+
+	want := []string{
+		"POST /api/v3/movie", "GET /api/v3/movie", "GET /api/v3/movie", "GET /api/v3/movie",
+		"POST /api/v3/movie", "GET /api/v3/qualityprofile", "GET /api/v3/rootfolder",
+	}
+	if got := f.Calls(); !slices.Equal(got, want) {
+		t.Errorf("simulator served %q\nwant %q", got, want)
+	}
+}
+
+func TestSimulatorErrors(t *testing.T) {
+	f := fake.NewRadarr()
+	f.APIKey = "key"
+
+	_, err := simulated(t, f, "wrong").GetMovieByTMDB(t.Context(), 603)
+	if !errors.Is(err, radarr.ErrUnauthorized) {
+		t.Errorf("wrong key: got %v, want ErrUnauthorized", err)
+	}
+
+	f.FailWith("GET /api/v3/rootfolder", errors.New("disk on fire"))
+	_, err = simulated(t, f, "key").GetRootFolders(t.Context())
+	var apiErr *radarr.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError || apiErr.Message != "disk on fire" {
+		t.Errorf("failing call: got %v, want a 500 with the message", err)
+	}
+}
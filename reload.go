@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bpouw/gopherseerr/notify"
+	"github.com/bpouw/gopherseerr/store"
+)
+
+// buildServer loads the templates and creates and validates the clients for
+// c, without touching the current server. The request ledger is shared by
+// every version of the config.
+func buildServer(ctx context.Context, c Config, requests *store.Store) (*server, error) {
+	s := &server{config: c, requests: requests}
+	var err error
+	if s.templates, err = parseTemplates(c.assetsDir()); err != nil {
+		return nil, fmt.Errorf("invalid templates: %w", err)
+	}
+	if err := setupClients(s); err != nil {
+		return nil, fmt.Errorf("invalid HTTP configuration: %w", err)
+	}
+	setupProfiles(s)
+	if err := validateProfiles(ctx, s); err != nil {
+		return nil, fmt.Errorf("invalid profile configuration: %w", err)
+	}
+	setupRootFolders(s)
+	if err := validateRootFolders(ctx, s); err != nil {
+		return nil, fmt.Errorf("invalid root folder configuration: %w", err)
+	}
+	if s.notifier, err = notify.NewDispatcher(c.Notifications); err != nil {
+		return nil, fmt.Errorf("invalid notification configuration: %w", err)
+	}
+	if s.userNotifiers, err = userNotifiers(c.Users); err != nil {
+		return nil, fmt.Errorf("invalid notification configuration: %w", err)
+	}
+	return s, nil
+}
+
+// restartSettings only take effect on a restart; a reload warns when they
+// change.
+var restartSettings = []string{"port", "requests_file", "server"}
+
+// reloadConfig reads the config again and swaps it in if it is valid. An
+// invalid config is logged and the running one is kept.
+func reloadConfig(ctx context.Context, path string) {
+	log.Println("Reloading configuration")
+	c, err := loadConfig(path)
+	if err != nil {
+		log.Printf("Keeping the current configuration, the new one is invalid:\n%v", err)
+		return
+	}
+	old := current.Load()
+	s, err := buildServer(ctx, c, old.requests)
+	if err != nil {
+		log.Printf("Keeping the current configuration: %v", err)
+		return
+	}
+	if reflect.DeepEqual(old.config.TMDBCache, c.TMDBCache) {
+		s.keepTMDBCache(old.tmdbCache) // TMDB answers the same with any API key
+	}
+	changes := configChanges(old.config, c)
+	current.Store(s)
+
+	if len(changes) == 0 {
+		log.Println("Configuration reloaded, no settings changed")
+		return
+	}
+	log.Println("Configuration reloaded:")
+	for _, change := range changes {
+		log.Printf("  %s", change)
+		for _, setting := range restartSettings {
+			if change.name == setting || strings.HasPrefix(change.name, setting+".") {
+				log.Printf("  %s only takes effect after a restart", change.name)
+			}
+		}
+	}
+}
+
+// watchConfig reloads the config on SIGHUP and whenever the config file
+// changes, until ctx is done. The file is polled, which works the same on
+// every platform and for files replaced by editors or config management.
+func watchConfig(ctx context.Context, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	stamp := func() (time.Time, int64) {
+		if path == "" {
+			return time.Time{}, 0
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, 0
+		}
+		return fi.ModTime(), fi.Size()
+	}
+	modTime, size := stamp()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			modTime, size = stamp()
+			reloadConfig(ctx, path)
+		case <-ticker.C:
+			m, n := stamp()
+			if m.IsZero() || (m.Equal(modTime) && n == size) {
+				continue // missing while being rewritten, or unchanged
+			}
+			modTime, size = m, n
+			reloadConfig(ctx, path)
+		}
+	}
+}
+
+// configChange is one setting that differs between two configs, named by
+// its JSON key, e.g. "radarr_url" or "server.tls_cert".
+type configChange struct {
+	name     string
+	old, new any // nil when the setting is unset
+}
+
+func (c configChange) String() string {
+	if secretSetting(c.name) {
+		return c.name + " (changed)"
+	}
+	format := func(v any) string {
+		if v == nil {
+			return "unset"
+		}
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	return fmt.Sprintf("%s: %s -> %s", c.name, format(c.old), format(c.new))
+}
+
+// secretSetting reports whether a setting may hold credentials and must not
+// be logged. Users, servers and notification targets carry passwords and
+// keys.
+func secretSetting(name string) bool {
+	for _, s := range []string{"api_key", "password", "token", "users", "servers", "notifications", "proxy"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func configChanges(before, after Config) []configChange {
+	a, b := flattenConfig(before), flattenConfig(after)
+	var changes []configChange
+	for key, value := range b {
+		if prev := a[key]; !reflect.DeepEqual(prev, value) {
+			changes = append(changes, configChange{name: key, old: prev, new: value})
+		}
+	}
+	for key, prev := range a {
+		if _, ok := b[key]; !ok {
+			changes = append(changes, configChange{name: key, old: prev})
+		}
+	}
+	slices.SortFunc(changes, func(x, y configChange) int { return strings.Compare(x.name, y.name) })
+	return changes
+}
+
+// flattenConfig maps every setting, including those of nested objects such as
+// "server", to its JSON value. Lists count as one setting.
+func flattenConfig(c Config) map[string]any {
+	data, _ := json.Marshal(c)
+	var raw map[string]any
+	json.Unmarshal(data, &raw)
+	out := make(map[string]any)
+	for key, value := range raw {
+		if nested, ok := value.(map[string]any); ok {
+			for k, v := range nested {
+				out[key+"."+k] = v
+			}
+			continue
+		}
+		out[key] = value
+	}
+	return out
+}
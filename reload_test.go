@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithServerKeepsServerForRequest(t *testing.T) {
+	defer current.Store(current.Load())
+	old, reloaded := &server{}, &server{}
+	current.Store(old)
+
+	var got []*server
+	h := withServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, serverFor(r))
+		current.Store(reloaded) // a reload while the request runs
+		got = append(got, serverFor(r))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if got[0] != old || got[1] != old {
+		t.Error("request switched servers halfway")
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if got[2] != reloaded {
+		t.Error("next request did not get the reloaded server")
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/bpouw/gopherseerr/httpclient"
+	"github.com/bpouw/gopherseerr/notify"
+	"github.com/bpouw/gopherseerr/radarr"
+	"github.com/bpouw/gopherseerr/sonarr"
+	"github.com/bpouw/gopherseerr/store"
+)
+
+// parseRequestForm turns a /request form post into a ledger entry. Returned
+// errors are caused by bad input.
+func parseRequestForm(r *http.Request) (store.Request, error) {
+	req := store.Request{
+		MediaType:    r.FormValue("type"),
+		RequestType:  r.FormValue("request_type"),
+		RootFolder:   r.FormValue("root_folder"),
+		Availability: r.FormValue("minimum_availability"),
+		Server:       r.FormValue("server"),
+	}
+	tmdbID, err := strconv.Atoi(r.FormValue("tmdb_id"))
+	if err != nil {
+		return req, errors.New("Invalid tmdb_id")
+	}
+	req.TMDBID = tmdbID
+
+	if v := r.FormValue("quality_profile"); v != "" {
+		profileID, err := strconv.Atoi(v)
+		if err != nil {
+			return req, errors.New("Invalid quality_profile")
+		}
+		req.QualityProfileID = profileID
+	}
+
+	if req.MediaType == "tv" {
+		switch req.RequestType {
+		case "season":
+			seasonNumber, err := strconv.Atoi(r.FormValue("season_number"))
+			if err != nil {
+				return req, errors.New("Invalid season_number")
+			}
+			req.SeasonNumber = seasonNumber
+		case "episode":
+			seasonNumber, err1 := strconv.Atoi(r.FormValue("season_number"))
+			episodeNumber, err2 := strconv.Atoi(r.FormValue("episode_number"))
+			if err1 != nil || err2 != nil {
+				return req, errors.New("Invalid season or episode number")
+			}
+			req.SeasonNumber = seasonNumber
+			req.EpisodeNumber = episodeNumber
+		case "batch":
+			// Checkboxes: season=2 and episode=S01E04, any number of each.
+			for _, v := range r.Form["season"] {
+				seasonNumber, err := strconv.Atoi(v)
+				if err != nil {
+					return req, errors.New("Invalid season")
+				}
+				req.Seasons = append(req.Seasons, seasonNumber)
+			}
+			for _, v := range r.Form["episode"] {
+				var ep store.Episode
+				if _, err := fmt.Sscanf(v, "S%dE%d", &ep.Season, &ep.Episode); err != nil {
+					return req, errors.New("Invalid episode")
+				}
+				req.Episodes = append(req.Episodes, ep)
+			}
+		}
+	}
+	return validateRequest(r, req)
+}
+
+// validateRequest checks a request built from a form or API call and clears
+// fields that do not apply to its type.
+func validateRequest(r *http.Request, req store.Request) (store.Request, error) {
+	if req.TMDBID <= 0 {
+		return req, errors.New("Invalid tmdb_id")
+	}
+	if req.RootFolder != "" && !isAdmin(r) {
+		return req, errors.New("Only admins can choose a root folder")
+	}
+	if req.QualityProfileID != 0 && !serverFor(r).config.ProfilePicker {
+		return req, errors.New("Choosing a quality profile is disabled, enable profile_picker to allow it")
+	}
+
+	if req.MediaType != "tv" || req.RequestType != "batch" {
+		req.Seasons, req.Episodes = nil, nil
+	}
+	switch req.MediaType {
+	case "movie":
+		req.RequestType = ""
+		req.SeasonNumber, req.EpisodeNumber = 0, 0
+		switch req.Availability {
+		case "", radarr.AvailabilityAnnounced, radarr.AvailabilityInCinemas, radarr.AvailabilityReleased:
+		default:
+			return req, errors.New("Invalid minimum_availability")
+		}
+	case "tv":
+		req.Availability = ""
+		switch req.RequestType {
+		case "full_show":
+			req.SeasonNumber, req.EpisodeNumber = 0, 0
+		case "season":
+			req.EpisodeNumber = 0
+		case "episode":
+		case "batch":
+			req.SeasonNumber, req.EpisodeNumber = 0, 0
+			return normalizeBatch(req)
+		default:
+			return req, errors.New("Unsupported TV request type")
+		}
+	default:
+		return req, errors.New("Unsupported media type")
+	}
+	return req, nil
+}
+
+// normalizeBatch sorts the seasons and episodes of a batch request and drops
+// duplicates. A batch of a single season or episode becomes a plain season or
+// episode request.
+func normalizeBatch(req store.Request) (store.Request, error) {
+	slices.Sort(req.Seasons)
+	req.Seasons = slices.Compact(req.Seasons)
+	slices.SortFunc(req.Episodes, func(a, b store.Episode) int {
+		return cmp.Or(cmp.Compare(a.Season, b.Season), cmp.Compare(a.Episode, b.Episode))
+	})
+	req.Episodes = slices.Compact(req.Episodes)
+	if slices.ContainsFunc(req.Seasons, func(n int) bool { return n < 0 }) ||
+		slices.ContainsFunc(req.Episodes, func(e store.Episode) bool { return e.Season < 0 || e.Episode <= 0 }) {
+		return req, errors.New("Invalid season or episode number")
+	}
+
+	switch {
+	case len(req.Seasons)+len(req.Episodes) == 0:
+		return req, errors.New("Select at least one season or episode")
+	case len(req.Seasons) == 1 && len(req.Episodes) == 0:
+		req.RequestType, req.SeasonNumber = "season", req.Seasons[0]
+		req.Seasons = nil
+	case len(req.Seasons) == 0 && len(req.Episodes) == 1:
+		req.RequestType = "episode"
+		req.SeasonNumber, req.EpisodeNumber = req.Episodes[0].Season, req.Episodes[0].Episode
+		req.Episodes = nil
+	}
+	return req, nil
+}
+
+// parseRequestFilter reads ledger filters from the query string.
+func parseRequestFilter(r *http.Request) (store.Filter, error) {
+	q := r.URL.Query()
+	filter := store.Filter{
+		MediaType:   q.Get("media_type"),
+		RequestType: q.Get("request_type"),
+		Status:      q.Get("status"),
+		User:        q.Get("user"),
+	}
+	if v := q.Get("tmdb_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, errors.New("Invalid tmdb_id")
+		}
+		filter.TMDBID = id
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return filter, errors.New("Invalid limit")
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+// submitRequest records req for the current user and, unless it has to wait
+// for approval, sends it to Radarr/Sonarr. It returns the ledger entry and the
+// message to show the user; the error reports why the request failed.
+func (s *server) submitRequest(r *http.Request, req store.Request) (store.Request, string, error) {
+	req.User = currentUsername(r)
+	if err := s.checkServer(req); err != nil {
+		return req, "", err
+	}
+
+	if needsApproval(r) {
+		req.Status = store.StatusPending
+		rec, err := s.requests.Add(req)
+		if err != nil {
+			return req, "", fmt.Errorf("failed to record request: %w", err)
+		}
+		s.notifyRequest(notify.EventCreated, rec)
+		return rec, "Your request has been sent to an admin for approval.", nil
+	}
+
+	successMessage, errAdd := s.executeRequest(r.Context(), &req)
+	req.Status = store.StatusSubmitted
+	if errAdd != nil {
+		req.Status = store.StatusFailed
+		req.Error = errAdd.Error()
+	}
+	rec, err := s.requests.Add(req)
+	if err != nil {
+		log.Println("Failed to record request:", err)
+		rec = req
+	}
+	if errAdd != nil {
+		s.notifyRequest(notify.EventFailed, rec)
+	} else {
+		s.notifyRequest(notify.EventCreated, rec)
+	}
+	return rec, successMessage, errAdd
+}
+
+// executeRequest sends a parsed request to Radarr or Sonarr and returns the
+// message to show the user on success. When there are several servers of the
+// service, it records the one the request went to in req.Server.
+func (s *server) executeRequest(ctx context.Context, req *store.Request) (string, error) {
+	tmdbID := req.TMDBID
+
+	switch req.MediaType {
+	case "movie":
+		rad, err := s.radarrFor(ctx, *req)
+		if err != nil {
+			return "", err
+		}
+		req.Server = serverName(&rad.instance, len(s.radarrs))
+		profileID := req.QualityProfileID
+		if profileID == 0 {
+			if profileID, err = rad.qualityProfile.resolve(ctx); err != nil {
+				return "", err
+			}
+		}
+		rootFolder, err := rad.rootFolders.choose(ctx, *req, profileID)
+		if err != nil {
+			return "", err
+		}
+		err = rad.AddMovie(ctx, radarr.AddMovieOptions{
+			TMDBID:              tmdbID,
+			QualityProfileID:    profileID,
+			RootFolder:          rootFolder,
+			MinimumAvailability: req.Availability,
+		})
+		if err != nil {
+			return "", err
+		}
+		return "Movie request successfully submitted!", nil
+
+	case "tv":
+		plan, err := s.planRequest(ctx, *req)
+		if err != nil {
+			return "", err
+		}
+		req.Server = plan.Server
+		return s.runPlan(ctx, *req, plan, false)
+	}
+	return "", fmt.Errorf("unsupported request %s/%s", req.MediaType, req.RequestType)
+}
+
+// requestError picks the status code and message for a request that could
+// not be sent to Radarr/Sonarr; status is used for errors without a better
+// match.
+func requestError(err error, status int) (int, string) {
+	var apiErr *httpclient.APIError
+	var unknownServer *unknownServerError
+	switch {
+	case errors.As(err, &unknownServer):
+		return http.StatusBadRequest, unknownServer.Error()
+	case errors.Is(err, radarr.ErrAlreadyExists):
+		return http.StatusConflict, "This movie is already in Radarr."
+	case errors.Is(err, sonarr.ErrAlreadyExists):
+		return http.StatusConflict, "This series is already in Sonarr."
+	case errors.Is(err, radarr.ErrUnauthorized):
+		return http.StatusBadGateway, "Radarr rejected the API key, check radarr_api_key in config.json."
+	case errors.Is(err, sonarr.ErrUnauthorized):
+		return http.StatusBadGateway, "Sonarr rejected the API key, check sonarr_api_key in config.json."
+	case errors.As(err, &apiErr) && len(apiErr.Validation) > 0:
+		return http.StatusUnprocessableEntity, apiErr.Service.Name + " rejected the request: " + apiErr.ValidationMessage()
+	}
+	return upstreamError(err, status, "Failed to process request: ")
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bpouw/gopherseerr/store"
+)
+
+func TestValidateRequestQualityProfile(t *testing.T) {
+	s := &server{}
+	r := httptest.NewRequest("POST", "/request", nil)
+	r = r.WithContext(context.WithValue(r.Context(), serverContextKey, s))
+	req := store.Request{TMDBID: 603, MediaType: "movie", QualityProfileID: 4}
+
+	s.config.ProfilePicker = false
+	if _, err := validateRequest(r, req); err == nil {
+		t.Error("quality profile accepted with profile_picker off")
+	}
+	s.config.ProfilePicker = true
+	got, err := validateRequest(r, req)
+	if err != nil || got.QualityProfileID != 4 {
+		t.Errorf("got %+v, %v; want the profile kept", got, err)
+	}
+}
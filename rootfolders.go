@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bpouw/gopherseerr/store"
+)
+
+// RootFolderRule sends matching requests to a different root folder than the
+// configured default, e.g. 4K movies or anime series. Every criterion that is
+// set has to match; the first matching rule wins.
+type RootFolderRule struct {
+	RootFolder       string     `json:"root_folder"`
+	QualityProfile   ProfileRef `json:"quality_profile"`   // e.g. "Ultra-HD"
+	Genres           []string   `json:"genres"`            // TMDB genre names, any of them matches
+	OriginalLanguage string     `json:"original_language"` // ISO 639-1 code, e.g. "ja"
+}
+
+func (r RootFolderRule) needsDetails() bool {
+	return len(r.Genres) > 0 || r.OriginalLanguage != ""
+}
+
+// rootFolder is the shape shared by Radarr and Sonarr root folders.
+type rootFolder struct {
+	ID         int
+	Path       string
+	Accessible bool
+	FreeSpace  int64
+}
+
+// FreeSpaceString formats the free space for display, e.g. "1.2 TB".
+func (f rootFolder) FreeSpaceString() string {
+	const unit = 1024
+	if f.FreeSpace < unit {
+		return fmt.Sprintf("%d B", f.FreeSpace)
+	}
+	div, exp := int64(unit), 0
+	for n := f.FreeSpace / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(f.FreeSpace)/float64(div), "KMGTPE"[exp])
+}
+
+// samePath compares root folder paths the way users tend to type them:
+// ignoring a trailing separator and, for Windows paths, case.
+func samePath(a, b string) bool {
+	a, b = strings.TrimRight(a, `/\`), strings.TrimRight(b, `/\`)
+	if windowsPath(a) && windowsPath(b) {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// windowsPath reports whether path starts with a drive letter, e.g. "X:", or
+// is a UNC path, e.g. `\\nas\media`.
+func windowsPath(path string) bool {
+	if strings.HasPrefix(path, `\\`) {
+		return true
+	}
+	return len(path) >= 2 && path[1] == ':' && ('a' <= path[0]|0x20 && path[0]|0x20 <= 'z')
+}
+
+type rootFolderNotFoundError struct {
+	service   string
+	path      string
+	available []string
+}
+
+func (e *rootFolderNotFoundError) Error() string {
+	return fmt.Sprintf("%s root folder %q does not exist (available: %s)", e.service, e.path, strings.Join(e.available, ", "))
+}
+
+// mediaDetails is the TMDB metadata root folder rules and server criteria
+// can match on.
+type mediaDetails struct {
+	Genres           []string
+	OriginalLanguage string
+}
+
+// rootFolderSet picks the root folder for a request to one service.
+type rootFolderSet struct {
+	service    string
+	configured string
+	rules      []RootFolderRule
+	profiles   *profileResolver
+	fetch      func(ctx context.Context) ([]rootFolder, error)
+	details    func(ctx context.Context, tmdbID int) (mediaDetails, error)
+}
+
+// setupRootFolders creates the root folder sets of every Radarr and Sonarr
+// of s.
+func setupRootFolders(s *server) {
+	for _, inst := range s.radarrs {
+		client := inst.MovieManager
+		inst.rootFolders = &rootFolderSet{
+			service:    inst.label,
+			configured: inst.config.RootFolder,
+			rules:      slices.Clone(inst.config.RootFolderRules),
+			profiles:   inst.qualityProfile,
+			fetch: cacheList(listTTL, func(ctx context.Context) ([]rootFolder, error) {
+				folders, err := client.GetRootFolders(ctx)
+				out := make([]rootFolder, len(folders))
+				for i, f := range folders {
+					out[i] = rootFolder(f)
+				}
+				return out, err
+			}),
+			details: s.movieDetails,
+		}
+	}
+	for _, inst := range s.sonarrs {
+		client := inst.SeriesManager
+		inst.rootFolders = &rootFolderSet{
+			service:    inst.label,
+			configured: inst.config.RootFolder,
+			rules:      slices.Clone(inst.config.RootFolderRules),
+			profiles:   inst.qualityProfile,
+			fetch: cacheList(listTTL, func(ctx context.Context) ([]rootFolder, error) {
+				folders, err := client.GetRootFolders(ctx)
+				out := make([]rootFolder, len(folders))
+				for i, f := range folders {
+					out[i] = rootFolder(f)
+				}
+				return out, err
+			}),
+			details: s.showDetails,
+		}
+	}
+}
+
+// movieDetails returns what root folder rules and server criteria match a
+// movie on.
+func (s *server) movieDetails(ctx context.Context, tmdbID int) (mediaDetails, error) {
+	movie, err := s.tmdb.GetMovieDetails(ctx, tmdbID)
+	if err != nil {
+		return mediaDetails{}, err
+	}
+	d := mediaDetails{OriginalLanguage: movie.OriginalLanguage}
+	for _, g := range movie.Genres {
+		d.Genres = append(d.Genres, g.Name)
+	}
+	return d, nil
+}
+
+// showDetails returns what root folder rules and server criteria match a
+// show on.
+func (s *server) showDetails(ctx context.Context, tmdbID int) (mediaDetails, error) {
+	show, err := s.tmdb.GetTVShowDetails(ctx, tmdbID)
+	if err != nil {
+		return mediaDetails{}, err
+	}
+	d := mediaDetails{OriginalLanguage: show.OriginalLanguage}
+	for _, g := range show.Genres {
+		d.Genres = append(d.Genres, g.Name)
+	}
+	return d, nil
+}
+
+// find returns the root folder matching path.
+func (s *rootFolderSet) find(folders []rootFolder, path string) (rootFolder, error) {
+	var available []string
+	for _, f := range folders {
+		if samePath(f.Path, path) {
+			return f, nil
+		}
+		available = append(available, fmt.Sprintf("%q", f.Path))
+	}
+	return rootFolder{}, &rootFolderNotFoundError{service: s.service, path: path, available: available}
+}
+
+// validate checks the configured default and every rule against the root
+// folders the service reports. An unreachable service is only logged.
+func (s *rootFolderSet) validate(ctx context.Context) error {
+	folders, err := s.fetch(ctx)
+	if err != nil {
+		log.Printf("Could not validate %s root folders: %v", s.service, err)
+		return nil
+	}
+	if len(folders) == 0 {
+		return fmt.Errorf("%s has no root folders configured", s.service)
+	}
+
+	// Swap the configured paths for the service's own spelling, so a
+	// trailing slash or different casing never reaches the API.
+	var errs []error
+	paths := []*string{&s.configured}
+	for i := range s.rules {
+		if s.rules[i].RootFolder == "" {
+			errs = append(errs, fmt.Errorf("%s root folder rule is missing root_folder", s.service))
+			continue
+		}
+		paths = append(paths, &s.rules[i].RootFolder)
+	}
+	for _, path := range paths {
+		if *path == "" {
+			continue
+		}
+		f, err := s.find(folders, *path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !f.Accessible {
+			log.Printf("%s reports root folder %q as inaccessible", s.service, f.Path)
+		}
+		*path = f.Path
+	}
+	return errors.Join(errs...)
+}
+
+// choose returns the root folder for a request: an explicit choice made by an
+// admin, then the first matching rule, then the configured default and
+// finally the first folder the service reports.
+func (s *rootFolderSet) choose(ctx context.Context, req store.Request, profileID int) (string, error) {
+	if req.RootFolder != "" {
+		folders, err := s.fetch(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch %s root folders: %w", s.service, err)
+		}
+		f, err := s.find(folders, req.RootFolder)
+		if err != nil {
+			return "", err
+		}
+		return f.Path, nil
+	}
+
+	var details *mediaDetails
+	for _, rule := range s.rules {
+		if !rule.QualityProfile.IsZero() && !s.profiles.matches(ctx, rule.QualityProfile, profileID) {
+			continue
+		}
+		if rule.needsDetails() && details == nil {
+			d, err := s.details(ctx, req.TMDBID)
+			if err != nil {
+				log.Printf("Failed to fetch TMDB details for root folder rules: %v", err)
+				d = mediaDetails{}
+			}
+			details = &d
+		}
+		if rule.OriginalLanguage != "" && !strings.EqualFold(rule.OriginalLanguage, details.OriginalLanguage) {
+			continue
+		}
+		if len(rule.Genres) > 0 && !containsAnyFold(details.Genres, rule.Genres) {
+			continue
+		}
+		return rule.RootFolder, nil
+	}
+
+	if s.configured != "" {
+		return s.configured, nil
+	}
+	folders, err := s.fetch(ctx)
+	if err != nil {
+		return "", fmt.Errorf("no %s root folder configured and failed to fetch one: %w", s.service, err)
+	}
+	if len(folders) == 0 {
+		return "", fmt.Errorf("%s has no root folders configured", s.service)
+	}
+	return folders[0].Path, nil
+}
+
+// containsAnyFold reports whether any of want appears in have, ignoring case.
+func containsAnyFold(have, want []string) bool {
+	for _, w := range want {
+		if slices.ContainsFunc(have, func(h string) bool { return strings.EqualFold(h, w) }) {
+			return true
+		}
+	}
+	return false
+}
+
+// rootFolderPicker feeds the admin-only root folder dropdown on request forms.
+type rootFolderPicker struct {
+	Folders []rootFolder
+	Default string
+}
+
+// newRootFolderPicker returns nil unless the user is an admin and the service
+// has more than one root folder to choose from.
+func newRootFolderPicker(r *http.Request, s *rootFolderSet) *rootFolderPicker {
+	if !isAdmin(r) {
+		return nil
+	}
+	folders, err := s.fetch(r.Context())
+	if err != nil {
+		log.Printf("Failed to load %s root folders for the picker: %v", s.service, err)
+		return nil
+	}
+	if len(folders) < 2 {
+		return nil
+	}
+	picker := &rootFolderPicker{Folders: folders}
+	if f, err := s.find(folders, s.configured); err == nil {
+		picker.Default = f.Path
+	}
+	return picker
+}
+
+func validateRootFolders(ctx context.Context, s *server) error {
+	var errs []error
+	for _, inst := range s.radarrs {
+		errs = append(errs, inst.rootFolders.validate(ctx))
+	}
+	for _, inst := range s.sonarrs {
+		errs = append(errs, inst.rootFolders.validate(ctx))
+	}
+	return errors.Join(errs...)
+}
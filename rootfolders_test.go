@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestSamePath(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"/media/movies", "/media/movies/", true},
+		{"/media/Movies", "/media/movies", false},
+		{`X:\plex\Movies`, `x:\plex\movies\`, true},
+		{`\\NAS\Media`, `\\nas\media`, true},
+		{`X:\plex\movies`, `Y:\plex\movies`, false},
+		{"/media/movies", "/media/movies-4k", false},
+	}
+	for _, tt := range tests {
+		if got := samePath(tt.a, tt.b); got != tt.want {
+			t.Errorf("samePath(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// The example config has to start against any Radarr and Sonarr once the API
+// keys are filled in, so its root folders must not name made-up paths.
+func TestExampleConfigRootFolders(t *testing.T) {
+	var c Config
+	problems, err := decodeConfigFile("config.example.json", &c)
+	if err != nil || len(problems) > 0 {
+		t.Fatalf("config.example.json: %v %v", err, problems)
+	}
+	ts := newTestServer(t)
+	ts.radarrs[0].config = c.radarrInstances()[0]
+	ts.sonarrs[0].config = c.sonarrInstances()[0]
+	setupRootFolders(ts.server)
+	if err := validateRootFolders(t.Context(), ts.server); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ServerConfig configures the web server. Timeouts are durations such as
+// "30s"; empty fields use the defaults below.
+type ServerConfig struct {
+	ReadHeaderTimeout string `json:"read_header_timeout"`
+	ReadTimeout       string `json:"read_timeout"`
+	WriteTimeout      string `json:"write_timeout"`
+	IdleTimeout       string `json:"idle_timeout"`
+	ShutdownTimeout   string `json:"shutdown_timeout"` // how long in-flight requests get to finish on SIGTERM
+
+	TLSCert       string `json:"tls_cert"`
+	TLSKey        string `json:"tls_key"`
+	TLSSelfSigned bool   `json:"tls_self_signed"` // generate tls_cert and tls_key if they do not exist
+}
+
+// Default server timeouts. Writes get long enough for a request that waits
+// on a slow Sonarr plus its retries.
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	defaultWriteTimeout      = 2 * time.Minute
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultShutdownTimeout   = 30 * time.Second
+
+	defaultSelfSignedCert = "tls-cert.pem"
+	defaultSelfSignedKey  = "tls-key.pem"
+)
+
+type serverTimeouts struct {
+	readHeader, read, write, idle, shutdown time.Duration
+}
+
+func (c ServerConfig) timeouts() (serverTimeouts, error) {
+	t := serverTimeouts{
+		readHeader: defaultReadHeaderTimeout,
+		read:       defaultReadTimeout,
+		write:      defaultWriteTimeout,
+		idle:       defaultIdleTimeout,
+		shutdown:   defaultShutdownTimeout,
+	}
+	durations := []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"read_header_timeout", c.ReadHeaderTimeout, &t.readHeader},
+		{"read_timeout", c.ReadTimeout, &t.read},
+		{"write_timeout", c.WriteTimeout, &t.write},
+		{"idle_timeout", c.IdleTimeout, &t.idle},
+		{"shutdown_timeout", c.ShutdownTimeout, &t.shutdown},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return t, fmt.Errorf("invalid %s %q: %w", d.name, d.value, err)
+		}
+		*d.dst = v
+	}
+	return t, nil
+}
+
+// tlsFiles returns the certificate and key to serve with, or empty strings
+// for plain HTTP. A self-signed pair is created on first use.
+func (c ServerConfig) tlsFiles() (cert, key string, err error) {
+	cert, key = c.TLSCert, c.TLSKey
+	if !c.TLSSelfSigned {
+		if (cert == "") != (key == "") {
+			return "", "", errors.New("tls_cert and tls_key must be set together")
+		}
+		return cert, key, nil
+	}
+	if cert == "" {
+		cert = defaultSelfSignedCert
+	}
+	if key == "" {
+		key = defaultSelfSignedKey
+	}
+	if _, err := os.Stat(cert); err == nil {
+		return cert, key, nil
+	}
+	if err := writeSelfSignedCert(cert, key); err != nil {
+		return "", "", fmt.Errorf("failed to create self-signed certificate: %w", err)
+	}
+	log.Printf("Created a self-signed certificate in %s; browsers will warn about it once", cert)
+	return cert, key, nil
+}
+
+// writeSelfSignedCert creates a certificate valid for ten years for
+// localhost, this machine's host name and its IP addresses.
+func writeSelfSignedCert(certFile, keyFile string) error {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Gopherseerr"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+	}
+	if host, err := os.Hostname(); err == nil && host != "localhost" {
+		tmpl.DNSNames = append(tmpl.DNSNames, host)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+// serve runs the web server until ctx is done, then stops accepting
+// connections and gives in-flight requests and background notifications
+// shutdown_timeout to finish.
+func serve(ctx context.Context, handler http.Handler) error {
+	c := current.Load().config
+	cfg, port := c.Server, c.Port
+	t, err := cfg.timeouts()
+	if err != nil {
+		return fmt.Errorf("server: %w", err)
+	}
+	certFile, keyFile, err := cfg.tlsFiles()
+	if err != nil {
+		return fmt.Errorf("server: %w", err)
+	}
+
+	srv := &http.Server{
+		Addr:              ":" + port,
+		Handler:           handler,
+		ReadHeaderTimeout: t.readHeader,
+		ReadTimeout:       t.read,
+		WriteTimeout:      t.write,
+		IdleTimeout:       t.idle,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		if certFile != "" {
+			log.Println("Starting server with TLS on port", port)
+			errc <- srv.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			log.Println("Starting server on port", port)
+			errc <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down, waiting for open requests to finish")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), t.shutdown)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("open requests did not finish in time: %w", err)
+	}
+	if err := waitForNotifications(shutdownCtx); err != nil {
+		return fmt.Errorf("notifications were still being sent: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerTimeouts(t *testing.T) {
+	got, err := ServerConfig{}.timeouts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := serverTimeouts{
+		readHeader: defaultReadHeaderTimeout,
+		read:       defaultReadTimeout,
+		write:      defaultWriteTimeout,
+		idle:       defaultIdleTimeout,
+		shutdown:   defaultShutdownTimeout,
+	}
+	if got != want {
+		t.Errorf("defaults = %+v, want %+v", got, want)
+	}
+
+	got, err = ServerConfig{ReadTimeout: "5s", ShutdownTimeout: "1m30s"}.timeouts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.read, want.shutdown = 5*time.Second, 90*time.Second
+	if got != want {
+		t.Errorf("with overrides = %+v, want %+v", got, want)
+	}
+
+	_, err = ServerConfig{IdleTimeout: "2 minutes"}.timeouts()
+	if err == nil || !strings.Contains(err.Error(), `invalid idle_timeout "2 minutes"`) {
+		t.Errorf("got %v, want an error naming idle_timeout", err)
+	}
+}
+
+func TestTLSFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    ServerConfig
+		cert, key string
+		err       string
+	}{
+		{name: "plain HTTP", config: ServerConfig{}},
+		{name: "cert and key", config: ServerConfig{TLSCert: "c.pem", TLSKey: "k.pem"}, cert: "c.pem", key: "k.pem"},
+		{name: "cert without key", config: ServerConfig{TLSCert: "c.pem"}, err: "tls_cert and tls_key must be set together"},
+		{name: "key without cert", config: ServerConfig{TLSKey: "k.pem"}, err: "tls_cert and tls_key must be set together"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, key, err := tt.config.tlsFiles()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("got %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil || cert != tt.cert || key != tt.key {
+				t.Errorf("got %q, %q, %v, want %q, %q", cert, key, err, tt.cert, tt.key)
+			}
+		})
+	}
+}
+
+func TestSelfSignedCert(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	cert, key, err := ServerConfig{TLSSelfSigned: true}.tlsFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert != defaultSelfSignedCert || key != defaultSelfSignedKey {
+		t.Errorf("got %q, %q, want the default file names", cert, key)
+	}
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("localhost"); err != nil {
+		t.Error(err)
+	}
+	if leaf.NotAfter.Before(time.Now().AddDate(9, 0, 0)) {
+		t.Errorf("certificate expires %s, want ten years from now", leaf.NotAfter)
+	}
+	if info, err := os.Stat(key); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("key file: %v, %v, want mode 0600", info, err)
+	}
+
+	// The next start keeps the certificate browsers were told to trust.
+	before, _ := os.ReadFile(cert)
+	if _, _, err := (ServerConfig{TLSSelfSigned: true}).tlsFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(cert); string(after) != string(before) {
+		t.Error("the certificate was replaced on the second start")
+	}
+
+	// Configured paths are used for the generated pair.
+	dir := t.TempDir()
+	c := ServerConfig{TLSSelfSigned: true, TLSCert: filepath.Join(dir, "cert.pem"), TLSKey: filepath.Join(dir, "key.pem")}
+	cert, key, err = c.tlsFiles()
+	if err != nil || cert != c.TLSCert || key != c.TLSKey {
+		t.Fatalf("got %q, %q, %v", cert, key, err)
+	}
+	if _, err := tls.LoadX509KeyPair(cert, key); err != nil {
+		t.Error(err)
+	}
+}
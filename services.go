@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/bpouw/gopherseerr/notify"
+	"github.com/bpouw/gopherseerr/radarr"
+	"github.com/bpouw/gopherseerr/sonarr"
+	"github.com/bpouw/gopherseerr/store"
+	"github.com/bpouw/gopherseerr/tmdb"
+)
+
+// MetadataProvider is the part of TMDB the handlers use.
+type MetadataProvider interface {
+	Search(ctx context.Context, query string) ([]tmdb.MediaBasic, error)
+	GetMovieDetails(ctx context.Context, movieID int) (*tmdb.MovieDetails, error)
+	GetTVShowDetails(ctx context.Context, tvID int) (*tmdb.TVShowDetails, error)
+	GetSeasonDetails(ctx context.Context, tvID int, seasonNumber int) (*tmdb.SeasonDetails, error)
+	FindTVByTVDB(ctx context.Context, tvdbID int) (int, error)
+}
+
+// MovieManager is the part of Radarr the handlers use.
+type MovieManager interface {
+	AddMovie(ctx context.Context, opts radarr.AddMovieOptions) error
+	GetMovieByTMDB(ctx context.Context, tmdbID int) (*radarr.Movie, error)
+	GetQualityProfiles(ctx context.Context) ([]radarr.QualityProfile, error)
+	GetRootFolders(ctx context.Context) ([]radarr.RootFolder, error)
+}
+
+// SeriesManager is the part of Sonarr the handlers use.
+type SeriesManager interface {
+	LookupSeries(ctx context.Context, tmdbID int) (*sonarr.Series, error)
+	AddSeries(ctx context.Context, opts sonarr.AddSeriesOptions) (int, error)
+	UpdateSeries(ctx context.Context, series *sonarr.Series) error
+	GetSeries(ctx context.Context, id int) (*sonarr.Series, error)
+	GetSeriesByTMDB(ctx context.Context, tmdbID int) (*sonarr.Series, error)
+	FindSeriesByTMDB(ctx context.Context, tmdbID int) (*sonarr.Series, error)
+	InvalidateSeries(tvdbID int)
+	GetEpisodes(ctx context.Context, seriesID int) ([]sonarr.Episode, error)
+	MonitorEpisodes(ctx context.Context, episodeIDs []int, monitored bool) error
+	SearchEpisodes(ctx context.Context, episodeIDs []int) error
+	GetQualityProfiles(ctx context.Context) ([]sonarr.QualityProfile, error)
+	GetLanguageProfiles(ctx context.Context) ([]sonarr.LanguageProfile, error)
+	GetRootFolders(ctx context.Context) ([]sonarr.RootFolder, error)
+}
+
+var (
+	_ MetadataProvider = (*tmdb.Client)(nil)
+	_ MovieManager     = (*radarr.Client)(nil)
+	_ SeriesManager    = (*sonarr.Client)(nil)
+)
+
+// server is what the handlers work with: the config and templates, the
+// services, each Radarr and Sonarr with the profiles and root folders
+// resolved against it, and the request ledger. main builds one per config;
+// anything else, e.g. the fakes in package fake, can be plugged in to run
+// the handlers without live services.
+type server struct {
+	config    Config
+	templates *template.Template
+
+	tmdb    MetadataProvider
+	radarrs []*radarrInstance // at least one, exactly one is the default
+	sonarrs []*sonarrInstance
+
+	tmdbCache *tmdb.Cache // nil when caching is off
+
+	requests *store.Store
+	notifier *notify.Dispatcher // nil sends no notifications
+
+	userNotifiers map[string]*notify.Dispatcher // by lower-case username, for users with their own targets
+}
+
+// current is the server of the running config. A reload stores a new one
+// instead of changing it, so a request that loaded it never sees half of an
+// old and half of a new config.
+var current atomic.Pointer[server]
+
+// withServer loads the current server once per request, for serverFor.
+func withServer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), serverContextKey, current.Load())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// serverFor returns the server a request runs on: the one withServer loaded,
+// else the current one.
+func serverFor(r *http.Request) *server {
+	if s, ok := r.Context().Value(serverContextKey).(*server); ok {
+		return s
+	}
+	return current.Load()
+}
+
+// handle turns a server method into a handler that runs it on the server of
+// the request.
+func handle(h func(*server, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(serverFor(r), w, r)
+	}
+}
@@ -2,24 +2,44 @@ package sonarr
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"strings"
+	"slices"
+	"time"
+
+	"github.com/bpouw/gopherseerr/httpclient"
 )
 
 type Client struct {
-	BaseURL string
-	APIKey  string
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+
+	// IndexMaxAge is how long the series index is used before lookups reload
+	// the library, DefaultIndexMaxAge when zero. Negative disables the index.
+	IndexMaxAge time.Duration
+
+	index seriesIndex
 }
 
-func NewClient(baseURL, apiKey string) *Client {
-	return &Client{
-		BaseURL: baseURL,
-		APIKey:  apiKey,
+// NewClient returns a client for the Sonarr instance at baseURL. opts configures
+// timeouts, TLS and proxying of every call.
+func NewClient(baseURL, apiKey string, opts httpclient.Options) (*Client, error) {
+	if opts.Service == "" {
+		opts.Service = "Sonarr"
+	}
+	hc, err := httpclient.New(opts)
+	if err != nil {
+		return nil, err
 	}
+	return &Client{
+		BaseURL:    baseURL,
+		APIKey:     apiKey,
+		HTTPClient: hc,
+	}, nil
 }
 
 type AddOptions struct {
@@ -28,14 +48,25 @@ type AddOptions struct {
 }
 
 type SonarrSeason struct {
-	SeasonNumber int  `json:"seasonNumber"`
-	Monitored    bool `json:"monitored"`
+	SeasonNumber int         `json:"seasonNumber"`
+	Monitored    bool        `json:"monitored"`
+	Statistics   *Statistics `json:"statistics,omitempty"`
+}
+
+// Statistics holds the episode counts Sonarr reports for a series or season.
+// EpisodeCount only includes monitored (or already downloaded) episodes,
+// TotalEpisodeCount includes everything Sonarr knows about.
+type Statistics struct {
+	EpisodeFileCount  int `json:"episodeFileCount"`
+	EpisodeCount      int `json:"episodeCount"`
+	TotalEpisodeCount int `json:"totalEpisodeCount"`
 }
 
 type Series struct {
 	ID                int            `json:"id,omitempty"`
 	Title             string         `json:"title"`
 	TvdbID            int            `json:"tvdbId"`
+	TmdbID            int            `json:"tmdbId,omitempty"`
 	TitleSlug         string         `json:"titleSlug"`
 	QualityProfileID  int            `json:"qualityProfileId"`
 	LanguageProfileID int            `json:"languageProfileId"`
@@ -49,6 +80,7 @@ type Series struct {
 	Tags              []int          `json:"tags,omitempty"`
 	Year              int            `json:"year,omitempty"`
 	Seasons           []SonarrSeason `json:"seasons"`
+	Statistics        *Statistics    `json:"statistics,omitempty"`
 }
 
 type Image struct {
@@ -61,6 +93,13 @@ type Episode struct {
 	SeasonNumber  int    `json:"seasonNumber"`
 	EpisodeNumber int    `json:"episodeNumber"`
 	Title         string `json:"title"`
+	Monitored     bool   `json:"monitored"`
+	HasFile       bool   `json:"hasFile"`
+}
+
+type EpisodesMonitored struct {
+	EpisodeIDs []int `json:"episodeIds"`
+	Monitored  bool  `json:"monitored"`
 }
 
 type CommandRequest struct {
@@ -69,52 +108,33 @@ type CommandRequest struct {
 }
 
 type AddSeriesOptions struct {
-	TMDBID           int
-	QualityProfileID int
-	RootFolder       string
-	SeasonsToMonitor map[int]bool
-	AddEntireShow    bool
+	TMDBID            int
+	QualityProfileID  int
+	LanguageProfileID int // Only used by Sonarr v3, defaults to 1
+	RootFolder        string
+	SeasonsToMonitor  map[int]bool
+	AddEntireShow     bool
 }
 
-func (c *Client) AddSeries(opts AddSeriesOptions) (int, error) {
-	lookupURL := fmt.Sprintf("%s/api/v3/series/lookup?term=tmdb:%d", c.BaseURL, opts.TMDBID)
-	lookupReq, err := http.NewRequest("GET", lookupURL, nil)
-	if err != nil {
-		return 0, fmt.Errorf("failed to create lookup request: %w", err)
-	}
-	lookupReq.Header.Set("X-Api-Key", c.APIKey)
-
-	resp, err := http.DefaultClient.Do(lookupReq)
-	if err != nil {
-		return 0, fmt.Errorf("failed to execute lookup request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return 0, fmt.Errorf("failed series lookup, status %d: %s", resp.StatusCode, string(body))
-	}
-
-	var results []Series
-	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
-		return 0, fmt.Errorf("failed to decode lookup response: %w", err)
-	}
-	if len(results) == 0 {
-		return 0, fmt.Errorf("no series found for tmdb id %d", opts.TMDBID)
-	}
-
-	seriesToAdd := results[0]
+// Series returns the series to POST to Sonarr for a result of the series
+// lookup.
+func (opts AddSeriesOptions) Series(lookup Series) Series {
+	seriesToAdd := lookup
 	seriesToAdd.QualityProfileID = opts.QualityProfileID
 	seriesToAdd.RootFolderPath = opts.RootFolder
 	seriesToAdd.Monitored = true
 	seriesToAdd.SeasonFolder = true
-	seriesToAdd.LanguageProfileID = 1
+	seriesToAdd.LanguageProfileID = opts.LanguageProfileID
+	if seriesToAdd.LanguageProfileID == 0 {
+		seriesToAdd.LanguageProfileID = 1
+	}
 	seriesToAdd.SeriesType = "standard"
 	seriesToAdd.AddOptions = &AddOptions{
 		SearchForMissingEpisodes: len(opts.SeasonsToMonitor) > 0 || opts.AddEntireShow,
 		Monitor:                  "none",
 	}
 
+	seriesToAdd.Seasons = slices.Clone(lookup.Seasons)
 	for i := range seriesToAdd.Seasons {
 		seasonNum := seriesToAdd.Seasons[i].SeasonNumber
 		if seasonNum == 0 {
@@ -128,43 +148,68 @@ func (c *Client) AddSeries(opts AddSeriesOptions) (int, error) {
 			seriesToAdd.Seasons[i].Monitored = shouldMonitor
 		}
 	}
+	return seriesToAdd
+}
+
+// LookupSeries returns what Sonarr's series search finds for a TMDB ID: the
+// library entry when the show was added, otherwise the show as it would be
+// added, with its seasons and an ID of 0.
+func (c *Client) LookupSeries(ctx context.Context, tmdbID int) (*Series, error) {
+	var results []Series
+	if err := c.get(ctx, fmt.Sprintf("/api/v3/series/lookup?term=tmdb:%d", tmdbID), &results); err != nil {
+		return nil, fmt.Errorf("failed series lookup: %w", err)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no series found for tmdb id %d: %w", tmdbID, ErrNotFound)
+	}
+	c.index.rememberTVDBID(tmdbID, results[0].TvdbID)
+	return &results[0], nil
+}
 
+func (c *Client) AddSeries(ctx context.Context, opts AddSeriesOptions) (int, error) {
+	lookup, err := c.LookupSeries(ctx, opts.TMDBID)
+	if err != nil {
+		return 0, err
+	}
+
+	seriesToAdd := opts.Series(*lookup)
 	endpoint := fmt.Sprintf("%s/api/v3/series", c.BaseURL)
 	payload, err := json.Marshal(seriesToAdd)
 	if err != nil {
 		return 0, fmt.Errorf("failed to marshal series payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(payload))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(payload))
 	if err != nil {
 		return 0, fmt.Errorf("failed to create add series request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Api-Key", c.APIKey)
 
-	postResp, err := http.DefaultClient.Do(req)
+	postResp, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return 0, fmt.Errorf("failed to execute add series request: %w", err)
 	}
 	defer postResp.Body.Close()
 
-	bodyBytes, _ := io.ReadAll(postResp.Body)
 	if postResp.StatusCode != http.StatusCreated {
-		if strings.Contains(string(bodyBytes), "already been added") {
-			return 0, errors.New("series already exists")
+		err := newAPIError(postResp)
+		if errors.Is(err, ErrAlreadyExists) {
+			c.index.invalidate(seriesToAdd.TvdbID) // added behind the index's back
 		}
-		return 0, fmt.Errorf("sonarr API returned status %d: %s", postResp.StatusCode, string(bodyBytes))
+		return 0, err
 	}
+	c.index.invalidate(seriesToAdd.TvdbID)
 
 	var addedSeries Series
-	if err := json.Unmarshal(bodyBytes, &addedSeries); err != nil {
+	if err := json.NewDecoder(postResp.Body).Decode(&addedSeries); err != nil {
 		return 0, fmt.Errorf("failed to decode add series response: %w", err)
 	}
 
 	return addedSeries.ID, nil
 }
 
-func (c *Client) UpdateSeries(series *Series) error {
+func (c *Client) UpdateSeries(ctx context.Context, series *Series) error {
 	series.AddOptions = nil
 
 	endpoint := fmt.Sprintf("%s/api/v3/series/%d", c.BaseURL, series.ID)
@@ -173,77 +218,140 @@ func (c *Client) UpdateSeries(series *Series) error {
 		return fmt.Errorf("failed to marshal series payload for update: %w", err)
 	}
 
-	req, err := http.NewRequest("PUT", endpoint, bytes.NewReader(payload))
+	req, err := http.NewRequestWithContext(ctx, "PUT", endpoint, bytes.NewReader(payload))
 	if err != nil {
 		return fmt.Errorf("failed to create update series request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Api-Key", c.APIKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to execute update series request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusAccepted {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("sonarr update API returned status %d: %s", resp.StatusCode, string(body))
+		return newAPIError(resp)
 	}
+	c.index.invalidate(series.TvdbID)
 	return nil
 }
 
-func (c *Client) GetSeriesByTMDB(tmdbID int) (*Series, error) {
-	lookupURL := fmt.Sprintf("%s/api/v3/series/lookup?term=tmdb:%d", c.BaseURL, tmdbID)
-	lookupReq, err := http.NewRequest("GET", lookupURL, nil)
+func (c *Client) GetSeriesByTMDB(ctx context.Context, tmdbID int) (*Series, error) {
+	series, err := c.FindSeriesByTMDB(ctx, tmdbID)
 	if err != nil {
 		return nil, err
 	}
-	lookupReq.Header.Set("X-Api-Key", c.APIKey)
-	lookupResp, err := http.DefaultClient.Do(lookupReq)
-	if err != nil {
-		return nil, err
+	if series == nil {
+		return nil, fmt.Errorf("series with TMDB ID %d is not in the library: %w", tmdbID, ErrNotFound)
 	}
-	defer lookupResp.Body.Close()
+	return series, nil
+}
 
-	if lookupResp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("TMDB ID %d not found via Sonarr lookup", tmdbID)
+// FindSeriesByTMDB is like GetSeriesByTMDB but returns a nil series without
+// an error when the show exists on TVDB but has not been added to Sonarr.
+func (c *Client) FindSeriesByTMDB(ctx context.Context, tmdbID int) (*Series, error) {
+	tvdbID := c.index.tvdbID(tmdbID)
+	if tvdbID == 0 {
+		lookup, err := c.LookupSeries(ctx, tmdbID)
+		if err != nil {
+			return nil, err
+		}
+		tvdbID = lookup.TvdbID
 	}
+	return c.FindSeriesByTVDB(ctx, tvdbID)
+}
 
-	var lookupResults []Series
-	if err := json.NewDecoder(lookupResp.Body).Decode(&lookupResults); err != nil || len(lookupResults) == 0 {
-		return nil, fmt.Errorf("could not find series by TMDB ID %d in Sonarr", tmdbID)
+// GetSeries returns a series by its Sonarr ID.
+func (c *Client) GetSeries(ctx context.Context, id int) (*Series, error) {
+	var series Series
+	if err := c.get(ctx, fmt.Sprintf("/api/v3/series/%d", id), &series); err != nil {
+		return nil, err
 	}
-	targetTvdbID := lookupResults[0].TvdbID
+	return &series, nil
+}
 
-	allSeriesURL := fmt.Sprintf("%s/api/v3/series", c.BaseURL)
-	req, err := http.NewRequest("GET", allSeriesURL, nil)
-	if err != nil {
+// GetAllSeries returns every series in the Sonarr library.
+func (c *Client) GetAllSeries(ctx context.Context) ([]Series, error) {
+	var series []Series
+	if err := c.get(ctx, "/api/v3/series", &series); err != nil {
 		return nil, err
 	}
-	req.Header.Set("X-Api-Key", c.APIKey)
+	return series, nil
+}
+
+type QualityProfile struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type LanguageProfile struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
 
-	allSeriesResp, err := http.DefaultClient.Do(req)
+// GetQualityProfiles returns the quality profiles configured in Sonarr.
+func (c *Client) GetQualityProfiles(ctx context.Context) ([]QualityProfile, error) {
+	var profiles []QualityProfile
+	if err := c.get(ctx, "/api/v3/qualityprofile", &profiles); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// GetLanguageProfiles returns the language profiles configured in Sonarr.
+// Sonarr v4 dropped language profiles, in which case an empty list is
+// returned.
+func (c *Client) GetLanguageProfiles(ctx context.Context) ([]LanguageProfile, error) {
+	var profiles []LanguageProfile
+	err := c.get(ctx, "/api/v3/languageprofile", &profiles)
+	if errors.Is(err, ErrNotFound) {
+		return []LanguageProfile{}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer allSeriesResp.Body.Close()
+	return profiles, nil
+}
 
-	var allSeries []Series
-	if err := json.NewDecoder(allSeriesResp.Body).Decode(&allSeries); err != nil {
+type RootFolder struct {
+	ID         int    `json:"id"`
+	Path       string `json:"path"`
+	Accessible bool   `json:"accessible"`
+	FreeSpace  int64  `json:"freeSpace"`
+}
+
+// GetRootFolders returns the root folders configured in Sonarr.
+func (c *Client) GetRootFolders(ctx context.Context) ([]RootFolder, error) {
+	var folders []RootFolder
+	if err := c.get(ctx, "/api/v3/rootfolder", &folders); err != nil {
 		return nil, err
 	}
+	return folders, nil
+}
 
-	for i, s := range allSeries {
-		if s.TvdbID == targetTvdbID {
-			return &allSeries[i], nil
-		}
+// get performs a GET against the Sonarr API and decodes the JSON response
+// into out.
+func (c *Client) get(ctx context.Context, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+path, nil)
+	if err != nil {
+		return err
 	}
+	req.Header.Set("X-Api-Key", c.APIKey)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-	return nil, fmt.Errorf("series with TMDB ID %d not found in Sonarr library", tmdbID)
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
 }
 
-func (c *Client) SearchEpisodes(episodeIDs []int) error {
+func (c *Client) SearchEpisodes(ctx context.Context, episodeIDs []int) error {
 	endpoint := fmt.Sprintf("%s/api/v3/command", c.BaseURL)
 	cmd := CommandRequest{
 		Name:       "EpisodeSearch",
@@ -253,38 +361,67 @@ func (c *Client) SearchEpisodes(episodeIDs []int) error {
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(payload))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(payload))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Api-Key", c.APIKey)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("command failed with status %d: %s", resp.StatusCode, string(body))
+		return newAPIError(resp)
 	}
 	return nil
 }
 
-func (c *Client) GetEpisodes(seriesID int) ([]Episode, error) {
+// MonitorEpisodes sets the monitored flag of episodes, leaving the rest of
+// their seasons alone.
+func (c *Client) MonitorEpisodes(ctx context.Context, episodeIDs []int, monitored bool) error {
+	endpoint := fmt.Sprintf("%s/api/v3/episode/monitor", c.BaseURL)
+	payload, err := json.Marshal(EpisodesMonitored{EpisodeIDs: episodeIDs, Monitored: monitored})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", c.APIKey)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return newAPIError(resp)
+	}
+	return nil
+}
+
+func (c *Client) GetEpisodes(ctx context.Context, seriesID int) ([]Episode, error) {
 	endpoint := fmt.Sprintf("%s/api/v3/episode?seriesId=%d", c.BaseURL, seriesID)
-	req, err := http.NewRequest("GET", endpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("X-Api-Key", c.APIKey)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	var episodes []Episode
 	if err := json.NewDecoder(resp.Body).Decode(&episodes); err != nil {
 		return nil, err
@@ -0,0 +1,211 @@
+package sonarr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bpouw/gopherseerr/httpclient"
+	"github.com/bpouw/gopherseerr/internal/testutil"
+)
+
+func newTestClient(t *testing.T, url string) *Client {
+	t.Helper()
+	c, err := NewClient(url, "key", httpclient.Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestContextAbortsCalls(t *testing.T) {
+	testutil.CheckContextAborts(t, newTestClient, []testutil.Call[*Client]{
+		{Name: "LookupSeries", Do: func(ctx context.Context, c *Client) error { _, err := c.LookupSeries(ctx, 1396); return err }},
+		{Name: "AddSeries", Do: func(ctx context.Context, c *Client) error {
+			_, err := c.AddSeries(ctx, AddSeriesOptions{TMDBID: 1396, QualityProfileID: 1, RootFolder: "/tv"})
+			return err
+		}},
+		{Name: "UpdateSeries", Do: func(ctx context.Context, c *Client) error { return c.UpdateSeries(ctx, &Series{ID: 1, TvdbID: 81189}) }},
+		{Name: "GetSeries", Do: func(ctx context.Context, c *Client) error { _, err := c.GetSeries(ctx, 1); return err }},
+		{Name: "FindSeriesByTMDB", Do: func(ctx context.Context, c *Client) error { _, err := c.FindSeriesByTMDB(ctx, 1396); return err }},
+		{Name: "GetEpisodes", Do: func(ctx context.Context, c *Client) error { _, err := c.GetEpisodes(ctx, 1); return err }},
+		{Name: "MonitorEpisodes", Do: func(ctx context.Context, c *Client) error { return c.MonitorEpisodes(ctx, []int{11}, true) }},
+		{Name: "SearchEpisodes", Do: func(ctx context.Context, c *Client) error { return c.SearchEpisodes(ctx, []int{11}) }},
+		{Name: "GetQualityProfiles", Do: func(ctx context.Context, c *Client) error { _, err := c.GetQualityProfiles(ctx); return err }},
+	})
+}
+
+func TestCanceledCallsKeepBreakerClosed(t *testing.T) {
+	srv, arrived := testutil.StallingServer(t)
+	c, _ := NewClient(srv.URL, "key", httpclient.Options{BreakerThreshold: 2})
+	for range 3 {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-arrived
+			cancel()
+		}()
+		c.GetEpisodes(ctx, 1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var unreachable *httpclient.UnreachableError
+	if err := c.SearchEpisodes(ctx, []int{11}); errors.As(err, &unreachable) {
+		t.Errorf("canceled calls opened the breaker: %v", err)
+	}
+}
+
+const breakingBadLookup = `[{"title": "Breaking Bad", "tvdbId": 81189, "titleSlug": "breaking-bad", "seasons": [
+	{"seasonNumber": 0, "monitored": true},
+	{"seasonNumber": 1, "monitored": true},
+	{"seasonNumber": 2, "monitored": true}
+]}]`
+
+func TestAddSeries(t *testing.T) {
+	srv := testutil.NewRecorder(t, map[string]testutil.Response{
+		"GET /api/v3/series/lookup": {Body: breakingBadLookup},
+		"POST /api/v3/series":       {Status: http.StatusCreated, Body: `{"id": 7, "tvdbId": 81189}`},
+	})
+	c := newTestClient(t, srv.URL)
+	id, err := c.AddSeries(t.Context(), AddSeriesOptions{
+		TMDBID: 1396, QualityProfileID: 4, RootFolder: "/tv", SeasonsToMonitor: map[int]bool{2: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 7 {
+		t.Errorf("ID = %d, want 7", id)
+	}
+
+	reqs := srv.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("got %d requests, want the lookup and the add", len(reqs))
+	}
+	if reqs[0].URI != "/api/v3/series/lookup?term=tmdb:1396" {
+		t.Errorf("lookup = %s", reqs[0].URI)
+	}
+	for _, req := range reqs {
+		if req.Header.Get("X-Api-Key") != "key" {
+			t.Errorf("%s %s sent key %q", req.Method, req.URI, req.Header.Get("X-Api-Key"))
+		}
+	}
+	var added Series
+	if err := json.Unmarshal([]byte(reqs[1].Body), &added); err != nil {
+		t.Fatal(err)
+	}
+	if added.TvdbID != 81189 || added.QualityProfileID != 4 || added.LanguageProfileID != 1 || added.RootFolderPath != "/tv" ||
+		!added.Monitored || added.AddOptions == nil || !added.AddOptions.SearchForMissingEpisodes {
+		t.Errorf("posted %+v", added)
+	}
+	var monitored []bool
+	for _, s := range added.Seasons {
+		monitored = append(monitored, s.Monitored)
+	}
+	if want := []bool{false, false, true}; !reflect.DeepEqual(monitored, want) {
+		t.Errorf("monitored seasons = %v, want %v", monitored, want)
+	}
+}
+
+func TestAddSeriesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes map[string]testutil.Response
+		want   error
+	}{
+		{"unknown show", map[string]testutil.Response{
+			"GET /api/v3/series/lookup": {Body: `[]`},
+		}, ErrNotFound},
+		{"already added", map[string]testutil.Response{
+			"GET /api/v3/series/lookup": {Body: breakingBadLookup},
+			"POST /api/v3/series": {Status: http.StatusBadRequest,
+				Body: `[{"propertyName": "TvdbId", "errorMessage": "This series has already been added", "errorCode": "SeriesExistsValidator"}]`},
+		}, ErrAlreadyExists},
+		{"wrong key", map[string]testutil.Response{
+			"GET /api/v3/series/lookup": {Status: http.StatusUnauthorized},
+		}, ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := testutil.NewRecorder(t, tt.routes)
+			_, err := newTestClient(t, srv.URL).AddSeries(t.Context(), AddSeriesOptions{TMDBID: 1396})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateSeries(t *testing.T) {
+	srv := testutil.NewRecorder(t, map[string]testutil.Response{
+		"PUT /api/v3/series/7": {Status: http.StatusAccepted, Body: `{}`},
+	})
+	series := &Series{ID: 7, TvdbID: 81189, Monitored: true, AddOptions: &AddOptions{Monitor: "none"}}
+	if err := newTestClient(t, srv.URL).UpdateSeries(t.Context(), series); err != nil {
+		t.Fatal(err)
+	}
+	var sent map[string]any
+	if err := json.Unmarshal([]byte(srv.Requests()[0].Body), &sent); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sent["addOptions"]; ok || sent["tvdbId"] != 81189.0 {
+		t.Errorf("sent %v, want the series without addOptions", sent)
+	}
+}
+
+func TestEpisodes(t *testing.T) {
+	srv := testutil.NewRecorder(t, map[string]testutil.Response{
+		"GET /api/v3/episode":         {Body: `[{"id": 11, "seasonNumber": 1, "episodeNumber": 1, "title": "Pilot", "hasFile": true}]`},
+		"PUT /api/v3/episode/monitor": {Status: http.StatusAccepted, Body: `[]`},
+		"POST /api/v3/command":        {Status: http.StatusCreated, Body: `{"id": 1}`},
+	})
+	c := newTestClient(t, srv.URL)
+
+	episodes, err := c.GetEpisodes(t.Context(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Episode{{ID: 11, SeasonNumber: 1, EpisodeNumber: 1, Title: "Pilot", HasFile: true}}; !reflect.DeepEqual(episodes, want) {
+		t.Errorf("episodes = %+v", episodes)
+	}
+	if err := c.MonitorEpisodes(t.Context(), []int{11, 12}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SearchEpisodes(t.Context(), []int{11, 12}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct{ method, uri, body string }{
+		{"GET", "/api/v3/episode?seriesId=7", ""},
+		{"PUT", "/api/v3/episode/monitor", `{"episodeIds":[11,12],"monitored":true}`},
+		{"POST", "/api/v3/command", `{"name":"EpisodeSearch","episodeIds":[11,12]}`},
+	}
+	reqs := srv.Requests()
+	if len(reqs) != len(want) {
+		t.Fatalf("got %d requests, want %d", len(reqs), len(want))
+	}
+	for i, w := range want {
+		if reqs[i].Method != w.method || reqs[i].URI != w.uri || reqs[i].Body != w.body {
+			t.Errorf("request %d = %s %s %s\nwant %s %s %s", i, reqs[i].Method, reqs[i].URI, reqs[i].Body, w.method, w.uri, w.body)
+		}
+	}
+}
+
+func TestGetLanguageProfiles(t *testing.T) {
+	v3 := testutil.NewRecorder(t, map[string]testutil.Response{
+		"GET /api/v3/languageprofile": {Body: `[{"id": 1, "name": "English"}]`},
+	})
+	profiles, err := newTestClient(t, v3.URL).GetLanguageProfiles(t.Context())
+	if err != nil || len(profiles) != 1 || profiles[0].Name != "English" {
+		t.Errorf("Sonarr v3: got %+v, %v", profiles, err)
+	}
+
+	// Sonarr v4 has no language profiles and answers 404.
+	v4 := testutil.NewRecorder(t, nil)
+	profiles, err = newTestClient(t, v4.URL).GetLanguageProfiles(t.Context())
+	if err != nil || profiles == nil || len(profiles) != 0 {
+		t.Errorf("Sonarr v4: got %+v, %v, want an empty list", profiles, err)
+	}
+}
@@ -0,0 +1,36 @@
+package sonarr
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bpouw/gopherseerr/httpclient"
+)
+
+// Errors returned by the client, for use with errors.Is. Responses from
+// Sonarr that are not a success are returned as *APIError, which matches the
+// sentinel that fits its status code or validation errors.
+var (
+	ErrAlreadyExists = errors.New("series already exists in Sonarr")
+	ErrNotFound      = errors.New("not found in Sonarr")
+	ErrUnauthorized  = errors.New("sonarr rejected the API key")
+)
+
+// Service ties the errors of Sonarr responses to the sentinels above.
+var Service = &httpclient.Service{
+	Name:          "Sonarr",
+	ExistsCode:    "SeriesExistsValidator",
+	AlreadyExists: ErrAlreadyExists,
+	NotFound:      ErrNotFound,
+	Unauthorized:  ErrUnauthorized,
+}
+
+type (
+	APIError          = httpclient.APIError
+	ValidationFailure = httpclient.ValidationFailure
+)
+
+// newAPIError reads the body of a failed response.
+func newAPIError(resp *http.Response) *APIError {
+	return httpclient.NewAPIError(Service, resp)
+}
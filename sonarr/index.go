@@ -0,0 +1,229 @@
+package sonarr
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultIndexMaxAge is how long the series index is trusted when
+// Client.IndexMaxAge is zero.
+const DefaultIndexMaxAge = 10 * time.Minute
+
+// seriesIndex is the client's copy of the Sonarr library, indexed by TVDB ID,
+// so looking up a show does not download the whole library every time.
+type seriesIndex struct {
+	mu     sync.Mutex
+	loaded time.Time
+	byTVDB map[int]*Series
+	dirty  map[int]time.Time // TVDB IDs added or changed, and when
+
+	// TMDB to TVDB IDs, from Sonarr v4 libraries and from lookups. They do
+	// not change, so these survive reloads.
+	tvdbIDs map[int]int
+
+	group singleflight.Group
+}
+
+// lookup returns the indexed series with the TVDB ID, or nil when it is not
+// in the library. ok is false when the index cannot answer: it has not been
+// loaded, is older than maxAge, or the series changed since.
+func (x *seriesIndex) lookup(tvdbID int, maxAge time.Duration) (series *Series, ok bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, changed := x.dirty[tvdbID]; changed || x.loaded.IsZero() || time.Since(x.loaded) > maxAge {
+		return nil, false
+	}
+	if s := x.byTVDB[tvdbID]; s != nil {
+		return cloneSeries(s), true
+	}
+	return nil, true
+}
+
+// fresh reports whether the whole index can be used as is.
+func (x *seriesIndex) fresh(maxAge time.Duration) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return !x.loaded.IsZero() && time.Since(x.loaded) <= maxAge && len(x.dirty) == 0
+}
+
+func (x *seriesIndex) all() []Series {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	out := make([]Series, 0, len(x.byTVDB))
+	for _, s := range x.byTVDB {
+		out = append(out, *cloneSeries(s))
+	}
+	return out
+}
+
+// replace swaps in a full library listing requested at loaded. Series that
+// changed after that may be missing from it and stay marked as changed.
+func (x *seriesIndex) replace(library []Series, loaded time.Time) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.byTVDB = make(map[int]*Series, len(library))
+	for i := range library {
+		x.byTVDB[library[i].TvdbID] = cloneSeries(&library[i])
+		if library[i].TmdbID != 0 {
+			x.setTVDBID(library[i].TmdbID, library[i].TvdbID)
+		}
+	}
+	x.loaded = loaded
+	for tvdbID, changed := range x.dirty {
+		if changed.Before(loaded) {
+			delete(x.dirty, tvdbID)
+		}
+	}
+}
+
+// set records the state of one series as fetched at loaded, nil meaning it
+// is not in the library.
+func (x *seriesIndex) set(tvdbID int, series *Series, loaded time.Time) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.byTVDB == nil {
+		return // not loaded yet, the next full load picks it up
+	}
+	if series == nil {
+		delete(x.byTVDB, tvdbID)
+	} else {
+		x.byTVDB[tvdbID] = cloneSeries(series)
+	}
+	if x.dirty[tvdbID].Before(loaded) {
+		delete(x.dirty, tvdbID)
+	}
+}
+
+func (x *seriesIndex) invalidate(tvdbID int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.dirty == nil {
+		x.dirty = make(map[int]time.Time)
+	}
+	x.dirty[tvdbID] = time.Now()
+}
+
+func (x *seriesIndex) tvdbID(tmdbID int) int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.tvdbIDs[tmdbID]
+}
+
+func (x *seriesIndex) rememberTVDBID(tmdbID, tvdbID int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.setTVDBID(tmdbID, tvdbID)
+}
+
+func (x *seriesIndex) setTVDBID(tmdbID, tvdbID int) {
+	if x.tvdbIDs == nil {
+		x.tvdbIDs = make(map[int]int)
+	}
+	x.tvdbIDs[tmdbID] = tvdbID
+}
+
+// cloneSeries copies s deep enough that callers can change the seasons of
+// the copy without touching the index.
+func cloneSeries(s *Series) *Series {
+	c := *s
+	c.Seasons = slices.Clone(s.Seasons)
+	c.Tags = slices.Clone(s.Tags)
+	c.Images = slices.Clone(s.Images)
+	return &c
+}
+
+func (c *Client) indexMaxAge() time.Duration {
+	if c.IndexMaxAge == 0 {
+		return DefaultIndexMaxAge
+	}
+	return c.IndexMaxAge
+}
+
+// RefreshIndex reloads the series index from the full library listing.
+// Concurrent refreshes share a single call to Sonarr.
+func (c *Client) RefreshIndex(ctx context.Context) error {
+	_, err := c.loadLibrary(ctx)
+	return err
+}
+
+func (c *Client) loadLibrary(ctx context.Context) ([]Series, error) {
+	ch := c.index.group.DoChan("library", func() (any, error) {
+		started := time.Now()
+		library, err := c.GetAllSeries(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if c.IndexMaxAge >= 0 {
+			c.index.replace(library, started)
+		}
+		return library, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]Series), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Library returns every series in the Sonarr library, from the index when it
+// is fresh.
+func (c *Client) Library(ctx context.Context) ([]Series, error) {
+	if c.IndexMaxAge >= 0 && c.index.fresh(c.indexMaxAge()) {
+		return c.index.all(), nil
+	}
+	library, err := c.loadLibrary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(library), nil
+}
+
+// InvalidateSeries tells the client that a series changed in Sonarr, e.g.
+// because an episode was downloaded, so the next lookup fetches it again.
+func (c *Client) InvalidateSeries(tvdbID int) {
+	c.index.invalidate(tvdbID)
+}
+
+// FindSeriesByTVDB returns the series with the TVDB ID, or nil without an
+// error when it is not in the library.
+func (c *Client) FindSeriesByTVDB(ctx context.Context, tvdbID int) (*Series, error) {
+	if c.IndexMaxAge >= 0 {
+		if series, ok := c.index.lookup(tvdbID, c.indexMaxAge()); ok {
+			return series, nil
+		}
+	}
+
+	started := time.Now()
+	var list []Series
+	if err := c.get(ctx, fmt.Sprintf("/api/v3/series?tvdbId=%d", tvdbID), &list); err != nil {
+		return nil, err
+	}
+	var found *Series
+	filtered := true
+	for i := range list {
+		if list[i].TvdbID == tvdbID {
+			found = &list[i]
+		} else {
+			filtered = false
+		}
+	}
+	if c.IndexMaxAge >= 0 {
+		if filtered {
+			c.index.set(tvdbID, found, started)
+		} else {
+			// Sonarr versions without the tvdbId filter return the whole
+			// library, which is as good as a refresh.
+			c.index.replace(list, started)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,217 @@
+package sonarr_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bpouw/gopherseerr/fake"
+	"github.com/bpouw/gopherseerr/httpclient"
+	"github.com/bpouw/gopherseerr/sonarr"
+)
+
+var (
+	breakingBad = sonarr.Series{TmdbID: 1396, TvdbID: 81189, Title: "Breaking Bad", Seasons: []sonarr.SonarrSeason{
+		{SeasonNumber: 1, Monitored: true}, {SeasonNumber: 2},
+	}}
+	gameOfThrones = sonarr.Series{TmdbID: 1399, TvdbID: 121361, Title: "Game of Thrones"}
+)
+
+// requestLog records the series calls a Sonarr simulator serves, with their
+// query, e.g. "/api/v3/series?tvdbId=81189".
+type requestLog struct {
+	mu   sync.Mutex
+	uris []string
+}
+
+// take returns the calls logged since the last take.
+func (l *requestLog) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	uris := l.uris
+	l.uris = nil
+	return uris
+}
+
+// indexedClient returns a client with the series index for a simulator
+// serving f. With ignoreFilter the simulator answers series calls with the
+// whole library, like Sonarr versions without the tvdbId filter.
+func indexedClient(t *testing.T, f *fake.Sonarr, maxAge time.Duration, ignoreFilter bool) (*sonarr.Client, *requestLog) {
+	t.Helper()
+	log := &requestLog{}
+	h := f.Handler()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/api/v3/series" {
+			log.mu.Lock()
+			log.uris = append(log.uris, r.URL.RequestURI())
+			log.mu.Unlock()
+			if ignoreFilter {
+				r.URL.RawQuery = ""
+			}
+		}
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	c, err := sonarr.NewClient(srv.URL, "", httpclient.Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.IndexMaxAge = maxAge
+	return c, log
+}
+
+func findTVDB(t *testing.T, c *sonarr.Client, tvdbID int) *sonarr.Series {
+	t.Helper()
+	series, err := c.FindSeriesByTVDB(context.Background(), tvdbID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return series
+}
+
+func TestIndexRefresh(t *testing.T) {
+	f := fake.NewSonarr()
+	f.Put(breakingBad)
+	c, log := indexedClient(t, f, 0, false)
+
+	if err := c.RefreshIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := log.take(); !slices.Equal(got, []string{"/api/v3/series"}) {
+		t.Fatalf("refresh called %v, want the full library", got)
+	}
+
+	series, err := c.FindSeriesByTMDB(context.Background(), breakingBad.TmdbID)
+	if err != nil || series == nil || series.Title != "Breaking Bad" {
+		t.Fatalf("FindSeriesByTMDB = %+v, %v", series, err)
+	}
+	if s := findTVDB(t, c, gameOfThrones.TvdbID); s != nil {
+		t.Errorf("found %+v, want nothing for a series not in the library", s)
+	}
+	library, err := c.Library(context.Background())
+	if err != nil || len(library) != 1 {
+		t.Fatalf("Library = %+v, %v", library, err)
+	}
+	if got := log.take(); len(got) != 0 {
+		t.Errorf("lookups on a fresh index called %v", got)
+	}
+	if slices.Contains(f.Calls(), "GET /api/v3/series/lookup") {
+		t.Error("the TMDB ID of a library series was looked up")
+	}
+
+	// Changing what a caller got must not change the index.
+	series.Seasons[1].Monitored = true
+	if s := findTVDB(t, c, breakingBad.TvdbID); s.Seasons[1].Monitored {
+		t.Error("the index shares seasons with its callers")
+	}
+}
+
+func TestIndexInvalidation(t *testing.T) {
+	f := fake.NewSonarr()
+	f.Put(breakingBad)
+	c, log := indexedClient(t, f, 0, false)
+	if err := c.RefreshIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	log.take()
+
+	// Added in Sonarr behind the client's back: the index does not know
+	// until told.
+	f.Put(gameOfThrones)
+	if s := findTVDB(t, c, gameOfThrones.TvdbID); s != nil {
+		t.Fatalf("found %+v before the series was invalidated", s)
+	}
+	c.InvalidateSeries(gameOfThrones.TvdbID)
+	if s := findTVDB(t, c, gameOfThrones.TvdbID); s == nil || s.Title != "Game of Thrones" {
+		t.Fatalf("found %+v after invalidating, want Game of Thrones", s)
+	}
+	findTVDB(t, c, gameOfThrones.TvdbID)
+	if got := log.take(); !slices.Equal(got, []string{"/api/v3/series?tvdbId=121361"}) {
+		t.Errorf("calls = %v, want one fetch of the invalidated series", got)
+	}
+
+	// Updates through the client invalidate the series themselves.
+	series := findTVDB(t, c, breakingBad.TvdbID)
+	series.Seasons[1].Monitored = true
+	if err := c.UpdateSeries(context.Background(), series); err != nil {
+		t.Fatal(err)
+	}
+	if s := findTVDB(t, c, breakingBad.TvdbID); !s.Seasons[1].Monitored {
+		t.Error("lookup after the update returned the old seasons")
+	}
+	if got := log.take(); !slices.Equal(got, []string{"/api/v3/series?tvdbId=81189"}) {
+		t.Errorf("calls = %v, want one fetch of the updated series", got)
+	}
+
+	// A changed series makes the index stale as a whole until reloaded.
+	c.InvalidateSeries(breakingBad.TvdbID)
+	if _, err := c.Library(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Library(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := log.take(); !slices.Equal(got, []string{"/api/v3/series"}) {
+		t.Errorf("calls = %v, want one library reload", got)
+	}
+}
+
+func TestIndexMaxAge(t *testing.T) {
+	f := fake.NewSonarr()
+	f.Put(breakingBad)
+
+	c, log := indexedClient(t, f, 20*time.Millisecond, false)
+	if err := c.RefreshIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	log.take()
+	time.Sleep(30 * time.Millisecond)
+	findTVDB(t, c, breakingBad.TvdbID)
+	if _, err := c.Library(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := log.take(); !slices.Equal(got, []string{"/api/v3/series?tvdbId=81189", "/api/v3/series"}) {
+		t.Errorf("calls on an expired index = %v, want a series fetch and a library reload", got)
+	}
+
+	c, log = indexedClient(t, f, -1, false)
+	if err := c.RefreshIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	findTVDB(t, c, breakingBad.TvdbID)
+	findTVDB(t, c, breakingBad.TvdbID)
+	if _, err := c.Library(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/api/v3/series", "/api/v3/series?tvdbId=81189", "/api/v3/series?tvdbId=81189", "/api/v3/series"}
+	if got := log.take(); !slices.Equal(got, want) {
+		t.Errorf("calls with the index off = %v, want %v", got, want)
+	}
+}
+
+func TestIndexFallsBackToFullLibrary(t *testing.T) {
+	f := fake.NewSonarr()
+	f.Put(breakingBad)
+	f.Put(gameOfThrones)
+	c, log := indexedClient(t, f, 0, true)
+
+	if s := findTVDB(t, c, gameOfThrones.TvdbID); s == nil || s.Title != "Game of Thrones" {
+		t.Fatalf("found %+v in the unfiltered listing, want Game of Thrones", s)
+	}
+	if s := findTVDB(t, c, breakingBad.TvdbID); s == nil || s.Title != "Breaking Bad" {
+		t.Fatalf("found %+v, want Breaking Bad from the index", s)
+	}
+	if s := findTVDB(t, c, 1); s != nil {
+		t.Errorf("found %+v for a series not in the library", s)
+	}
+	if _, err := c.Library(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := log.take(); !slices.Equal(got, []string{"/api/v3/series?tvdbId=121361"}) {
+		t.Errorf("calls = %v, want the unfiltered listing to load the index", got)
+	}
+}
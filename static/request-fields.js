@@ -0,0 +1,35 @@
+// Every request form on the page, including the episode forms that are
+// built on the fly, picks up the values of the server, quality profile and
+// root folder pickers on submit. Pickers of servers that are not chosen are
+// hidden and skipped.
+document.addEventListener('submit', function (event) {
+    const form = event.target;
+    if (!form.action.endsWith('/request')) {
+        return;
+    }
+    form.querySelectorAll('input[data-request-field]').forEach(function (input) {
+        input.remove();
+    });
+    document.querySelectorAll('select[data-request-field]').forEach(function (select) {
+        if (select.closest('[hidden]')) {
+            return;
+        }
+        const input = document.createElement('input');
+        input.type = 'hidden';
+        input.name = select.dataset.requestField;
+        input.dataset.requestField = input.name;
+        input.value = select.value;
+        form.appendChild(input);
+    });
+});
+
+// Choosing a server shows its pickers.
+document.addEventListener('change', function (event) {
+    const select = event.target;
+    if (select.dataset.requestField !== 'server') {
+        return;
+    }
+    document.querySelectorAll('[data-server]').forEach(function (section) {
+        section.hidden = section.dataset.server !== select.value;
+    });
+});
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/bpouw/gopherseerr/radarr"
+	"github.com/bpouw/gopherseerr/sonarr"
+	"github.com/bpouw/gopherseerr/store"
+	"github.com/bpouw/gopherseerr/tmdb"
+)
+
+// Library status badges shown on search results and show pages. An empty
+// status means it could not be determined, e.g. because Radarr was down.
+type LibraryStatus string
+
+const (
+	LibraryAvailable LibraryStatus = "Available"
+	LibraryPartial   LibraryStatus = "Partially available"
+	LibraryRequested LibraryStatus = "Requested"
+	LibraryMissing   LibraryStatus = "Missing"
+)
+
+// bestStatus returns the status that is furthest along, so a title counts as
+// available if any Radarr or Sonarr has it.
+func bestStatus(a, b LibraryStatus) LibraryStatus {
+	rank := func(s LibraryStatus) int {
+		switch s {
+		case LibraryAvailable:
+			return 4
+		case LibraryPartial:
+			return 3
+		case LibraryRequested:
+			return 2
+		case LibraryMissing:
+			return 1
+		}
+		return 0
+	}
+	if rank(b) > rank(a) {
+		return b
+	}
+	return a
+}
+
+// Class returns the CSS class suffix used by the badge templates.
+func (s LibraryStatus) Class() string {
+	return strings.ToLower(strings.ReplaceAll(string(s), " ", "-"))
+}
+
+type searchResult struct {
+	tmdb.MediaBasic
+	Status LibraryStatus `json:"status,omitempty"`
+}
+
+type showPage struct {
+	*tmdb.TVShowDetails
+	Status  LibraryStatus   `json:"status,omitempty"`
+	Seasons []seasonStatus  `json:"seasons"`
+	Servers []string        `json:"servers,omitempty"` // the Sonarrs that have the show, when there are several
+	Options *requestOptions `json:"-"`
+}
+
+type seasonStatus struct {
+	tmdb.Season
+	Status             LibraryStatus `json:"status,omitempty"`
+	EpisodeFileCount   int           `json:"episode_file_count,omitempty"`
+	SonarrEpisodeCount int           `json:"sonarr_episode_count,omitempty"`
+}
+
+type moviePage struct {
+	*tmdb.MovieDetails
+	Status      LibraryStatus   `json:"status,omitempty"`
+	Radarr      []radarrEntry   `json:"-"`                 // the Radarrs that have the movie
+	Servers     []string        `json:"servers,omitempty"` // their names, when there are several
+	Requestable bool            `json:"-"`                 // some Radarr does not have the movie
+	Options     *requestOptions `json:"-"`
+}
+
+// radarrEntry is a movie in the library of one Radarr.
+type radarrEntry struct {
+	Server string // empty while there is only one Radarr
+	*radarr.Movie
+}
+
+// movieStatus derives a badge from a Radarr library entry. movie is nil when
+// Radarr does not know the title.
+func (s *server) movieStatus(movie *radarr.Movie, tmdbID int) LibraryStatus {
+	switch {
+	case movie == nil:
+		if s.hasOpenRequest("movie", tmdbID) {
+			return LibraryRequested
+		}
+		return LibraryMissing
+	case movie.HasFile:
+		return LibraryAvailable
+	case movie.Monitored:
+		return LibraryRequested
+	default:
+		return LibraryMissing
+	}
+}
+
+// seriesStatus derives a badge from a Sonarr library entry. series is nil when
+// Sonarr does not know the title.
+func (s *server) seriesStatus(series *sonarr.Series, tmdbID int) LibraryStatus {
+	if series == nil {
+		if s.hasOpenRequest("tv", tmdbID) {
+			return LibraryRequested
+		}
+		return LibraryMissing
+	}
+	return statisticsStatus(series.Statistics, series.Monitored)
+}
+
+func statisticsStatus(stats *sonarr.Statistics, monitored bool) LibraryStatus {
+	if stats == nil || stats.EpisodeFileCount == 0 {
+		if monitored {
+			return LibraryRequested
+		}
+		return LibraryMissing
+	}
+	if stats.EpisodeFileCount >= stats.TotalEpisodeCount {
+		return LibraryAvailable
+	}
+	return LibraryPartial
+}
+
+// hasOpenRequest reports whether the ledger holds a request for the title
+// that has not reached Radarr/Sonarr yet.
+func (s *server) hasOpenRequest(mediaType string, tmdbID int) bool {
+	for _, req := range s.requests.List(store.Filter{MediaType: mediaType, TMDBID: tmdbID}) {
+		if req.Status == store.StatusPending || req.Status == store.StatusApproved {
+			return true
+		}
+	}
+	return false
+}
+
+// enrichSearchResults attaches library badges to TMDB search results. Each
+// title is looked up on its own, in parallel: Radarr filters by TMDB ID and
+// the Sonarr client answers from its series index, so a search never lists
+// a whole library.
+func (s *server) enrichSearchResults(ctx context.Context, results []tmdb.MediaBasic) []searchResult {
+	out := make([]searchResult, len(results))
+	var wg sync.WaitGroup
+	for i, item := range results {
+		out[i].MediaBasic = item
+		if item.MediaType != "movie" && item.MediaType != "tv" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out[i].Status = s.libraryStatus(ctx, item)
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+// libraryStatus returns the best status of a movie or show across every
+// Radarr or Sonarr.
+func (s *server) libraryStatus(ctx context.Context, item tmdb.MediaBasic) LibraryStatus {
+	var status LibraryStatus
+	if item.MediaType == "movie" {
+		for _, inst := range s.radarrs {
+			movie, err := inst.GetMovieByTMDB(ctx, item.ID)
+			if err != nil {
+				log.Printf("Failed to fetch %s status for movie %d: %v", inst.label, item.ID, err)
+				continue
+			}
+			status = bestStatus(status, s.movieStatus(movie, item.ID))
+		}
+		return status
+	}
+	for _, inst := range s.sonarrs {
+		// The series is found by its TVDB ID, which Sonarr v3 libraries
+		// have even though they leave out the TMDB ID.
+		series, err := inst.FindSeriesByTMDB(ctx, item.ID)
+		if errors.Is(err, sonarr.ErrNotFound) {
+			series, err = nil, nil // unknown to Sonarr's series lookup
+		}
+		if err != nil {
+			log.Printf("Failed to fetch %s status for show %d: %v", inst.label, item.ID, err)
+			continue
+		}
+		status = bestStatus(status, s.seriesStatus(series, item.ID))
+	}
+	return status
+}
+
+// enrichMovieDetails attaches a library badge to a TMDB movie, the best
+// status across every Radarr.
+func (s *server) enrichMovieDetails(ctx context.Context, details *tmdb.MovieDetails) moviePage {
+	page := moviePage{MovieDetails: details}
+	for _, inst := range s.radarrs {
+		movie, err := inst.GetMovieByTMDB(ctx, details.ID)
+		if err != nil {
+			log.Printf("Failed to fetch %s status for movie: %v", inst.label, err)
+			page.Requestable = true
+			continue
+		}
+		page.Status = bestStatus(page.Status, s.movieStatus(movie, details.ID))
+		if movie == nil {
+			page.Requestable = true
+			continue
+		}
+		name := serverName(&inst.instance, len(s.radarrs))
+		page.Radarr = append(page.Radarr, radarrEntry{Server: name, Movie: movie})
+		if name != "" {
+			page.Servers = append(page.Servers, name)
+		}
+	}
+	return page
+}
+
+// enrichShowDetails attaches series and per-season badges to a TMDB show,
+// taking the best status across every Sonarr.
+func (s *server) enrichShowDetails(ctx context.Context, details *tmdb.TVShowDetails) showPage {
+	page := showPage{TVShowDetails: details, Seasons: make([]seasonStatus, len(details.Seasons))}
+	for i, season := range details.Seasons {
+		page.Seasons[i].Season = season
+	}
+
+	for _, inst := range s.sonarrs {
+		series, err := inst.FindSeriesByTMDB(ctx, details.ID)
+		if err != nil {
+			log.Printf("Failed to fetch %s status for show: %v", inst.label, err)
+			continue
+		}
+		page.Status = bestStatus(page.Status, s.seriesStatus(series, details.ID))
+		if series != nil {
+			if name := serverName(&inst.instance, len(s.sonarrs)); name != "" {
+				page.Servers = append(page.Servers, name)
+			}
+		}
+
+		for i := range page.Seasons {
+			status := LibraryMissing
+			var stats *sonarr.Statistics
+			if series != nil {
+				for _, season := range series.Seasons {
+					if season.SeasonNumber == page.Seasons[i].SeasonNumber {
+						status, stats = statisticsStatus(season.Statistics, season.Monitored), season.Statistics
+					}
+				}
+			}
+			if best := bestStatus(page.Seasons[i].Status, status); best != page.Seasons[i].Status {
+				page.Seasons[i].Status = best
+				page.Seasons[i].EpisodeFileCount, page.Seasons[i].SonarrEpisodeCount = 0, 0
+				if stats != nil {
+					page.Seasons[i].EpisodeFileCount = stats.EpisodeFileCount
+					page.Seasons[i].SonarrEpisodeCount = stats.TotalEpisodeCount
+				}
+			}
+		}
+	}
+	return page
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/bpouw/gopherseerr/fake"
+	"github.com/bpouw/gopherseerr/httpclient"
+	"github.com/bpouw/gopherseerr/radarr"
+	"github.com/bpouw/gopherseerr/sonarr"
+	"github.com/bpouw/gopherseerr/store"
+	"github.com/bpouw/gopherseerr/tmdb"
+)
+
+func TestBestStatus(t *testing.T) {
+	order := []LibraryStatus{"", LibraryMissing, LibraryRequested, LibraryPartial, LibraryAvailable}
+	for i, a := range order {
+		for j, b := range order {
+			want := order[max(i, j)]
+			if got := bestStatus(a, b); got != want {
+				t.Errorf("bestStatus(%q, %q) = %q, want %q", a, b, got, want)
+			}
+		}
+	}
+}
+
+func TestStatisticsStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		stats     *sonarr.Statistics
+		monitored bool
+		want      LibraryStatus
+	}{
+		{"no statistics", nil, false, LibraryMissing},
+		{"no statistics, monitored", nil, true, LibraryRequested},
+		{"no files", &sonarr.Statistics{TotalEpisodeCount: 10}, false, LibraryMissing},
+		{"no files, monitored", &sonarr.Statistics{EpisodeCount: 10, TotalEpisodeCount: 10}, true, LibraryRequested},
+		{"some files", &sonarr.Statistics{EpisodeFileCount: 3, EpisodeCount: 10, TotalEpisodeCount: 10}, true, LibraryPartial},
+		// Complete means every episode Sonarr knows of, not only the
+		// monitored ones.
+		{"monitored episodes complete", &sonarr.Statistics{EpisodeFileCount: 5, EpisodeCount: 5, TotalEpisodeCount: 10}, true, LibraryPartial},
+		{"every file", &sonarr.Statistics{EpisodeFileCount: 10, EpisodeCount: 10, TotalEpisodeCount: 10}, true, LibraryAvailable},
+		{"every file, unmonitored", &sonarr.Statistics{EpisodeFileCount: 10, TotalEpisodeCount: 10}, false, LibraryAvailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := statisticsStatus(tt.stats, tt.monitored); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnrichSearchResults(t *testing.T) {
+	ts := newTestServer(t)
+	ts.radarr.Put(radarr.Movie{TmdbID: 603, Title: "The Matrix", HasFile: true})
+	ts.radarr.Put(radarr.Movie{TmdbID: 604, Title: "The Matrix Reloaded", Monitored: true})
+
+	// A Sonarr v3 library, whose series have no TMDB ID, behind the real
+	// client: the show is found through its TVDB ID.
+	f := fake.NewSonarr()
+	show := sonarr.Series{TmdbID: 1396, TvdbID: 81189, Title: "Breaking Bad", Monitored: true,
+		Statistics: &sonarr.Statistics{EpisodeFileCount: 4, EpisodeCount: 4, TotalEpisodeCount: 4}}
+	f.Catalog(show, nil)
+	show.TmdbID = 0
+	f.Put(show)
+	f.Catalog(sonarr.Series{TmdbID: 1399, TvdbID: 121361, Title: "Game of Thrones"}, nil)
+	srv := fake.NewSonarrServer(f)
+	t.Cleanup(srv.Close)
+	client, err := sonarr.NewClient(srv.URL, "", httpclient.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.sonarrs[0].SeriesManager = client
+	ts.addRequests(t, store.Request{TMDBID: 605, MediaType: "movie", Status: store.StatusPending})
+
+	results := []tmdb.MediaBasic{
+		{ID: 603, MediaType: "movie"},
+		{ID: 604, MediaType: "movie"},
+		{ID: 605, MediaType: "movie"},
+		{ID: 606, MediaType: "movie"},
+		{ID: 1396, MediaType: "tv"},
+		{ID: 1399, MediaType: "tv"},
+		{ID: 1400, MediaType: "tv"},
+		{ID: 17419, MediaType: "person"},
+	}
+	var got []LibraryStatus
+	for _, r := range ts.enrichSearchResults(t.Context(), results) {
+		got = append(got, r.Status)
+	}
+	want := []LibraryStatus{
+		LibraryAvailable, LibraryRequested, LibraryRequested, LibraryMissing,
+		LibraryAvailable, LibraryMissing, LibraryMissing, "",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("statuses = %q\nwant %q", got, want)
+	}
+	for _, call := range ts.radarr.Calls() {
+		if call != "GetMovieByTMDB" {
+			t.Errorf("search called Radarr's %s, want only per-title lookups", call)
+		}
+	}
+}
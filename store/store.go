@@ -0,0 +1,290 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request outcomes recorded in the ledger.
+const (
+	StatusPending     = "pending"
+	StatusApproved    = "approved"
+	StatusDenied      = "denied"
+	StatusSubmitted   = "submitted"
+	StatusFailed      = "failed"
+	StatusDownloading = "downloading"
+	StatusAvailable   = "available"
+)
+
+var (
+	ErrNotFound      = errors.New("request not found")
+	ErrStatusChanged = errors.New("request status has changed")
+)
+
+// Request is a single entry in the request ledger.
+type Request struct {
+	ID               int        `json:"id"`
+	TMDBID           int        `json:"tmdb_id"`
+	MediaType        string     `json:"media_type"`             // "movie" or "tv"
+	RequestType      string     `json:"request_type,omitempty"` // "full_show", "season", "episode" or "batch"
+	SeasonNumber     int        `json:"season_number,omitempty"`
+	EpisodeNumber    int        `json:"episode_number,omitempty"`
+	Seasons          []int      `json:"seasons,omitempty"`  // batch requests only
+	Episodes         []Episode  `json:"episodes,omitempty"` // batch requests only
+	User             string     `json:"user,omitempty"`
+	QualityProfileID int        `json:"quality_profile_id,omitempty"` // 0 uses the configured default
+	RootFolder       string     `json:"root_folder,omitempty"`        // empty uses the root folder rules
+	Availability     string     `json:"minimum_availability,omitempty"`
+	Server           string     `json:"server,omitempty"` // the Radarr/Sonarr it went to, empty for the default one
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	Status           string     `json:"status"`
+	Error            string     `json:"error,omitempty"`
+	ReviewedBy       string     `json:"reviewed_by,omitempty"`
+	ReviewedAt       *time.Time `json:"reviewed_at,omitempty"`
+	DenyReason       string     `json:"deny_reason,omitempty"`
+}
+
+// Episode identifies an episode of a batch request.
+type Episode struct {
+	Season  int `json:"season"`
+	Episode int `json:"episode"`
+}
+
+func (e Episode) String() string {
+	return fmt.Sprintf("S%02dE%02d", e.Season, e.Episode)
+}
+
+// Selection describes the seasons and episodes of a batch request, with runs
+// of consecutive episodes collapsed, e.g. "Seasons 1, 2, S03E04–E09". The
+// episodes are expected in order.
+func (r Request) Selection() string {
+	var parts []string
+	switch len(r.Seasons) {
+	case 0:
+	case 1:
+		parts = append(parts, fmt.Sprintf("Season %d", r.Seasons[0]))
+	default:
+		seasons := make([]string, len(r.Seasons))
+		for i, n := range r.Seasons {
+			seasons[i] = strconv.Itoa(n)
+		}
+		parts = append(parts, "Seasons "+strings.Join(seasons, ", "))
+	}
+	for i := 0; i < len(r.Episodes); {
+		first, j := r.Episodes[i], i
+		for j+1 < len(r.Episodes) && r.Episodes[j+1].Season == first.Season && r.Episodes[j+1].Episode == r.Episodes[j].Episode+1 {
+			j++
+		}
+		if j > i {
+			parts = append(parts, fmt.Sprintf("%s–E%02d", first, r.Episodes[j].Episode))
+		} else {
+			parts = append(parts, first.String())
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Filter narrows the result of List. Zero values match everything.
+type Filter struct {
+	TMDBID      int
+	MediaType   string
+	RequestType string
+	Status      string
+	User        string
+	Limit       int
+}
+
+func (f Filter) matches(r *Request) bool {
+	if f.TMDBID != 0 && r.TMDBID != f.TMDBID {
+		return false
+	}
+	if f.MediaType != "" && r.MediaType != f.MediaType {
+		return false
+	}
+	if f.RequestType != "" && r.RequestType != f.RequestType {
+		return false
+	}
+	if f.Status != "" && r.Status != f.Status {
+		return false
+	}
+	if f.User != "" && !strings.EqualFold(r.User, f.User) {
+		return false
+	}
+	return true
+}
+
+// Store is a file-backed request ledger. The whole ledger is kept in memory
+// and rewritten atomically to disk on every change.
+type Store struct {
+	path     string
+	mu       sync.RWMutex
+	nextID   int
+	requests []*Request
+}
+
+type fileFormat struct {
+	NextID   int        `json:"next_id"`
+	Requests []*Request `json:"requests"`
+}
+
+// Open loads the ledger at path, creating an empty one if the file does not
+// exist yet.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, nextID: 1}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request store: %w", err)
+	}
+	if len(data) == 0 {
+		return s, nil
+	}
+
+	var ff fileFormat
+	if err := json.Unmarshal(data, &ff); err != nil {
+		return nil, fmt.Errorf("failed to parse request store %s: %w", path, err)
+	}
+	s.requests = ff.Requests
+	s.nextID = ff.NextID
+	for _, r := range s.requests {
+		if r.ID >= s.nextID {
+			s.nextID = r.ID + 1
+		}
+	}
+	return s, nil
+}
+
+// Add assigns an ID and timestamps to r, appends it to the ledger and returns
+// the stored copy.
+func (s *Store) Add(r Request) (Request, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	r.ID = s.nextID
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = now
+	}
+	r.UpdatedAt = now
+
+	s.nextID++
+	s.requests = append(s.requests, &r)
+	if err := s.save(); err != nil {
+		s.requests = s.requests[:len(s.requests)-1]
+		s.nextID--
+		return Request{}, err
+	}
+	return r, nil
+}
+
+// Update applies fn to the request with the given ID and persists the result.
+func (s *Store) Update(id int, fn func(r *Request)) (Request, error) {
+	return s.update(id, "", fn)
+}
+
+// Transition is like Update but only applies fn if the request currently has
+// status from. It returns ErrStatusChanged otherwise, which lets callers such
+// as the approval queue act on a request exactly once.
+func (s *Store) Transition(id int, from string, fn func(r *Request)) (Request, error) {
+	return s.update(id, from, fn)
+}
+
+func (s *Store) update(id int, from string, fn func(r *Request)) (Request, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.requests {
+		if r.ID != id {
+			continue
+		}
+		if from != "" && r.Status != from {
+			return *r, ErrStatusChanged
+		}
+		before := *r
+		fn(r)
+		r.ID = id
+		r.UpdatedAt = time.Now().UTC()
+		if err := s.save(); err != nil {
+			*r = before
+			return Request{}, err
+		}
+		return *r, nil
+	}
+	return Request{}, ErrNotFound
+}
+
+// Get returns the request with the given ID.
+func (s *Store) Get(id int) (Request, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, r := range s.requests {
+		if r.ID == id {
+			return *r, nil
+		}
+	}
+	return Request{}, ErrNotFound
+}
+
+// List returns the requests matching f, newest first.
+func (s *Store) List(f Filter) []Request {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := []Request{}
+	for _, r := range s.requests {
+		if f.matches(r) {
+			out = append(out, *r)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].ID > out[j].ID
+	})
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[:f.Limit]
+	}
+	return out
+}
+
+// save writes the ledger to a temporary file and renames it over the
+// original so a crash never leaves a half-written store behind. Callers must
+// hold s.mu.
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(fileFormat{NextID: s.nextID, Requests: s.requests}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode request store: %w", err)
+	}
+
+	dir := filepath.Dir(s.path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write request store: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write request store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write request store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write request store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func openTemp(t *testing.T) (*Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "requests.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+func mustAdd(t *testing.T, s *Store, r Request) Request {
+	t.Helper()
+	added, err := s.Add(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return added
+}
+
+func ids(requests []Request) []int {
+	out := []int{}
+	for _, r := range requests {
+		out = append(out, r.ID)
+	}
+	return out
+}
+
+func TestAddAndUpdate(t *testing.T) {
+	s, _ := openTemp(t)
+	first := mustAdd(t, s, Request{TMDBID: 603, MediaType: "movie", Status: StatusSubmitted})
+	second := mustAdd(t, s, Request{ID: 99, TMDBID: 1396, MediaType: "tv", Status: StatusPending})
+	if first.ID != 1 || second.ID != 2 {
+		t.Errorf("IDs = %d, %d, want 1, 2", first.ID, second.ID)
+	}
+	if first.CreatedAt.IsZero() || !first.UpdatedAt.Equal(first.CreatedAt) {
+		t.Errorf("timestamps = %v, %v", first.CreatedAt, first.UpdatedAt)
+	}
+
+	updated, err := s.Update(first.ID, func(r *Request) {
+		r.Status = StatusFailed
+		r.Error = "Radarr is down"
+		r.ID = 42 // ignored
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.ID != first.ID || updated.Status != StatusFailed || updated.UpdatedAt.Before(first.UpdatedAt) {
+		t.Errorf("updated = %+v", updated)
+	}
+	if got, _ := s.Get(first.ID); !reflect.DeepEqual(got, updated) {
+		t.Errorf("Get = %+v, want %+v", got, updated)
+	}
+	if _, err := s.Update(7, func(r *Request) {}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update of an unknown request = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Get(7); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of an unknown request = %v, want ErrNotFound", err)
+	}
+}
+
+func TestTransition(t *testing.T) {
+	s, _ := openTemp(t)
+	r := mustAdd(t, s, Request{TMDBID: 603, MediaType: "movie", Status: StatusPending})
+	approve := func(r *Request) { r.Status = StatusApproved; r.ReviewedBy = "admin" }
+
+	if got, err := s.Transition(r.ID, StatusPending, approve); err != nil || got.Status != StatusApproved {
+		t.Fatalf("first approval = %+v, %v", got, err)
+	}
+	called := false
+	got, err := s.Transition(r.ID, StatusPending, func(r *Request) { called = true })
+	if !errors.Is(err, ErrStatusChanged) || called {
+		t.Errorf("second approval = %v (fn called: %v), want ErrStatusChanged", err, called)
+	}
+	if got.Status != StatusApproved {
+		t.Errorf("the failed transition returned status %q, want the current one", got.Status)
+	}
+	if _, err := s.Transition(7, StatusPending, approve); !errors.Is(err, ErrNotFound) {
+		t.Errorf("transition of an unknown request = %v, want ErrNotFound", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	s, _ := openTemp(t)
+	mustAdd(t, s, Request{TMDBID: 603, MediaType: "movie", User: "alice", Status: StatusAvailable})
+	mustAdd(t, s, Request{TMDBID: 1396, MediaType: "tv", RequestType: "season", User: "bob", Status: StatusSubmitted})
+	mustAdd(t, s, Request{TMDBID: 1396, MediaType: "tv", RequestType: "episode", User: "Alice", Status: StatusPending})
+	mustAdd(t, s, Request{TMDBID: 604, MediaType: "movie", Status: StatusSubmitted})
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []int
+	}{
+		{"everything, newest first", Filter{}, []int{4, 3, 2, 1}},
+		{"media type", Filter{MediaType: "movie"}, []int{4, 1}},
+		{"TMDB ID", Filter{TMDBID: 1396}, []int{3, 2}},
+		{"request type", Filter{RequestType: "episode"}, []int{3}},
+		{"status", Filter{Status: StatusSubmitted}, []int{4, 2}},
+		{"user ignores case", Filter{User: "ALICE"}, []int{3, 1}},
+		{"combined", Filter{MediaType: "tv", User: "alice"}, []int{3}},
+		{"limit", Filter{Limit: 2}, []int{4, 3}},
+		{"limit after filtering", Filter{MediaType: "movie", Limit: 1}, []int{4}},
+		{"no match", Filter{Status: StatusDenied}, []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(s.List(tt.filter)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("List = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenExisting(t *testing.T) {
+	s, path := openTemp(t)
+	mustAdd(t, s, Request{TMDBID: 603, MediaType: "movie", Status: StatusSubmitted})
+	mustAdd(t, s, Request{TMDBID: 1396, MediaType: "tv", Status: StatusPending, Episodes: []Episode{{1, 2}}})
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reopened.List(Filter{}), s.List(Filter{})) {
+		t.Errorf("reopened ledger = %+v\nwant %+v", reopened.List(Filter{}), s.List(Filter{}))
+	}
+	if r := mustAdd(t, reopened, Request{TMDBID: 604}); r.ID != 3 {
+		t.Errorf("next ID after reopening = %d, want 3", r.ID)
+	}
+}
+
+func TestOpenRecoversNextID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.json")
+	// An older or hand-edited file whose next_id lags behind the requests.
+	data := `{"next_id": 2, "requests": [{"id": 3, "status": "submitted"}, {"id": 7, "status": "pending"}]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := mustAdd(t, s, Request{TMDBID: 603}); r.ID != 8 {
+		t.Errorf("ID = %d, want 8", r.ID)
+	}
+}
+
+func TestOpenEmptyAndInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.json")
+	os.WriteFile(empty, nil, 0o644)
+	if s, err := Open(empty); err != nil || len(s.List(Filter{})) != 0 {
+		t.Errorf("Open(empty file) = %v", err)
+	}
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`{"requests": [`), 0o644)
+	if _, err := Open(invalid); err == nil {
+		t.Error("Open(invalid file) returned no error")
+	}
+}
+
+func TestSaveIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ledger", "requests.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the directory the temporary file cannot be created: nothing
+	// is recorded and no ID is used up.
+	if _, err := s.Add(Request{TMDBID: 603}); err == nil {
+		t.Fatal("Add succeeded without a directory to save to")
+	}
+	if got := s.List(Filter{}); len(got) != 0 {
+		t.Errorf("the failed Add left %+v behind", got)
+	}
+
+	if err := os.Mkdir(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r := mustAdd(t, s, Request{TMDBID: 603, Status: StatusSubmitted})
+	if r.ID != 1 {
+		t.Errorf("ID = %d, want 1", r.ID)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "requests.json" {
+		t.Errorf("directory holds %v, want only requests.json", entries)
+	}
+
+	// A failed update leaves the request as it was.
+	os.RemoveAll(filepath.Dir(path))
+	if _, err := s.Update(r.ID, func(r *Request) { r.Status = StatusAvailable }); err == nil {
+		t.Fatal("Update succeeded without a directory to save to")
+	}
+	if got, _ := s.Get(r.ID); got.Status != StatusSubmitted {
+		t.Errorf("status after the failed update = %q, want %q", got.Status, StatusSubmitted)
+	}
+}
+
+func TestSelection(t *testing.T) {
+	tests := []struct {
+		r    Request
+		want string
+	}{
+		{Request{Seasons: []int{2}}, "Season 2"},
+		{Request{Seasons: []int{1, 2}, Episodes: []Episode{{3, 4}, {3, 5}, {3, 6}, {4, 1}}}, "Seasons 1, 2, S03E04–E06, S04E01"},
+		{Request{Episodes: []Episode{{1, 1}, {1, 3}}}, "S01E01, S01E03"},
+	}
+	for _, tt := range tests {
+		if got := tt.r.Selection(); got != tt.want {
+			t.Errorf("Selection() = %q, want %q", got, tt.want)
+		}
+	}
+}
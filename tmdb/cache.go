@@ -0,0 +1,171 @@
+package tmdb
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Endpoint groups the TMDB calls that share a cache TTL.
+type Endpoint string
+
+const (
+	EndpointSearch Endpoint = "search"
+	EndpointMovie  Endpoint = "movie"
+	EndpointTV     Endpoint = "tv"
+	EndpointSeason Endpoint = "season"
+	EndpointFind   Endpoint = "find"
+)
+
+// DefaultTTLs are used for endpoints missing from CacheOptions.TTL. Search
+// results change as new titles come out; details rarely change.
+var DefaultTTLs = map[Endpoint]time.Duration{
+	EndpointSearch: 10 * time.Minute,
+	EndpointMovie:  6 * time.Hour,
+	EndpointTV:     6 * time.Hour,
+	EndpointSeason: 6 * time.Hour,
+	EndpointFind:   24 * time.Hour,
+}
+
+const DefaultCacheEntries = 1000
+
+type CacheOptions struct {
+	MaxEntries int                        // defaults to DefaultCacheEntries
+	TTL        map[Endpoint]time.Duration // a negative TTL disables caching for the endpoint
+}
+
+// Cache keeps successful TMDB responses in memory. The least recently used
+// entry is dropped once MaxEntries is reached, and concurrent lookups of the
+// same URL share a single call to TMDB.
+type Cache struct {
+	maxEntries int
+	ttl        map[Endpoint]time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is most recently used
+	group   singleflight.Group
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type cacheEntry struct {
+	key     string
+	body    []byte
+	expires time.Time
+}
+
+// CacheStats is a snapshot of the cache counters.
+type CacheStats struct {
+	Entries int   `json:"entries"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+func NewCache(opts CacheOptions) *Cache {
+	c := &Cache{
+		maxEntries: opts.MaxEntries,
+		ttl:        make(map[Endpoint]time.Duration),
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+	if c.maxEntries <= 0 {
+		c.maxEntries = DefaultCacheEntries
+	}
+	for e, ttl := range DefaultTTLs {
+		c.ttl[e] = ttl
+	}
+	for e, ttl := range opts.TTL {
+		c.ttl[e] = ttl
+	}
+	return c
+}
+
+// get returns the cached body for key, or calls fetch to load it.
+func (c *Cache) get(ctx context.Context, endpoint Endpoint, key string, fetch func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	ttl := c.ttl[endpoint]
+	if ttl <= 0 {
+		return fetch(ctx)
+	}
+	if body, ok := c.lookup(key); ok {
+		c.hits.Add(1)
+		return body, nil
+	}
+	c.misses.Add(1)
+
+	// The shared call must not fail for everyone when the caller that
+	// started it goes away, so it runs without that caller's cancellation.
+	ch := c.group.DoChan(key, func() (any, error) {
+		body, err := fetch(context.WithoutCancel(ctx))
+		if err == nil {
+			c.store(key, body, ttl)
+		}
+		return body, err
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]byte), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Cache) lookup(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return entry.body, true
+}
+
+func (c *Cache) store(key string, body []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &cacheEntry{key: key, body: body, expires: time.Now().Add(ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Flush drops every entry and returns how many there were. The counters are
+// kept.
+func (c *Cache) Flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.lru.Len()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	return n
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	n := c.lru.Len()
+	c.mu.Unlock()
+	return CacheStats{Entries: n, Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
@@ -0,0 +1,185 @@
+package tmdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingFetch returns a fetch that answers with its key and counts how
+// often it was called.
+func countingFetch(key string, calls *atomic.Int32) func(ctx context.Context) ([]byte, error) {
+	return func(ctx context.Context) ([]byte, error) {
+		calls.Add(1)
+		return []byte(key), nil
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(CacheOptions{MaxEntries: 2})
+	var calls atomic.Int32
+	get := func(key string) {
+		if body, err := c.get(ctx, EndpointMovie, key, countingFetch(key, &calls)); err != nil || string(body) != key {
+			t.Fatalf("get(%q) = %q, %v", key, body, err)
+		}
+	}
+
+	get("a")
+	get("b")
+	get("a") // a is now more recently used than b
+	get("c") // evicts b
+	if calls.Load() != 3 {
+		t.Fatalf("fetched %d times, want 3", calls.Load())
+	}
+	get("a")
+	get("c")
+	if calls.Load() != 3 {
+		t.Errorf("a or c was evicted instead of b")
+	}
+	get("b")
+	if calls.Load() != 4 {
+		t.Errorf("b was not evicted")
+	}
+	if n := c.Stats().Entries; n != 2 {
+		t.Errorf("cache holds %d entries, want 2", n)
+	}
+}
+
+func TestCacheTTLPerEndpoint(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(CacheOptions{TTL: map[Endpoint]time.Duration{
+		EndpointSearch: 20 * time.Millisecond,
+		EndpointFind:   -1,
+	}})
+	tests := []struct {
+		endpoint  Endpoint
+		wantCalls int32 // after a call, a second one right away and a third after the short TTL
+	}{
+		{EndpointSearch, 2}, // expired
+		{EndpointMovie, 1},  // default TTL of hours
+		{EndpointFind, 3},   // not cached
+	}
+	counts := make([]atomic.Int32, len(tests))
+	for round := range 3 {
+		if round == 2 {
+			time.Sleep(30 * time.Millisecond)
+		}
+		for i, tt := range tests {
+			key := string(tt.endpoint)
+			c.get(ctx, tt.endpoint, key, countingFetch(key, &counts[i]))
+		}
+	}
+	for i, tt := range tests {
+		if got := counts[i].Load(); got != tt.wantCalls {
+			t.Errorf("%s fetched %d times, want %d", tt.endpoint, got, tt.wantCalls)
+		}
+	}
+}
+
+func TestCacheSharesConcurrentFetches(t *testing.T) {
+	c := NewCache(CacheOptions{})
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) ([]byte, error) {
+		calls.Add(1)
+		<-release
+		return []byte("body"), nil
+	}
+
+	const callers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, err := c.get(context.Background(), EndpointTV, "key", fetch)
+			if err == nil && string(body) != "body" {
+				err = fmt.Errorf("got %q", body)
+			}
+			errs <- err
+		}()
+	}
+	for c.Stats().Misses < callers {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("fetched %d times for %d concurrent callers, want once", calls.Load(), callers)
+	}
+}
+
+func TestCacheSharedFetchOutlivesCanceledCaller(t *testing.T) {
+	c := NewCache(CacheOptions{})
+	release := make(chan struct{})
+	var calls atomic.Int32
+	fetch := func(ctx context.Context) ([]byte, error) {
+		calls.Add(1)
+		<-release
+		return []byte("body"), ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := c.get(ctx, EndpointTV, "key", fetch)
+		first <- err
+	}()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan []byte)
+	go func() {
+		body, _ := c.get(context.Background(), EndpointTV, "key", fetch)
+		second <- body
+	}()
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("first caller got %v, want the cancellation", err)
+	}
+	close(release)
+	if body := <-second; string(body) != "body" {
+		t.Errorf("second caller got %q, want the shared response", body)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("fetched %d times, want once", calls.Load())
+	}
+}
+
+func TestCacheCounters(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(CacheOptions{})
+	var calls atomic.Int32
+	failing := func(ctx context.Context) ([]byte, error) { return nil, errors.New("TMDB is down") }
+
+	c.get(ctx, EndpointMovie, "a", countingFetch("a", &calls)) // miss
+	c.get(ctx, EndpointMovie, "a", countingFetch("a", &calls)) // hit
+	c.get(ctx, EndpointMovie, "a", countingFetch("a", &calls)) // hit
+	c.get(ctx, EndpointMovie, "b", failing)                    // miss, not stored
+	c.get(ctx, EndpointMovie, "b", countingFetch("b", &calls)) // miss
+	want := CacheStats{Entries: 2, Hits: 2, Misses: 3}
+	if got := c.Stats(); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+
+	if n := c.Flush(); n != 2 {
+		t.Errorf("Flush dropped %d entries, want 2", n)
+	}
+	c.get(ctx, EndpointMovie, "a", countingFetch("a", &calls))
+	want = CacheStats{Entries: 1, Hits: 2, Misses: 4}
+	if got := c.Stats(); got != want {
+		t.Errorf("stats after a flush = %+v, want %+v", got, want)
+	}
+}
@@ -1,13 +1,19 @@
 package tmdb
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+
+	"github.com/bpouw/gopherseerr/httpclient"
 )
 
-const baseURL = "https://api.themoviedb.org/3"
+// DefaultBaseURL is the TMDB API the client talks to unless BaseURL is set.
+const DefaultBaseURL = "https://api.themoviedb.org/3"
 
 type SearchResult struct {
 	Page         int          `json:"page"`
@@ -28,12 +34,139 @@ type MediaBasic struct {
 }
 
 type TVShowDetails struct {
-	ID           int      `json:"id"`
-	Name         string   `json:"name"`
-	Overview     string   `json:"overview"`
-	PosterPath   string   `json:"poster_path"`
-	FirstAirDate string   `json:"first_air_date"`
-	Seasons      []Season `json:"seasons"`
+	ID               int      `json:"id"`
+	Name             string   `json:"name"`
+	Overview         string   `json:"overview"`
+	PosterPath       string   `json:"poster_path"`
+	FirstAirDate     string   `json:"first_air_date"`
+	OriginalLanguage string   `json:"original_language"`
+	Genres           []Genre  `json:"genres"`
+	Seasons          []Season `json:"seasons"`
+}
+
+type MovieDetails struct {
+	ID               int             `json:"id"`
+	Title            string          `json:"title"`
+	Tagline          string          `json:"tagline"`
+	Overview         string          `json:"overview"`
+	PosterPath       string          `json:"poster_path"`
+	ReleaseDate      string          `json:"release_date"`
+	Runtime          int             `json:"runtime"` // minutes
+	OriginalLanguage string          `json:"original_language"`
+	Genres           []Genre         `json:"genres"`
+	Collection       *Collection     `json:"belongs_to_collection"`
+	Credits          Credits         `json:"credits"`
+	Videos           VideoList       `json:"videos"`
+	ReleaseDates     ReleaseDateList `json:"release_dates"`
+}
+
+type Collection struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	PosterPath string `json:"poster_path"`
+}
+
+type Credits struct {
+	Cast []CastMember `json:"cast"`
+}
+
+type CastMember struct {
+	Name        string `json:"name"`
+	Character   string `json:"character"`
+	ProfilePath string `json:"profile_path"`
+	Order       int    `json:"order"`
+}
+
+type VideoList struct {
+	Results []Video `json:"results"`
+}
+
+type Video struct {
+	Name     string `json:"name"`
+	Key      string `json:"key"`
+	Site     string `json:"site"` // e.g. "YouTube"
+	Type     string `json:"type"` // e.g. "Trailer", "Teaser"
+	Official bool   `json:"official"`
+}
+
+type ReleaseDateList struct {
+	Results []CountryReleaseDates `json:"results"`
+}
+
+type CountryReleaseDates struct {
+	Country      string        `json:"iso_3166_1"`
+	ReleaseDates []ReleaseDate `json:"release_dates"`
+}
+
+type ReleaseDate struct {
+	Certification string `json:"certification"`
+	ReleaseDate   string `json:"release_date"`
+	Type          int    `json:"type"`
+}
+
+// Release types as used by TMDB.
+var releaseTypeNames = map[int]string{
+	1: "Premiere",
+	2: "Theatrical (limited)",
+	3: "Theatrical",
+	4: "Digital",
+	5: "Physical",
+	6: "TV",
+}
+
+// TypeName returns a readable name for the release type.
+func (r ReleaseDate) TypeName() string {
+	if name, ok := releaseTypeNames[r.Type]; ok {
+		return name
+	}
+	return "Other"
+}
+
+// Date returns the release date without the time part.
+func (r ReleaseDate) Date() string {
+	if len(r.ReleaseDate) >= 10 {
+		return r.ReleaseDate[:10]
+	}
+	return r.ReleaseDate
+}
+
+// TopCast returns the first n billed cast members.
+func (m *MovieDetails) TopCast(n int) []CastMember {
+	if len(m.Credits.Cast) < n {
+		return m.Credits.Cast
+	}
+	return m.Credits.Cast[:n]
+}
+
+// Trailers returns the YouTube trailers, official ones first.
+func (m *MovieDetails) Trailers() []Video {
+	var official, other []Video
+	for _, v := range m.Videos.Results {
+		if v.Site != "YouTube" || v.Type != "Trailer" {
+			continue
+		}
+		if v.Official {
+			official = append(official, v)
+		} else {
+			other = append(other, v)
+		}
+	}
+	return append(official, other...)
+}
+
+// ReleaseDatesFor returns the release dates for a country, e.g. "US".
+func (m *MovieDetails) ReleaseDatesFor(country string) []ReleaseDate {
+	for _, c := range m.ReleaseDates.Results {
+		if c.Country == country {
+			return c.ReleaseDates
+		}
+	}
+	return nil
+}
+
+type Genre struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
 }
 
 type Season struct {
@@ -56,26 +189,29 @@ type TMDbEpisode struct {
 }
 
 type Client struct {
-	APIKey string
-}
-
-func NewClient(apiKey string) *Client {
-	return &Client{APIKey: apiKey}
+	BaseURL    string // DefaultBaseURL when empty
+	APIKey     string
+	HTTPClient *http.Client
+	Cache      *Cache // successful responses are kept here when set
 }
 
-func (c *Client) Search(query string) ([]MediaBasic, error) {
-	endpoint := fmt.Sprintf("%s/search/multi", baseURL)
-	params := url.Values{"api_key": {c.APIKey}, "query": {query}, "include_adult": {"false"}}
-	fullURL := fmt.Sprintf("%s?%s", endpoint, params.Encode())
-
-	resp, err := http.Get(fullURL)
+// NewClient returns a TMDB client. opts configures timeouts, TLS and proxying
+// of every call.
+func NewClient(apiKey string, opts httpclient.Options) (*Client, error) {
+	if opts.Service == "" {
+		opts.Service = "TMDB"
+	}
+	hc, err := httpclient.New(opts)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	return &Client{APIKey: apiKey, HTTPClient: hc}, nil
+}
 
+func (c *Client) Search(ctx context.Context, query string) ([]MediaBasic, error) {
+	params := url.Values{"query": {query}, "include_adult": {"false"}}
 	var result SearchResult
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := c.getJSON(ctx, EndpointSearch, "/search/multi", params, "search", &result); err != nil {
 		return nil, err
 	}
 
@@ -88,47 +224,120 @@ func (c *Client) Search(query string) ([]MediaBasic, error) {
 	return filtered, nil
 }
 
-func (c *Client) GetTVShowDetails(tvID int) (*TVShowDetails, error) {
-	endpoint := fmt.Sprintf("%s/tv/%d", baseURL, tvID)
-	params := url.Values{"api_key": {c.APIKey}}
-	fullURL := fmt.Sprintf("%s?%s", endpoint, params.Encode())
-
-	resp, err := http.Get(fullURL)
-	if err != nil {
+func (c *Client) GetTVShowDetails(ctx context.Context, tvID int) (*TVShowDetails, error) {
+	var details TVShowDetails
+	if err := c.getJSON(ctx, EndpointTV, fmt.Sprintf("/tv/%d", tvID), nil, "show details", &details); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	return &details, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("TMDB API returned non-200 status: %d", resp.StatusCode)
+// GetMovieDetails fetches the details of a single movie, including its cast,
+// videos and release dates.
+func (c *Client) GetMovieDetails(ctx context.Context, movieID int) (*MovieDetails, error) {
+	params := url.Values{"append_to_response": {"credits,videos,release_dates"}}
+	var details MovieDetails
+	if err := c.getJSON(ctx, EndpointMovie, fmt.Sprintf("/movie/%d", movieID), params, "movie details", &details); err != nil {
+		return nil, err
 	}
+	return &details, nil
+}
 
-	var details TVShowDetails
-	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
+// GetSeasonDetails fetches episode information for a specific season.
+func (c *Client) GetSeasonDetails(ctx context.Context, tvID int, seasonNumber int) (*SeasonDetails, error) {
+	var details SeasonDetails
+	path := fmt.Sprintf("/tv/%d/season/%d", tvID, seasonNumber)
+	if err := c.getJSON(ctx, EndpointSeason, path, nil, "season details", &details); err != nil {
 		return nil, err
 	}
 	return &details, nil
 }
 
-// GetSeasonDetails fetches episode information for a specific season.
-func (c *Client) GetSeasonDetails(tvID int, seasonNumber int) (*SeasonDetails, error) {
-	endpoint := fmt.Sprintf("%s/tv/%d/season/%d", baseURL, tvID, seasonNumber)
-	params := url.Values{"api_key": {c.APIKey}}
-	fullURL := fmt.Sprintf("%s?%s", endpoint, params.Encode())
+type findResult struct {
+	TVResults []MediaBasic `json:"tv_results"`
+}
 
-	resp, err := http.Get(fullURL)
+// FindTVByTVDB returns the TMDB ID of the show with the given TVDB ID, or 0
+// if TMDB does not know it.
+func (c *Client) FindTVByTVDB(ctx context.Context, tvdbID int) (int, error) {
+	params := url.Values{"external_source": {"tvdb_id"}}
+	var result findResult
+	if err := c.getJSON(ctx, EndpointFind, fmt.Sprintf("/find/%d", tvdbID), params, "find", &result); err != nil {
+		return 0, err
+	}
+	if len(result.TVResults) == 0 {
+		return 0, nil
+	}
+	return result.TVResults[0].ID, nil
+}
+
+// getJSON fetches path with params and decodes the response into out, going
+// through the cache when the client has one. The API key is left out of the
+// cache key.
+func (c *Client) getJSON(ctx context.Context, endpoint Endpoint, path string, params url.Values, what string, out any) error {
+	key := path + "?" + params.Encode()
+	fetch := func(ctx context.Context) ([]byte, error) {
+		return c.fetch(ctx, path, params, what)
+	}
+
+	var body []byte
+	var err error
+	if c.Cache != nil {
+		body, err = c.Cache.get(ctx, endpoint, key, fetch)
+	} else {
+		body, err = fetch(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, out)
+}
+
+// fetch performs a GET request for path and returns the body of a 200
+// response.
+func (c *Client) fetch(ctx context.Context, path string, params url.Values, what string) ([]byte, error) {
+	query := url.Values{"api_key": {c.APIKey}}
+	for k, v := range params {
+		query[k] = v
+	}
+	base := c.BaseURL
+	if base == "" {
+		base = DefaultBaseURL
+	}
+	fullURL := fmt.Sprintf("%s%s?%s", base, path, query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
 	if err != nil {
 		return nil, err
 	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		// The error quotes the URL, which carries the API key and ends up
+		// on pages and in API responses.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactAPIKey(urlErr.URL)
+		}
+		return nil, err
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("TMDB API returned non-200 status for season details: %d", resp.StatusCode)
+		return nil, fmt.Errorf("TMDB API returned non-200 status for %s: %d", what, resp.StatusCode)
 	}
+	return io.ReadAll(resp.Body)
+}
 
-	var details SeasonDetails
-	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
-		return nil, err
+// redactAPIKey replaces the api_key query parameter of rawURL.
+func redactAPIKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "(TMDB URL)"
 	}
-	return &details, nil
+	q := u.Query()
+	if q.Has("api_key") {
+		q.Set("api_key", "REDACTED")
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
 }
@@ -0,0 +1,164 @@
+package tmdb
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bpouw/gopherseerr/httpclient"
+	"github.com/bpouw/gopherseerr/internal/testutil"
+)
+
+func newTestClient(t *testing.T, baseURL string) *Client {
+	t.Helper()
+	c, err := NewClient("key", httpclient.Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL = baseURL
+	return c
+}
+
+func TestContextAbortsCalls(t *testing.T) {
+	calls := []testutil.Call[*Client]{
+		{Name: "Search", Do: func(ctx context.Context, c *Client) error { _, err := c.Search(ctx, "matrix"); return err }},
+		{Name: "GetMovieDetails", Do: func(ctx context.Context, c *Client) error { _, err := c.GetMovieDetails(ctx, 603); return err }},
+		{Name: "GetTVShowDetails", Do: func(ctx context.Context, c *Client) error { _, err := c.GetTVShowDetails(ctx, 1396); return err }},
+		{Name: "GetSeasonDetails", Do: func(ctx context.Context, c *Client) error { _, err := c.GetSeasonDetails(ctx, 1396, 1); return err }},
+		{Name: "FindTVByTVDB", Do: func(ctx context.Context, c *Client) error { _, err := c.FindTVByTVDB(ctx, 81189); return err }},
+	}
+	testutil.CheckContextAborts(t, newTestClient, calls)
+	t.Run("cached", func(t *testing.T) {
+		testutil.CheckContextAborts(t, func(t *testing.T, baseURL string) *Client {
+			// A cached call keeps going for the other callers waiting on
+			// it; the timeout ends it before the server is closed.
+			c, _ := NewClient("key", httpclient.Options{Timeout: 300 * time.Millisecond, MaxRetries: -1})
+			c.BaseURL = baseURL
+			c.Cache = NewCache(CacheOptions{})
+			return c
+		}, calls)
+	})
+}
+
+func TestRequests(t *testing.T) {
+	srv := testutil.NewRecorder(t, map[string]testutil.Response{
+		"GET /search/multi":     {Body: `{"results": []}`},
+		"GET /movie/603":        {Body: `{}`},
+		"GET /tv/1396":          {Body: `{}`},
+		"GET /tv/1396/season/2": {Body: `{}`},
+		"GET /find/81189":       {Body: `{}`},
+	})
+	c := newTestClient(t, srv.URL)
+	c.Search(t.Context(), "the matrix")
+	c.GetMovieDetails(t.Context(), 603)
+	c.GetTVShowDetails(t.Context(), 1396)
+	c.GetSeasonDetails(t.Context(), 1396, 2)
+	c.FindTVByTVDB(t.Context(), 81189)
+
+	want := []struct {
+		path  string
+		query url.Values
+	}{
+		{"/search/multi", url.Values{"api_key": {"key"}, "query": {"the matrix"}, "include_adult": {"false"}}},
+		{"/movie/603", url.Values{"api_key": {"key"}, "append_to_response": {"credits,videos,release_dates"}}},
+		{"/tv/1396", url.Values{"api_key": {"key"}}},
+		{"/tv/1396/season/2", url.Values{"api_key": {"key"}}},
+		{"/find/81189", url.Values{"api_key": {"key"}, "external_source": {"tvdb_id"}}},
+	}
+	reqs := srv.Requests()
+	if len(reqs) != len(want) {
+		t.Fatalf("got %d requests, want %d", len(reqs), len(want))
+	}
+	for i, w := range want {
+		u, _ := url.Parse(reqs[i].URI)
+		if u.Path != w.path || !reflect.DeepEqual(u.Query(), w.query) {
+			t.Errorf("request %d = %s, want %s?%s", i, reqs[i].URI, w.path, w.query.Encode())
+		}
+	}
+}
+
+func TestSearchKeepsMoviesAndShows(t *testing.T) {
+	srv := testutil.NewRecorder(t, map[string]testutil.Response{
+		"GET /search/multi": {Body: `{"page": 1, "results": [
+			{"id": 603, "title": "The Matrix", "media_type": "movie", "release_date": "1999-03-30"},
+			{"id": 6384, "name": "Keanu Reeves", "media_type": "person"},
+			{"id": 1396, "name": "Breaking Bad", "media_type": "tv", "first_air_date": "2008-01-20"}
+		]}`},
+	})
+	results, err := newTestClient(t, srv.URL).Search(t.Context(), "matrix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MediaBasic{
+		{ID: 603, Title: "The Matrix", MediaType: "movie", ReleaseDate: "1999-03-30"},
+		{ID: 1396, Name: "Breaking Bad", MediaType: "tv", FirstAirDate: "2008-01-20"},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("results = %+v\nwant %+v", results, want)
+	}
+}
+
+func TestDetails(t *testing.T) {
+	srv := testutil.NewRecorder(t, map[string]testutil.Response{
+		"GET /movie/603": {Body: `{"id": 603, "title": "The Matrix", "runtime": 136,
+			"credits": {"cast": [{"name": "Keanu Reeves", "character": "Neo"}]},
+			"videos": {"results": [{"key": "abc", "site": "YouTube", "type": "Trailer"}]}}`},
+		"GET /tv/1396/season/1": {Body: `{"episodes": [{"episode_number": 1, "name": "Pilot"}]}`},
+		"GET /find/81189":       {Body: `{"tv_results": [{"id": 1396}]}`},
+		"GET /find/1":           {Body: `{"tv_results": []}`},
+	})
+	c := newTestClient(t, srv.URL)
+
+	movie, err := c.GetMovieDetails(t.Context(), 603)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.Title != "The Matrix" || movie.Runtime != 136 || len(movie.TopCast(5)) != 1 || len(movie.Trailers()) != 1 {
+		t.Errorf("movie = %+v", movie)
+	}
+	season, err := c.GetSeasonDetails(t.Context(), 1396, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []TMDbEpisode{{EpisodeNumber: 1, Name: "Pilot"}}; !reflect.DeepEqual(season.Episodes, want) {
+		t.Errorf("episodes = %+v", season.Episodes)
+	}
+	if id, err := c.FindTVByTVDB(t.Context(), 81189); id != 1396 || err != nil {
+		t.Errorf("FindTVByTVDB = %d, %v, want 1396", id, err)
+	}
+	if id, err := c.FindTVByTVDB(t.Context(), 1); id != 0 || err != nil {
+		t.Errorf("FindTVByTVDB of an unknown show = %d, %v, want 0", id, err)
+	}
+}
+
+func TestNon200(t *testing.T) {
+	srv := testutil.NewRecorder(t, map[string]testutil.Response{
+		"GET /movie/603": {Status: http.StatusUnauthorized, Body: `{"status_code": 7}`},
+	})
+	c := newTestClient(t, srv.URL)
+	if _, err := c.GetMovieDetails(t.Context(), 603); err == nil || err.Error() != "TMDB API returned non-200 status for movie details: 401" {
+		t.Errorf("got %v", err)
+	}
+	if _, err := c.GetTVShowDetails(t.Context(), 1396); err == nil || !strings.Contains(err.Error(), "show details: 404") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestErrorsHideAPIKey(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	c, _ := NewClient("s3cret-key", httpclient.Options{MaxRetries: -1})
+	c.BaseURL = srv.URL
+	_, err := c.GetMovieDetails(context.Background(), 603)
+	if err == nil {
+		t.Fatal("got no error from a closed server")
+	}
+	if strings.Contains(err.Error(), "s3cret-key") || !strings.Contains(err.Error(), "api_key=REDACTED") {
+		t.Errorf("error = %q, want the API key redacted", err)
+	}
+}
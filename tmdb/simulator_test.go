@@ -0,0 +1,81 @@
+package tmdb_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bpouw/gopherseerr/fake"
+	"github.com/bpouw/gopherseerr/httpclient"
+	"github.com/bpouw/gopherseerr/tmdb"
+)
+
+// simulated returns a client for a simulator serving f with the API key.
+func simulated(t *testing.T, f *fake.TMDB, apiKey string) *tmdb.Client {
+	t.Helper()
+	srv := fake.NewTMDBServer(f)
+	t.Cleanup(srv.Close)
+	c, err := tmdb.NewClient(apiKey, httpclient.Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL = srv.URL
+	return c
+}
+
+func TestSimulator(t *testing.T) {
+	f := fake.NewTMDB()
+	f.APIKey = "key"
+	f.AddMovie(tmdb.MovieDetails{ID: 603, Title: "The Matrix", ReleaseDate: "1999-03-30", Runtime: 136})
+	f.AddShow(tmdb.TVShowDetails{ID: 1396, Name: "Breaking Bad", Seasons: []tmdb.Season{
+		{SeasonNumber: 1, EpisodeCount: 7}, {SeasonNumber: 2, EpisodeCount: 13},
+	}}, 81189, []tmdb.TMDbEpisode{{EpisodeNumber: 1, Name: "Pilot"}})
+	c := simulated(t, f, "key")
+	ctx := t.Context()
+
+	results, err := c.Search(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []tmdb.MediaBasic{{ID: 1396, Name: "Breaking Bad", MediaType: "tv"}}; !reflect.DeepEqual(results, want) {
+		t.Errorf("Search = %+v, want %+v", results, want)
+	}
+
+	movie, err := c.GetMovieDetails(ctx, 603)
+	if err != nil || movie.Title != "The Matrix" || movie.Runtime != 136 {
+		t.Errorf("GetMovieDetails = %+v, %v", movie, err)
+	}
+	show, err := c.GetTVShowDetails(ctx, 1396)
+	if err != nil || show.Name != "Breaking Bad" || len(show.Seasons) != 2 {
+		t.Errorf("GetTVShowDetails = %+v, %v", show, err)
+	}
+	season, err := c.GetSeasonDetails(ctx, 1396, 1)
+	if err != nil || !reflect.DeepEqual(season.Episodes, []tmdb.TMDbEpisode{{EpisodeNumber: 1, Name: "Pilot"}}) {
+		t.Errorf("season 1 = %+v, %v", season, err)
+	}
+	if season, err := c.GetSeasonDetails(ctx, 1396, 2); err != nil || len(season.Episodes) != 13 {
+		t.Errorf("season 2 = %+v, %v, want 13 numbered episodes", season, err)
+	}
+	if id, err := c.FindTVByTVDB(ctx, 81189); id != 1396 || err != nil {
+		t.Errorf("FindTVByTVDB = %d, %v, want 1396", id, err)
+	}
+	if id, err := c.FindTVByTVDB(ctx, 121361); id != 0 || err != nil {
+		t.Errorf("FindTVByTVDB of an unknown show = %d, %v, want 0", id, err)
+	}
+}
+
+func TestSimulatorErrors(t *testing.T) {
+	f := fake.NewTMDB()
+	f.APIKey = "key"
+
+	// The simulator fails the way TMDB does and the client reports it the
+	// same way as the fake does when used directly.
+	_, err := simulated(t, f, "key").GetMovieDetails(t.Context(), 603)
+	_, direct := f.GetMovieDetails(t.Context(), 603)
+	if err == nil || err.Error() != direct.Error() {
+		t.Errorf("unknown movie: got %v, want %v", err, direct)
+	}
+	_, err = simulated(t, f, "wrong").Search(t.Context(), "matrix")
+	if want := "TMDB API returned non-200 status for search: 401"; err == nil || err.Error() != want {
+		t.Errorf("wrong key: got %v, want %s", err, want)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bpouw/gopherseerr/tmdb"
+)
+
+// TMDBCacheConfig configures the in-memory cache of TMDB responses.
+type TMDBCacheConfig struct {
+	Disabled   bool              `json:"disabled"`
+	MaxEntries int               `json:"max_entries"` // defaults to 1000
+	TTL        map[string]string `json:"ttl"`         // per endpoint, e.g. {"search": "5m"}; "0" disables caching it
+}
+
+// newCache returns the cache described by c, or nil when it is disabled.
+func (c TMDBCacheConfig) newCache() (*tmdb.Cache, error) {
+	if c.Disabled {
+		return nil, nil
+	}
+	opts := tmdb.CacheOptions{MaxEntries: c.MaxEntries, TTL: make(map[tmdb.Endpoint]time.Duration)}
+	for name, value := range c.TTL {
+		endpoint := tmdb.Endpoint(name)
+		if _, ok := tmdb.DefaultTTLs[endpoint]; !ok {
+			return nil, fmt.Errorf("unknown endpoint %q in ttl", name)
+		}
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl for %s %q: %w", name, value, err)
+		}
+		if ttl == 0 {
+			ttl = -1
+		}
+		opts.TTL[endpoint] = ttl
+	}
+	return tmdb.NewCache(opts), nil
+}
+
+// keepTMDBCache makes the TMDB client of s use cache, the one of the running
+// config, so a reload does not throw away what it holds.
+func (s *server) keepTMDBCache(cache *tmdb.Cache) {
+	if c, ok := s.tmdb.(*tmdb.Client); ok {
+		c.Cache = cache
+		s.tmdbCache = cache
+	}
+}
+
+// handleTMDBCacheStats reports the cache size and hit/miss counters.
+func (s *server) handleTMDBCacheStats(w http.ResponseWriter, r *http.Request) {
+	if s.tmdbCache == nil {
+		writeJSONError(w, http.StatusNotFound, "the TMDB cache is disabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.tmdbCache.Stats())
+}
+
+// handleTMDBCacheFlush empties the cache, e.g. after fixing metadata on TMDB.
+func (s *server) handleTMDBCacheFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "the cache must be flushed with a POST")
+		return
+	}
+	if s.tmdbCache == nil {
+		writeJSONError(w, http.StatusNotFound, "the TMDB cache is disabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Flushed int `json:"flushed"`
+	}{s.tmdbCache.Flush()})
+}
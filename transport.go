@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bpouw/gopherseerr/httpclient"
+	"github.com/bpouw/gopherseerr/radarr"
+	"github.com/bpouw/gopherseerr/sonarr"
+	"github.com/bpouw/gopherseerr/tmdb"
+)
+
+// HTTPConfig is the JSON form of httpclient.Options. The "http" section
+// applies to every service; the per-service sections override single fields.
+type HTTPConfig struct {
+	Timeout               string `json:"timeout"`                 // e.g. "30s"
+	DialTimeout           string `json:"dial_timeout"`            // e.g. "10s"
+	ResponseHeaderTimeout string `json:"response_header_timeout"` // e.g. "15s"
+	UserAgent             string `json:"user_agent"`
+	CAFile                string `json:"ca_file"`
+	InsecureSkipVerify    *bool  `json:"insecure_skip_verify"` // nil leaves the "http" setting, false turns it off
+	Proxy                 string `json:"proxy"`
+
+	MaxRetries       int    `json:"max_retries"`       // -1 disables retries
+	RetryDelay       string `json:"retry_delay"`       // e.g. "500ms"
+	MaxRetryDelay    string `json:"max_retry_delay"`   // e.g. "10s"
+	BreakerThreshold int    `json:"breaker_threshold"` // -1 disables the circuit breaker
+	BreakerCooldown  string `json:"breaker_cooldown"`  // e.g. "30s"
+}
+
+// merge returns c with the fields set in override replaced.
+func (c HTTPConfig) merge(override HTTPConfig) HTTPConfig {
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&c.Timeout, override.Timeout)
+	set(&c.DialTimeout, override.DialTimeout)
+	set(&c.ResponseHeaderTimeout, override.ResponseHeaderTimeout)
+	set(&c.UserAgent, override.UserAgent)
+	set(&c.CAFile, override.CAFile)
+	set(&c.Proxy, override.Proxy)
+	set(&c.RetryDelay, override.RetryDelay)
+	set(&c.MaxRetryDelay, override.MaxRetryDelay)
+	set(&c.BreakerCooldown, override.BreakerCooldown)
+	if override.MaxRetries != 0 {
+		c.MaxRetries = override.MaxRetries
+	}
+	if override.BreakerThreshold != 0 {
+		c.BreakerThreshold = override.BreakerThreshold
+	}
+	if override.InsecureSkipVerify != nil {
+		c.InsecureSkipVerify = override.InsecureSkipVerify
+	}
+	return c
+}
+
+func (c HTTPConfig) options() (httpclient.Options, error) {
+	opts := httpclient.Options{
+		UserAgent:          c.UserAgent,
+		CAFile:             c.CAFile,
+		InsecureSkipVerify: c.InsecureSkipVerify != nil && *c.InsecureSkipVerify,
+		Proxy:              c.Proxy,
+		MaxRetries:         c.MaxRetries,
+		BreakerThreshold:   c.BreakerThreshold,
+	}
+	durations := []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"timeout", c.Timeout, &opts.Timeout},
+		{"dial_timeout", c.DialTimeout, &opts.DialTimeout},
+		{"response_header_timeout", c.ResponseHeaderTimeout, &opts.ResponseHeaderTimeout},
+		{"retry_delay", c.RetryDelay, &opts.RetryDelay},
+		{"max_retry_delay", c.MaxRetryDelay, &opts.MaxRetryDelay},
+		{"breaker_cooldown", c.BreakerCooldown, &opts.BreakerCooldown},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s %q: %w", d.name, d.value, err)
+		}
+		*d.dst = v
+	}
+	return opts, nil
+}
+
+// httpOptions returns the client options for one service, overriding the
+// "http" section with each of the given sections in turn.
+func (c *Config) httpOptions(overrides ...HTTPConfig) (httpclient.Options, error) {
+	merged := c.HTTP
+	for _, o := range overrides {
+		merged = merged.merge(o)
+	}
+	return merged.options()
+}
+
+// setupClients creates the TMDB, Radarr and Sonarr clients for s.config.
+func setupClients(s *server) error {
+	c := &s.config
+	if _, err := c.HTTP.options(); err != nil {
+		return fmt.Errorf("http: %w", err)
+	}
+	opts, err := c.httpOptions(c.TMDBHTTP)
+	if err != nil {
+		return fmt.Errorf("tmdb_http: %w", err)
+	}
+	tmdbClient, err := tmdb.NewClient(c.TMDBApiKey, opts)
+	if err != nil {
+		return fmt.Errorf("tmdb_http: %w", err)
+	}
+	if tmdbClient.Cache, err = c.TMDBCache.newCache(); err != nil {
+		return fmt.Errorf("tmdb_cache: %w", err)
+	}
+	s.tmdb, s.tmdbCache = tmdbClient, tmdbClient.Cache
+
+	indexMaxAge, err := parseIndexMaxAge(c.SonarrIndexMaxAge)
+	if err != nil {
+		return err
+	}
+	s.radarrs, s.sonarrs = nil, nil
+	for i, ic := range c.radarrInstances() {
+		key := serverKey("radarr", len(c.RadarrServers) > 0, i)
+		inst := newInstance("Radarr", ic)
+		opts, err := c.httpOptions(c.RadarrHTTP, ic.HTTP)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		opts.Service = inst.label
+		client, err := radarr.NewClient(ic.URL, ic.APIKey, opts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		s.radarrs = append(s.radarrs, &radarrInstance{MovieManager: client, instance: inst})
+	}
+	for i, ic := range c.sonarrInstances() {
+		key := serverKey("sonarr", len(c.SonarrServers) > 0, i)
+		inst := newInstance("Sonarr", ic)
+		opts, err := c.httpOptions(c.SonarrHTTP, ic.HTTP)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		opts.Service = inst.label
+		client, err := sonarr.NewClient(ic.URL, ic.APIKey, opts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		client.IndexMaxAge = indexMaxAge
+		s.sonarrs = append(s.sonarrs, &sonarrInstance{SeriesManager: client, instance: inst})
+	}
+	markDefault(s.radarrBases())
+	markDefault(s.sonarrBases())
+	return nil
+}
+
+// serverKey names the settings of a Radarr or Sonarr in errors.
+func serverKey(service string, listed bool, i int) string {
+	if listed {
+		return fmt.Sprintf("%s_servers[%d].http", service, i)
+	}
+	return service + "_http"
+}
+
+// refreshSonarrIndex keeps the series index of every Sonarr loaded,
+// reloading it halfway through its max age so lookups rarely find it
+// expired. It picks up the new clients after a config reload.
+func refreshSonarrIndex(ctx context.Context) {
+	for {
+		var clients []*sonarr.Client
+		var labels []string
+		for _, inst := range current.Load().sonarrs {
+			if client, ok := inst.SeriesManager.(*sonarr.Client); ok {
+				clients = append(clients, client)
+				labels = append(labels, inst.label)
+			}
+		}
+		if len(clients) == 0 {
+			return // only Sonarr itself has an index
+		}
+
+		// Every client shares sonarr_index_max_age.
+		wait := clients[0].IndexMaxAge
+		switch {
+		case wait == 0:
+			wait = sonarr.DefaultIndexMaxAge
+		case wait < 0:
+			wait = time.Minute // only check whether a reload turned it on
+		}
+		if clients[0].IndexMaxAge >= 0 {
+			for i, client := range clients {
+				if err := client.RefreshIndex(ctx); err != nil {
+					log.Printf("Failed to refresh the %s series index: %v", labels[i], err)
+				}
+			}
+		}
+
+		timer := time.NewTimer(wait / 2)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// upstreamError picks the status code and message for a failed call to TMDB,
+// Radarr or Sonarr. While a service's circuit breaker is open the user gets a
+// plain "Sonarr is unreachable" instead of the transport error.
+func upstreamError(err error, status int, prefix string) (int, string) {
+	var unreachable *httpclient.UnreachableError
+	if errors.As(err, &unreachable) {
+		return http.StatusServiceUnavailable, unreachable.Error() + ", please try again later."
+	}
+	return status, prefix + err.Error()
+}
+
+func writeUpstreamError(w http.ResponseWriter, err error, status int, prefix string) {
+	status, message := upstreamError(err, status, prefix)
+	http.Error(w, message, status)
+}
+
+func writeJSONUpstreamError(w http.ResponseWriter, err error, status int, prefix string) {
+	status, message := upstreamError(err, status, prefix)
+	writeJSONError(w, status, message)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHTTPConfigMerge(t *testing.T) {
+	yes, no := true, false
+	global := HTTPConfig{Timeout: "30s", MaxRetries: 3, InsecureSkipVerify: &yes}
+
+	tests := []struct {
+		name     string
+		override HTTPConfig
+		timeout  time.Duration
+		retries  int
+		insecure bool
+	}{
+		{"nothing set", HTTPConfig{}, 30 * time.Second, 3, true},
+		{"fields replaced", HTTPConfig{Timeout: "5s", MaxRetries: -1}, 5 * time.Second, -1, true},
+		{"certificate checks turned back on", HTTPConfig{InsecureSkipVerify: &no}, 30 * time.Second, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := global.merge(tt.override).options()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.Timeout != tt.timeout || opts.MaxRetries != tt.retries || opts.InsecureSkipVerify != tt.insecure {
+				t.Errorf("got timeout %s, retries %d, insecure %v", opts.Timeout, opts.MaxRetries, opts.InsecureSkipVerify)
+			}
+		})
+	}
+
+	// A server's own section is applied after its service's.
+	c := Config{HTTP: HTTPConfig{InsecureSkipVerify: &no}}
+	opts, err := c.httpOptions(HTTPConfig{InsecureSkipVerify: &yes}, HTTPConfig{InsecureSkipVerify: &no})
+	if err != nil || opts.InsecureSkipVerify {
+		t.Errorf("got insecure %v, %v, want the server's false to win", opts.InsecureSkipVerify, err)
+	}
+	if _, err := (HTTPConfig{RetryDelay: "soon"}).options(); err == nil {
+		t.Error("got no error for an invalid retry_delay")
+	}
+}
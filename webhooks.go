@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/bpouw/gopherseerr/notify"
+	"github.com/bpouw/gopherseerr/sonarr"
+	"github.com/bpouw/gopherseerr/store"
+)
+
+// Radarr and Sonarr report grabs and imports to /webhook/radarr and
+// /webhook/sonarr (Settings -> Connect -> Webhook). Matching requests move
+// from submitted to downloading to available. With several servers, each
+// names itself in the server query parameter; without it the call is taken
+// to come from the default one.
+
+type radarrWebhook struct {
+	EventType string `json:"eventType"` // "Grab", "Download", "Test", ...
+	Movie     struct {
+		Title  string `json:"title"`
+		TmdbID int    `json:"tmdbId"`
+	} `json:"movie"`
+}
+
+type sonarrWebhook struct {
+	EventType string `json:"eventType"`
+	Series    struct {
+		ID     int    `json:"id"`
+		Title  string `json:"title"`
+		TvdbID int    `json:"tvdbId"`
+		TmdbID int    `json:"tmdbId"` // only sent by Sonarr v4
+	} `json:"series"`
+	Episodes []struct {
+		SeasonNumber  int `json:"seasonNumber"`
+		EpisodeNumber int `json:"episodeNumber"`
+	} `json:"episodes"`
+}
+
+// webhookAuthorized checks the shared webhook_token, given either as the
+// token query parameter or as the basic auth password.
+func webhookAuthorized(r *http.Request) bool {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		_, token, _ = r.BasicAuth()
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(serverFor(r).config.WebhookToken)) == 1
+}
+
+// decodeWebhook authorizes and decodes a webhook call, writing the error
+// response itself when it returns false.
+func decodeWebhook(w http.ResponseWriter, r *http.Request, payload any) bool {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "webhooks must be POSTed")
+		return false
+	}
+	if serverFor(r).config.WebhookToken == "" {
+		writeJSONError(w, http.StatusForbidden, "webhooks are disabled, set webhook_token in config.json")
+		return false
+	}
+	if !webhookAuthorized(r) {
+		writeJSONError(w, http.StatusUnauthorized, "invalid webhook token")
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid webhook payload: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// webhookInstance returns the index of the server a webhook call comes from,
+// writing the error response itself when it returns false.
+func webhookInstance(w http.ResponseWriter, r *http.Request, service string, list []*instance) (int, bool) {
+	i, err := findInstance(service, list, r.URL.Query().Get("server"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return 0, false
+	}
+	return i, true
+}
+
+type webhookResponse struct {
+	Updated []int `json:"updated"` // IDs of the requests whose status changed
+}
+
+func (s *server) handleRadarrWebhook(w http.ResponseWriter, r *http.Request) {
+	var p radarrWebhook
+	if !decodeWebhook(w, r, &p) {
+		return
+	}
+	i, ok := webhookInstance(w, r, "Radarr", s.radarrBases())
+	if !ok {
+		return
+	}
+	rad := s.radarrs[i]
+
+	var status string
+	switch p.EventType {
+	case "Grab":
+		status = store.StatusDownloading
+	case "Download":
+		status = store.StatusAvailable
+	default:
+		writeJSON(w, http.StatusOK, webhookResponse{})
+		return
+	}
+
+	if p.Movie.TmdbID == 0 {
+		writeJSONError(w, http.StatusBadRequest, "webhook payload has no movie.tmdbId")
+		return
+	}
+
+	updated := []int{}
+	for _, req := range s.trackedRequests(&rad.instance, "movie", p.Movie.TmdbID, status) {
+		if s.markRequest(req, status) {
+			updated = append(updated, req.ID)
+		}
+	}
+	writeJSON(w, http.StatusOK, webhookResponse{Updated: updated})
+}
+
+func (s *server) handleSonarrWebhook(w http.ResponseWriter, r *http.Request) {
+	var p sonarrWebhook
+	if !decodeWebhook(w, r, &p) {
+		return
+	}
+	i, ok := webhookInstance(w, r, "Sonarr", s.sonarrBases())
+	if !ok {
+		return
+	}
+	son := s.sonarrs[i]
+	if p.EventType != "Grab" && p.EventType != "Download" {
+		writeJSON(w, http.StatusOK, webhookResponse{})
+		return
+	}
+	if p.EventType == "Download" {
+		son.InvalidateSeries(p.Series.TvdbID) // its statistics changed
+	}
+
+	tmdbID := p.Series.TmdbID
+	if tmdbID == 0 {
+		var err error
+		tmdbID, err = s.tmdb.FindTVByTVDB(r.Context(), p.Series.TvdbID)
+		if err != nil {
+			writeJSONError(w, http.StatusBadGateway, "failed to look up TVDB ID on TMDB: "+err.Error())
+			return
+		}
+		if tmdbID == 0 {
+			log.Printf("Sonarr webhook for %q: TVDB ID %d is unknown to TMDB", p.Series.Title, p.Series.TvdbID)
+			writeJSON(w, http.StatusOK, webhookResponse{})
+			return
+		}
+	}
+
+	covers := func(req store.Request) bool {
+		for _, ep := range p.Episodes {
+			switch {
+			case req.RequestType == "full_show",
+				req.RequestType == "season" && ep.SeasonNumber == req.SeasonNumber,
+				req.RequestType == "episode" && ep.SeasonNumber == req.SeasonNumber && ep.EpisodeNumber == req.EpisodeNumber,
+				req.RequestType == "batch" && slices.Contains(req.Seasons, ep.SeasonNumber),
+				req.RequestType == "batch" && slices.Contains(req.Episodes, store.Episode{Season: ep.SeasonNumber, Episode: ep.EpisodeNumber}):
+				return true
+			}
+		}
+		return false
+	}
+
+	// A download completes an episode request outright. Season and full show
+	// requests only count as available once Sonarr has every monitored
+	// episode, so fetch the series statistics once when needed. Batch
+	// requests also need a file for each of their episodes.
+	var series *sonarr.Series
+	var episodes []sonarr.Episode
+	loadSeries := func(req store.Request) bool {
+		if series == nil {
+			got, err := son.GetSeries(r.Context(), p.Series.ID)
+			if err != nil {
+				log.Printf("Failed to fetch %q from %s to check request #%d: %v", p.Series.Title, son.label, req.ID, err)
+				return false
+			}
+			series = got
+		}
+		return true
+	}
+	seasonComplete := func(n int) bool {
+		for _, season := range series.Seasons {
+			if season.SeasonNumber == n {
+				return statisticsComplete(season.Statistics)
+			}
+		}
+		return false
+	}
+	episodeComplete := func(req store.Request, ep store.Episode) bool {
+		if episodes == nil {
+			got, err := son.GetEpisodes(r.Context(), p.Series.ID)
+			if err != nil {
+				log.Printf("Failed to fetch the episodes of %q from %s to check request #%d: %v", p.Series.Title, son.label, req.ID, err)
+				return false
+			}
+			episodes = got
+		}
+		return slices.ContainsFunc(episodes, func(e sonarr.Episode) bool {
+			return e.SeasonNumber == ep.Season && e.EpisodeNumber == ep.Episode && e.HasFile
+		})
+	}
+	complete := func(req store.Request) bool {
+		if req.RequestType == "episode" {
+			return true
+		}
+		if !loadSeries(req) {
+			return false
+		}
+		switch req.RequestType {
+		case "full_show":
+			return statisticsComplete(series.Statistics)
+		case "season":
+			return seasonComplete(req.SeasonNumber)
+		case "batch":
+			for _, n := range req.Seasons {
+				if !seasonComplete(n) {
+					return false
+				}
+			}
+			for _, ep := range req.Episodes {
+				if !episodeComplete(req, ep) {
+					return false
+				}
+			}
+			return true
+		}
+		return false
+	}
+
+	updated := []int{}
+	for _, req := range s.trackedRequests(&son.instance, "tv", tmdbID, store.StatusAvailable) {
+		if !covers(req) {
+			continue
+		}
+		status := store.StatusDownloading
+		if p.EventType == "Download" && complete(req) {
+			status = store.StatusAvailable
+		}
+		if req.Status != status && s.markRequest(req, status) {
+			updated = append(updated, req.ID)
+		}
+	}
+	writeJSON(w, http.StatusOK, webhookResponse{Updated: updated})
+}
+
+// statisticsComplete reports whether every monitored episode has a file.
+func statisticsComplete(stats *sonarr.Statistics) bool {
+	return stats != nil && stats.EpisodeCount > 0 && stats.EpisodeFileCount >= stats.EpisodeCount
+}
+
+// trackedRequests returns the requests for a title sent to inst that can
+// still move to status: submitted ones, and downloading ones when status is
+// available.
+func (s *server) trackedRequests(inst *instance, mediaType string, tmdbID int, status string) []store.Request {
+	from := []string{store.StatusSubmitted}
+	if status == store.StatusAvailable {
+		from = append(from, store.StatusDownloading)
+	}
+	var out []store.Request
+	for _, req := range s.requests.List(store.Filter{MediaType: mediaType, TMDBID: tmdbID}) {
+		if slices.Contains(from, req.Status) && inst.owns(req) {
+			out = append(out, req)
+		}
+	}
+	return out
+}
+
+// markRequest moves req to status unless it changed in the meantime, and
+// tells the requester when it became available.
+func (s *server) markRequest(req store.Request, status string) bool {
+	updated, err := s.requests.Transition(req.ID, req.Status, func(r *store.Request) {
+		r.Status = status
+	})
+	if errors.Is(err, store.ErrStatusChanged) {
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to mark request #%d as %s: %v", req.ID, status, err)
+		return false
+	}
+	log.Printf("Request #%d is now %s", req.ID, status)
+	if status == store.StatusAvailable {
+		s.notifyRequest(notify.EventAvailable, updated)
+	}
+	return true
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bpouw/gopherseerr/sonarr"
+	"github.com/bpouw/gopherseerr/store"
+	"github.com/bpouw/gopherseerr/tmdb"
+)
+
+const testWebhookToken = "hook-s3cret"
+
+func postWebhook(target, body string) *http.Request {
+	if strings.Contains(target, "?") {
+		target += "&token=" + testWebhookToken
+	} else {
+		target += "?token=" + testWebhookToken
+	}
+	return postJSON(target, body)
+}
+
+// addRequests records reqs, submitted unless they say otherwise, and returns
+// their IDs.
+func (ts *testServer) addRequests(t *testing.T, reqs ...store.Request) []int {
+	t.Helper()
+	var ids []int
+	for _, req := range reqs {
+		if req.Status == "" {
+			req.Status = store.StatusSubmitted
+		}
+		added, err := ts.requests.Add(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, added.ID)
+	}
+	return ids
+}
+
+// statuses returns the status of each request in ids.
+func (ts *testServer) statuses(t *testing.T, ids []int) []string {
+	t.Helper()
+	var out []string
+	for _, id := range ids {
+		req, err := ts.requests.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, req.Status)
+	}
+	return out
+}
+
+func decodeUpdated(t *testing.T, w *httptest.ResponseRecorder) []int {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var resp webhookResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Updated
+}
+
+func TestWebhookAuthorization(t *testing.T) {
+	basicAuth := postJSON("/webhook/radarr", `{"eventType": "Test"}`)
+	basicAuth.SetBasicAuth("radarr", testWebhookToken)
+	tests := []struct {
+		name       string
+		token      string
+		r          *http.Request
+		wantStatus int
+	}{
+		{"disabled", "", postWebhook("/webhook/radarr", `{"eventType": "Test"}`), http.StatusForbidden},
+		{"no token", testWebhookToken, postJSON("/webhook/radarr", `{"eventType": "Test"}`), http.StatusUnauthorized},
+		{"wrong token", testWebhookToken, postJSON("/webhook/radarr?token=guess", `{"eventType": "Test"}`), http.StatusUnauthorized},
+		{"token parameter", testWebhookToken, postWebhook("/webhook/radarr", `{"eventType": "Test"}`), http.StatusOK},
+		{"basic auth", testWebhookToken, basicAuth, http.StatusOK},
+		{"GET", testWebhookToken, httptest.NewRequest(http.MethodGet, "/webhook/radarr?token="+testWebhookToken, nil), http.StatusMethodNotAllowed},
+		{"invalid payload", testWebhookToken, postWebhook("/webhook/radarr", `{"eventType": `), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.config.WebhookToken = tt.token
+			if w := ts.do((*server).handleRadarrWebhook, tt.r); w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestRadarrWebhook(t *testing.T) {
+	tests := []struct {
+		name        string
+		event       string
+		status      string
+		server      string
+		wantStatus  string
+		wantUpdated bool
+	}{
+		{"grab", "Grab", store.StatusSubmitted, "", store.StatusDownloading, true},
+		{"download", "Download", store.StatusSubmitted, "", store.StatusAvailable, true},
+		{"download after grab", "Download", store.StatusDownloading, "", store.StatusAvailable, true},
+		{"grab after download", "Grab", store.StatusAvailable, "", store.StatusAvailable, false},
+		{"pending request", "Download", store.StatusPending, "", store.StatusPending, false},
+		{"other events", "Rename", store.StatusSubmitted, "", store.StatusSubmitted, false},
+		{"request sent to another server", "Download", store.StatusSubmitted, "4k", store.StatusSubmitted, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.config.WebhookToken = testWebhookToken
+			ids := ts.addRequests(t,
+				store.Request{TMDBID: 603, MediaType: "movie", Status: tt.status, Server: tt.server},
+				store.Request{TMDBID: 604, MediaType: "movie"},
+			)
+
+			w := ts.do((*server).handleRadarrWebhook, postWebhook("/webhook/radarr",
+				`{"eventType": "`+tt.event+`", "movie": {"title": "The Matrix", "tmdbId": 603}}`))
+			updated := decodeUpdated(t, w)
+			if tt.wantUpdated != slices.Equal(updated, ids[:1]) {
+				t.Errorf("updated %v", updated)
+			}
+			want := []string{tt.wantStatus, store.StatusSubmitted}
+			if got := ts.statuses(t, ids); !slices.Equal(got, want) {
+				t.Errorf("statuses = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRadarrWebhookWithoutMovie(t *testing.T) {
+	ts := newTestServer(t)
+	ts.config.WebhookToken = testWebhookToken
+	w := ts.do((*server).handleRadarrWebhook, postWebhook("/webhook/radarr", `{"eventType": "Grab", "movie": {}}`))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// sonarrEvent is a Sonarr webhook call for Breaking Bad as added by
+// addBreakingBad, for the episodes given as season and episode numbers.
+func sonarrEvent(event string, tmdbID int, episodes ...[2]int) string {
+	var eps []string
+	for _, ep := range episodes {
+		b, _ := json.Marshal(map[string]int{"seasonNumber": ep[0], "episodeNumber": ep[1]})
+		eps = append(eps, string(b))
+	}
+	b, _ := json.Marshal(map[string]any{"id": 1, "title": "Breaking Bad", "tvdbId": 81189, "tmdbId": tmdbID})
+	return `{"eventType": "` + event + `", "series": ` + string(b) + `, "episodes": [` + strings.Join(eps, ", ") + `]}`
+}
+
+func TestSonarrWebhookCovers(t *testing.T) {
+	requests := []store.Request{
+		{RequestType: "full_show"},
+		{RequestType: "season", SeasonNumber: 2},
+		{RequestType: "season", SeasonNumber: 1},
+		{RequestType: "episode", SeasonNumber: 2, EpisodeNumber: 1},
+		{RequestType: "episode", SeasonNumber: 2, EpisodeNumber: 2},
+		{RequestType: "batch", Seasons: []int{2}},
+		{RequestType: "batch", Seasons: []int{1}, Episodes: []store.Episode{{Season: 2, Episode: 1}}},
+		{RequestType: "batch", Seasons: []int{1}, Episodes: []store.Episode{{Season: 1, Episode: 2}}},
+	}
+	ts := newTestServer(t)
+	ts.config.WebhookToken = testWebhookToken
+	ts.addBreakingBad(true)
+	for i := range requests {
+		requests[i].TMDBID, requests[i].MediaType = 1396, "tv"
+	}
+	ids := ts.addRequests(t, requests...)
+
+	w := ts.do((*server).handleSonarrWebhook, postWebhook("/webhook/sonarr", sonarrEvent("Grab", 1396, [2]int{2, 1})))
+	updated := decodeUpdated(t, w)
+	slices.Sort(updated)
+	grabbed := []int{ids[0], ids[1], ids[3], ids[5], ids[6]}
+	if !slices.Equal(updated, grabbed) {
+		t.Errorf("updated %v, want %v", updated, grabbed)
+	}
+	want := []string{"downloading", "downloading", "submitted", "downloading", "submitted", "downloading", "downloading", "submitted"}
+	if got := ts.statuses(t, ids); !slices.Equal(got, want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+}
+
+func TestSonarrWebhookComplete(t *testing.T) {
+	tests := []struct {
+		name           string
+		req            store.Request
+		seriesComplete bool
+		season2        sonarr.Statistics
+		s01e02HasFile  bool
+		want           string
+	}{
+		{
+			name: "episode",
+			req:  store.Request{RequestType: "episode", SeasonNumber: 2, EpisodeNumber: 1},
+			want: store.StatusAvailable,
+		},
+		{
+			name:    "season missing episodes",
+			req:     store.Request{RequestType: "season", SeasonNumber: 2},
+			season2: sonarr.Statistics{EpisodeFileCount: 1, EpisodeCount: 2},
+			want:    store.StatusDownloading,
+		},
+		{
+			name:    "season complete",
+			req:     store.Request{RequestType: "season", SeasonNumber: 2},
+			season2: sonarr.Statistics{EpisodeFileCount: 2, EpisodeCount: 2},
+			want:    store.StatusAvailable,
+		},
+		{
+			name:    "season without monitored episodes",
+			req:     store.Request{RequestType: "season", SeasonNumber: 2},
+			season2: sonarr.Statistics{},
+			want:    store.StatusDownloading,
+		},
+		{
+			name:    "full show missing episodes",
+			req:     store.Request{RequestType: "full_show"},
+			season2: sonarr.Statistics{EpisodeFileCount: 2, EpisodeCount: 2},
+			want:    store.StatusDownloading,
+		},
+		{
+			name:           "full show complete",
+			req:            store.Request{RequestType: "full_show"},
+			seriesComplete: true,
+			want:           store.StatusAvailable,
+		},
+		{
+			name:    "batch missing an episode",
+			req:     store.Request{RequestType: "batch", Seasons: []int{2}, Episodes: []store.Episode{{Season: 1, Episode: 2}}},
+			season2: sonarr.Statistics{EpisodeFileCount: 2, EpisodeCount: 2},
+			want:    store.StatusDownloading,
+		},
+		{
+			name:          "batch complete",
+			req:           store.Request{RequestType: "batch", Seasons: []int{2}, Episodes: []store.Episode{{Season: 1, Episode: 2}}},
+			season2:       sonarr.Statistics{EpisodeFileCount: 2, EpisodeCount: 2},
+			s01e02HasFile: true,
+			want:          store.StatusAvailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.config.WebhookToken = testWebhookToken
+			ts.addBreakingBad(true)
+			series := ts.sonarr.Series()[0]
+			series.Statistics = &sonarr.Statistics{EpisodeFileCount: 2, EpisodeCount: 4}
+			if tt.seriesComplete {
+				series.Statistics.EpisodeFileCount = 4
+			}
+			series.Seasons[2].Statistics = &tt.season2
+			if err := ts.sonarr.UpdateSeries(context.Background(), &series); err != nil {
+				t.Fatal(err)
+			}
+			episodes, _ := ts.sonarr.GetEpisodes(context.Background(), series.ID)
+			episodes[1].HasFile = tt.s01e02HasFile
+			ts.sonarr.Catalog(series, episodes)
+
+			tt.req.TMDBID, tt.req.MediaType = 1396, "tv"
+			ids := ts.addRequests(t, tt.req)
+			ts.do((*server).handleSonarrWebhook, postWebhook("/webhook/sonarr", sonarrEvent("Download", 1396, [2]int{2, 1}, [2]int{2, 2})))
+			if got := ts.statuses(t, ids); got[0] != tt.want {
+				t.Errorf("status = %s, want %s", got[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestSonarrWebhookFindsShowByTVDB(t *testing.T) {
+	ts := newTestServer(t)
+	ts.config.WebhookToken = testWebhookToken
+	ts.addBreakingBad(true)
+	ids := ts.addRequests(t, store.Request{TMDBID: 1396, MediaType: "tv", RequestType: "episode", SeasonNumber: 2, EpisodeNumber: 1})
+
+	// Sonarr v3 sends no TMDB ID, and TMDB does not know the show yet.
+	if updated := decodeUpdated(t, ts.do((*server).handleSonarrWebhook, postWebhook("/webhook/sonarr", sonarrEvent("Grab", 0, [2]int{2, 1})))); len(updated) != 0 {
+		t.Errorf("updated %v for a show unknown to TMDB", updated)
+	}
+	ts.tmdb.AddShow(tmdb.TVShowDetails{ID: 1396, Name: "Breaking Bad"}, 81189)
+	if updated := decodeUpdated(t, ts.do((*server).handleSonarrWebhook, postWebhook("/webhook/sonarr", sonarrEvent("Grab", 0, [2]int{2, 1})))); !slices.Equal(updated, ids) {
+		t.Errorf("updated %v, want %v", updated, ids)
+	}
+}
+
+func TestSonarrWebhookServer(t *testing.T) {
+	ts := newTestServer(t)
+	ts.config.WebhookToken = testWebhookToken
+	ts.addSonarr(InstanceConfig{Name: "anime"})
+	ts.addBreakingBad(true)
+	req := store.Request{TMDBID: 1396, MediaType: "tv", RequestType: "full_show"}
+	onAnime := req
+	onAnime.Server = "anime"
+	ids := ts.addRequests(t, req, onAnime)
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		want       []string
+	}{
+		{"/webhook/sonarr?server=Anime", http.StatusOK, []string{"submitted", "downloading"}},
+		{"/webhook/sonarr", http.StatusOK, []string{"downloading", "downloading"}},
+		{"/webhook/sonarr?server=4k", http.StatusBadRequest, []string{"downloading", "downloading"}},
+	}
+	for _, tt := range tests {
+		w := ts.do((*server).handleSonarrWebhook, postWebhook(tt.target, sonarrEvent("Grab", 1396, [2]int{1, 1})))
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d: %s", tt.target, w.Code, tt.wantStatus, w.Body)
+		}
+		if got := ts.statuses(t, ids); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: statuses = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}